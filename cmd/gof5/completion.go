@@ -0,0 +1,180 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/kayrus/gof5/pkg/config"
+	"github.com/kayrus/gof5/pkg/cookie"
+)
+
+// commands is a list of gof5 commands, offered by the completion
+var commands = []string{"stop", "status", "install", "uninstall", "install-agent", "uninstall-agent", "register-handler", "set-password", "check-config", "list-profiles", "restore-dns", "completion"}
+
+// fileFlags are completed with file names
+var fileFlags = []string{"config", "ca-cert", "cert", "key", "password-file", "log-file", "pid-file"}
+
+type completionFlag struct {
+	name  string
+	usage string
+	bool  bool
+	file  bool
+}
+
+func completionFlags() []completionFlag {
+	var flags []completionFlag
+	flag.VisitAll(func(f *flag.Flag) {
+		v := completionFlag{
+			name:  f.Name,
+			usage: f.Usage,
+		}
+		if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok {
+			v.bool = b.IsBoolFlag()
+		}
+		for _, name := range fileFlags {
+			if name == f.Name {
+				v.file = true
+			}
+		}
+		flags = append(flags, v)
+	})
+	return flags
+}
+
+// printServers prints the servers with saved cookies, used by the completion
+// scripts
+func printServers() error {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return err
+	}
+	for _, v := range cookie.Servers(config.Dir(home)) {
+		fmt.Println(v)
+	}
+	return nil
+}
+
+func printCompletion(shell string) error {
+	switch shell {
+	case "bash":
+		fmt.Print(bashCompletion(completionFlags()))
+	case "zsh":
+		fmt.Print(zshCompletion(completionFlags()))
+	case "fish":
+		fmt.Print(fishCompletion(completionFlags()))
+	case "servers":
+		return printServers()
+	default:
+		return fmt.Errorf("unsupported %q shell, supported shells are: bash, zsh, fish", shell)
+	}
+	return nil
+}
+
+func bashCompletion(flags []completionFlag) string {
+	var all, files, values []string
+	for _, f := range flags {
+		all = append(all, "--"+f.name)
+		switch {
+		case f.name == "server", f.bool:
+		case f.file:
+			files = append(files, "--"+f.name, "-"+f.name)
+		default:
+			values = append(values, "--"+f.name, "-"+f.name)
+		}
+	}
+
+	return fmt.Sprintf(`_gof5() {
+	local cur="${COMP_WORDS[COMP_CWORD]}"
+	local prev="${COMP_WORDS[COMP_CWORD-1]}"
+	case "$prev" in
+	--server|-server)
+		COMPREPLY=($(compgen -W "$(gof5 completion servers 2>/dev/null)" -- "$cur"))
+		return
+		;;
+	%s)
+		COMPREPLY=($(compgen -f -- "$cur"))
+		return
+		;;
+	%s)
+		return
+		;;
+	completion)
+		COMPREPLY=($(compgen -W "bash zsh fish" -- "$cur"))
+		return
+		;;
+	esac
+	if [[ "$cur" == -* ]]; then
+		COMPREPLY=($(compgen -W "%s" -- "$cur"))
+		return
+	fi
+	COMPREPLY=($(compgen -W "%s" -- "$cur"))
+}
+complete -F _gof5 gof5
+`, strings.Join(files, "|"), strings.Join(values, "|"), strings.Join(all, " "), strings.Join(commands, " "))
+}
+
+func zshCompletion(flags []completionFlag) string {
+	escape := strings.NewReplacer("'", `'\''`, "[", `\[`, "]", `\]`)
+
+	var args string
+	for _, f := range flags {
+		spec := "--" + f.name
+		if f.usage != "" {
+			spec += "[" + escape.Replace(f.usage) + "]"
+		}
+		switch {
+		case f.name == "server":
+			spec += ":server:_gof5_servers"
+		case f.bool:
+		case f.file:
+			spec += ":file:_files"
+		default:
+			spec += ":value: "
+		}
+		args += fmt.Sprintf("\t\t'%s' \\\n", spec)
+	}
+
+	return fmt.Sprintf(`#compdef gof5
+
+_gof5_servers() {
+	local -a servers
+	servers=(${(f)"$(gof5 completion servers 2>/dev/null)"})
+	compadd -a servers
+}
+
+_gof5() {
+	_arguments \
+%s		'1:command:(%s)' \
+		'2:shell:(bash zsh fish)'
+}
+
+compdef _gof5 gof5
+`, args, strings.Join(commands, " "))
+}
+
+func fishCompletion(flags []completionFlag) string {
+	escape := strings.NewReplacer(`\`, `\\`, "'", `\'`)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "complete -c gof5 -f -n '__fish_use_subcommand' -a '%s'\n", strings.Join(commands, " "))
+	b.WriteString("complete -c gof5 -f -n '__fish_seen_subcommand_from completion' -a 'bash zsh fish'\n")
+	for _, f := range flags {
+		fmt.Fprintf(&b, "complete -c gof5 -l %s", f.name)
+		if f.usage != "" {
+			fmt.Fprintf(&b, " -d '%s'", escape.Replace(f.usage))
+		}
+		switch {
+		case f.name == "server":
+			b.WriteString(" -x -a '(gof5 completion servers 2>/dev/null)'")
+		case f.bool:
+		case f.file:
+			b.WriteString(" -r -F")
+		default:
+			b.WriteString(" -x")
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
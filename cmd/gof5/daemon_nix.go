@@ -0,0 +1,74 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/kayrus/gof5/pkg/util"
+
+	"golang.org/x/sys/unix"
+)
+
+func daemonize(logFilePath string, mode os.FileMode) (*os.File, error) {
+	// Create log directory if needed
+	dir := filepath.Dir(logFilePath)
+	if err := os.MkdirAll(dir, util.DirMode(mode)); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+
+	// Open log file in the parent (before forking)
+	logFile, err := util.OpenFile(logFilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, mode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log file: %w", err)
+	}
+
+	// Fork the process, the password is passed in the environment
+	cmd := exec.Command(os.Args[0], removeFlag(os.Args[1:], "password")...)
+	cmd.Stdin = nil
+	cmd.Stdout = nil
+	cmd.Stderr = logFile
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setsid: true,
+	}
+
+	if err := cmd.Start(); err != nil {
+		logFile.Close()
+		return nil, fmt.Errorf("failed to start daemon process: %w", err)
+	}
+
+	// Parent process closes its copy of the log file and exits
+	logFile.Close()
+	os.Exit(0)
+	return nil, nil
+}
+
+// removeFlag removes the flag and its value from the arguments
+func removeFlag(args []string, name string) []string {
+	var res []string
+	for i := 0; i < len(args); i++ {
+		if args[i] == "--" {
+			return append(res, args[i:]...)
+		}
+		v := strings.SplitN(strings.TrimLeft(args[i], "-"), "=", 2)
+		if v[0] != name || !strings.HasPrefix(args[i], "-") {
+			res = append(res, args[i])
+			continue
+		}
+		if len(v) == 1 {
+			// skip the value
+			i++
+		}
+	}
+	return res
+}
+
+func redirectStderr(f *os.File) {
+	unix.Dup2(int(f.Fd()), int(os.Stderr.Fd()))
+}
@@ -0,0 +1,12 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func daemonize(logFilePath string, mode os.FileMode) (*os.File, error) {
+	return nil, fmt.Errorf("daemon mode is not supported on Windows, use the install command to run gof5 as a service")
+}
+
+func redirectStderr(f *os.File) {}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const desktopEntry = `[Desktop Entry]
+Type=Application
+Name=gof5
+Comment=F5 VPN client
+Exec=%s %%u
+Terminal=true
+NoDisplay=true
+MimeType=x-scheme-handler/f5-vpn;
+`
+
+// desktopQuote quotes the argument according to the desktop entry
+// specification
+func desktopQuote(s string) string {
+	s = strings.ReplaceAll(s, "%", "%%")
+	if !strings.ContainsAny(s, " \t\n\"'\\><~|&;$*?#()`") {
+		return s
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "`", "\\`", "$", `\$`).Replace(s) + `"`
+}
+
+// registerHandler registers gof5 as an "f5-vpn" URL scheme handler using the
+// xdg desktop entry
+func registerHandler(args []string) error {
+	args, err := filterArgs(args)
+	if err != nil {
+		return err
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to detect executable path: %s", err)
+	}
+
+	var cmd []string
+	for _, v := range append([]string{exe}, args...) {
+		cmd = append(cmd, desktopQuote(v))
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(home, ".local", "share", "applications")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create %q directory: %s", dir, err)
+	}
+
+	path := filepath.Join(dir, "gof5.desktop")
+	if err := os.WriteFile(path, []byte(fmt.Sprintf(desktopEntry, strings.Join(cmd, " "))), 0644); err != nil {
+		return fmt.Errorf("failed to write desktop entry: %s", err)
+	}
+	log.Printf("Created %q desktop entry", path)
+
+	if out, err := exec.Command("xdg-mime", "default", "gof5.desktop", "x-scheme-handler/f5-vpn").CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to register f5-vpn URL handler: %s: %s", err, out)
+	}
+
+	return nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !windows
+// +build !linux,!windows
+
+package main
+
+import (
+	"fmt"
+)
+
+// registerHandler is not supported, since macOS delivers URLs to app bundles
+// via Apple Events instead of command line arguments
+func registerHandler(args []string) error {
+	return fmt.Errorf("f5-vpn URL handler registration is supported only on Linux and Windows")
+}
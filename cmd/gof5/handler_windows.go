@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/windows/registry"
+)
+
+// registerHandler registers gof5 as an "f5-vpn" URL scheme handler in the
+// current user registry
+func registerHandler(args []string) error {
+	args, err := filterArgs(args)
+	if err != nil {
+		return err
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to detect executable path: %s", err)
+	}
+
+	var cmd []string
+	for _, v := range append([]string{exe}, args...) {
+		cmd = append(cmd, syscall.EscapeArg(v))
+	}
+	cmd = append(cmd, `"%1"`)
+
+	k, _, err := registry.CreateKey(registry.CURRENT_USER, `Software\Classes\f5-vpn`, registry.SET_VALUE)
+	if err != nil {
+		return fmt.Errorf("failed to create registry key: %s", err)
+	}
+	defer k.Close()
+	if err = k.SetStringValue("", "URL:f5-vpn Protocol"); err != nil {
+		return fmt.Errorf("failed to set registry value: %s", err)
+	}
+	if err = k.SetStringValue("URL Protocol", ""); err != nil {
+		return fmt.Errorf("failed to set registry value: %s", err)
+	}
+
+	c, _, err := registry.CreateKey(k, `shell\open\command`, registry.SET_VALUE)
+	if err != nil {
+		return fmt.Errorf("failed to create registry key: %s", err)
+	}
+	defer c.Close()
+	if err = c.SetStringValue("", strings.Join(cmd, " ")); err != nil {
+		return fmt.Errorf("failed to set registry value: %s", err)
+	}
+	log.Printf("Registered f5-vpn URL handler")
+
+	return nil
+}
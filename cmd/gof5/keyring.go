@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/kayrus/gof5/pkg/client"
+
+	"github.com/zalando/go-keyring"
+	"golang.org/x/term"
+)
+
+const keyringService = "gof5"
+
+// keyringUser returns the "<username>@<server>" keyring entry name
+func keyringUser(opts *client.Options) (string, error) {
+	if opts.Username == "" || opts.Server == "" {
+		return "", fmt.Errorf("--username and --server are required to use the keyring")
+	}
+
+	server := opts.Server
+	if u, err := url.Parse(server); err == nil && u.Host != "" {
+		server = u.Host
+	}
+
+	return opts.Username + "@" + server, nil
+}
+
+// readKeyringPassword returns the password from the OS secret store, an
+// empty password is returned, when the entry doesn't exist
+func readKeyringPassword(opts *client.Options) (string, error) {
+	user, err := keyringUser(opts)
+	if err != nil {
+		return "", err
+	}
+
+	password, err := keyring.Get(keyringService, user)
+	if errors.Is(err, keyring.ErrNotFound) {
+		log.Printf("Password for %q is not found in the keyring", user)
+		if os.Getenv("SUDO_USER") != "" {
+			log.Printf("The root keyring is used under sudo, read the %s keyring before sudo and pass the password with --password-stdin", os.Getenv("SUDO_USER"))
+		}
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read password from the keyring: %s", err)
+	}
+
+	return password, nil
+}
+
+// setKeyringPassword stores the password, read from the terminal or stdin,
+// in the OS secret store
+func setKeyringPassword(opts *client.Options) error {
+	user, err := keyringUser(opts)
+	if err != nil {
+		return err
+	}
+
+	password := opts.Password
+	if password == "" {
+		if term.IsTerminal(int(os.Stdin.Fd())) {
+			if password, err = readPassword(); err != nil {
+				return err
+			}
+		} else {
+			v, err := bufio.NewReader(os.Stdin).ReadString('\n')
+			if err != nil && v == "" {
+				return fmt.Errorf("failed to read password from stdin: %s", err)
+			}
+			password = strings.TrimRight(v, "\r\n")
+		}
+	}
+	if password == "" {
+		return fmt.Errorf("password cannot be empty")
+	}
+
+	if err := keyring.Set(keyringService, user, password); err != nil {
+		return fmt.Errorf("failed to store password in the keyring: %s", err)
+	}
+	log.Printf("Password for %q is stored in the keyring", user)
+
+	return nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+const launchdLabel = "com.gof5.vpn"
+
+const plistTemplate = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>%s</string>
+	<key>ProgramArguments</key>
+	<array>
+%s	</array>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>StandardOutPath</key>
+	<string>%s</string>
+	<key>StandardErrorPath</key>
+	<string>%s</string>
+</dict>
+</plist>
+`
+
+// secretFlags must not be stored in the plist or the URL handler command
+var secretFlags = []string{"password", "token", "next-token", "key-passphrase", "pkcs12-password"}
+
+func xmlEscape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// filterArgs removes the flags with secrets from the arguments
+func filterArgs(args []string) ([]string, error) {
+	var res []string
+	for i := 0; i < len(args); i++ {
+		name := strings.TrimLeft(args[i], "-")
+		if name == args[i] {
+			res = append(res, args[i])
+			continue
+		}
+		v := strings.SplitN(name, "=", 2)
+		for _, f := range secretFlags {
+			if v[0] == f {
+				return nil, fmt.Errorf("--%s flag cannot be stored, use a password file or an environment variable instead", f)
+			}
+		}
+		res = append(res, args[i])
+	}
+	return res, nil
+}
+
+func launchdPlistPath() (string, error) {
+	if os.Geteuid() == 0 {
+		return filepath.Join("/Library", "LaunchDaemons", launchdLabel+".plist"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, "Library", "LaunchAgents", launchdLabel+".plist"), nil
+}
+
+func installAgent(args []string, logFilePath string) error {
+	if runtime.GOOS != "darwin" {
+		return fmt.Errorf("launchd is supported only on macOS")
+	}
+
+	args, err := filterArgs(args)
+	if err != nil {
+		return err
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to detect executable path: %s", err)
+	}
+
+	var programArgs string
+	for _, v := range append([]string{exe}, args...) {
+		programArgs += fmt.Sprintf("\t\t<string>%s</string>\n", xmlEscape(v))
+	}
+
+	path, err := launchdPlistPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create %q directory: %s", filepath.Dir(path), err)
+	}
+
+	logPath := xmlEscape(logFilePath)
+	plist := fmt.Sprintf(plistTemplate, launchdLabel, programArgs, logPath, logPath)
+	if err := os.WriteFile(path, []byte(plist), 0644); err != nil {
+		return fmt.Errorf("failed to write launchd plist: %s", err)
+	}
+	log.Printf("Created %q launchd plist", path)
+
+	if out, err := exec.Command("launchctl", "load", "-w", path).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to load launchd plist: %s: %s", err, out)
+	}
+
+	return nil
+}
+
+func uninstallAgent() error {
+	if runtime.GOOS != "darwin" {
+		return fmt.Errorf("launchd is supported only on macOS")
+	}
+
+	path, err := launchdPlistPath()
+	if err != nil {
+		return err
+	}
+
+	if out, err := exec.Command("launchctl", "unload", "-w", path).CombinedOutput(); err != nil {
+		log.Printf("Warning: failed to unload launchd plist: %s: %s", err, out)
+	}
+
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("failed to remove launchd plist: %s", err)
+	}
+	log.Printf("Removed %q launchd plist", path)
+
+	return nil
+}
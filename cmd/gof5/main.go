@@ -2,111 +2,369 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"os"
-	"os/exec"
 	"os/user"
 	"path/filepath"
 	"runtime"
 	"strconv"
 	"strings"
 	"syscall"
+	"text/tabwriter"
 	"time"
 
 	"github.com/kayrus/gof5/pkg/client"
 	"github.com/kayrus/gof5/pkg/config"
+	"github.com/kayrus/gof5/pkg/link"
+	"github.com/kayrus/gof5/pkg/util"
+
+	"golang.org/x/term"
+	"gopkg.in/yaml.v2"
+)
+
+const stopTimeout = 30 * time.Second
+
+// exit codes, 2 is used by the flag package on invalid arguments
+const (
+	exitError = 1
+	// a required input is missing in the non-interactive mode
+	exitInputRequired = 3
+	// the F5 server rejected the credentials or session
+	exitAuth = 4
+	// the F5 server is unreachable or cannot be resolved
+	exitNetwork = 5
+	// the config file cannot be read or is invalid
+	exitConfig = 6
+	// elevated privileges are required
+	exitPermission = 7
+	// the tunnel driver is not available
+	exitDriver = 8
 )
 
+// permissionError is returned, when gof5 runs without the required privileges
+type permissionError struct {
+	err error
+}
+
+func (e *permissionError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permissionError) Unwrap() error {
+	return e.err
+}
+
+// listFlag joins the repeated flag values into a comma-separated list
+type listFlag struct {
+	v *string
+}
+
+func (f listFlag) String() string {
+	if f.v == nil {
+		return ""
+	}
+	return *f.v
+}
+
+func (f listFlag) Set(s string) error {
+	if *f.v != "" {
+		*f.v += ","
+	}
+	*f.v += s
+	return nil
+}
+
 var (
 	Version = "dev"
 	info    = fmt.Sprintf("gof5 %s compiled with %s for %s/%s", Version, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	// nonInteractive disables all prompts
+	nonInteractive bool
 )
 
+func exitCode(err error) int {
+	var inputErr client.InputError
+	var authErr client.AuthError
+	var netErr *client.NetworkError
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+	var driverErr *config.DriverError
+	var configErr *config.Error
+	var permErr *permissionError
+	switch {
+	case errors.As(err, &inputErr):
+		return exitInputRequired
+	case errors.As(err, &authErr):
+		return exitAuth
+	case errors.As(err, &driverErr):
+		return exitDriver
+	case errors.As(err, &configErr):
+		return exitConfig
+	case errors.As(err, &permErr):
+		return exitPermission
+	case errors.As(err, &netErr), errors.As(err, &dnsErr),
+		errors.As(err, &opErr) && opErr.Op == "dial":
+		return exitNetwork
+	}
+	return exitError
+}
+
 func fatal(err error) {
-	if runtime.GOOS == "windows" {
+	if runtime.GOOS == "windows" && !nonInteractive {
 		// Escalated privileges in windows opens a new terminal, and if there is an
 		// error, it is impossible to see it. Thus we wait for user to press a button.
 		log.Printf("%s, press enter to exit", err)
 		bufio.NewReader(os.Stdin).ReadBytes('\n')
-		os.Exit(1)
+		os.Exit(exitCode(err))
+	}
+	log.Print(err)
+	os.Exit(exitCode(err))
+}
+
+func writePIDFile(pidPath string, mode os.FileMode) error {
+	// Ensure the directory exists
+	dir := filepath.Dir(pidPath)
+	if err := os.MkdirAll(dir, util.DirMode(mode)); err != nil {
+		return fmt.Errorf("failed to create PID directory: %w", err)
 	}
-	log.Fatal(err)
+
+	// Write the PID to file
+	pid := os.Getpid()
+	if err := util.WriteFile(pidPath, []byte(strconv.Itoa(pid)), mode); err != nil {
+		return fmt.Errorf("failed to write PID file: %w", err)
+	}
+
+	return nil
 }
 
-func daemonize(logFilePath string) (*os.File, error) {
-	if runtime.GOOS == "windows" {
-		return nil, fmt.Errorf("daemon mode is not supported on Windows")
+// runtimeDir returns the directory for the PID, log and state files:
+// $XDG_RUNTIME_DIR/gof5, when the variable is set, and /tmp/gof5 otherwise.
+// Windows and macOS always use /tmp/gof5.
+func runtimeDir() string {
+	if runtime.GOOS != "windows" && runtime.GOOS != "darwin" {
+		if xdg := os.Getenv("XDG_RUNTIME_DIR"); xdg != "" {
+			return filepath.Join(xdg, "gof5")
+		}
 	}
+	return filepath.Join("/tmp", "gof5")
+}
 
-	// Create log directory if needed
-	dir := filepath.Dir(logFilePath)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create log directory: %w", err)
+// checkPIDDir verifies, that the PID file can be written
+func checkPIDDir(pidPath string, mode os.FileMode) error {
+	dir := filepath.Dir(pidPath)
+	if err := os.MkdirAll(dir, util.DirMode(mode)); err != nil {
+		return fmt.Errorf("failed to create PID directory: %w", err)
 	}
+	f, err := os.CreateTemp(dir, ".gof5-*")
+	if err != nil {
+		return fmt.Errorf("PID directory %q is not writable: %w", dir, err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
 
-	// Open log file in the parent (before forking)
-	logFile, err := os.OpenFile(logFilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+// resolvePIDPath returns the absolute PID file path, the --pid-file flag takes
+// precedence over the pidFile config option
+func resolvePIDPath(pidFile string, cfg *config.Config, defaultPath string) (string, error) {
+	if pidFile == "" && cfg != nil {
+		pidFile = cfg.PIDFile
+	}
+	if pidFile == "" {
+		return defaultPath, nil
+	}
+	v, err := filepath.Abs(pidFile)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open log file: %w", err)
+		return "", fmt.Errorf("failed to resolve %q PID file path: %w", pidFile, err)
 	}
+	return v, nil
+}
+
+func removePIDFile(pidPath string) {
+	// the PID directory may be writable only by root
+	util.Privileged(func() {
+		if err := os.Remove(pidPath); err != nil {
+			log.Printf("Warning: failed to remove PID file: %s", err)
+		}
+	})
+}
 
-	// Fork the process
-	cmd := exec.Command(os.Args[0], os.Args[1:]...)
-	cmd.Stdin = nil
-	cmd.Stdout = nil
-	cmd.Stderr = logFile
-	cmd.SysProcAttr = &syscall.SysProcAttr{
-		Setsid: true,
+func readPIDFile(pidPath string) (int, error) {
+	data, err := os.ReadFile(pidPath)
+	if err != nil {
+		return 0, err
 	}
 
-	if err := cmd.Start(); err != nil {
-		logFile.Close()
-		return nil, fmt.Errorf("failed to start daemon process: %w", err)
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, fmt.Errorf("invalid PID file %q: %w", pidPath, err)
 	}
 
-	// Parent process closes its copy of the log file and exits
-	logFile.Close()
-	os.Exit(0)
-	return nil, nil
+	return pid, nil
 }
 
-func writePIDFile(pidPath string) error {
-	// Ensure the directory exists
-	dir := filepath.Dir(pidPath)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return fmt.Errorf("failed to create PID directory: %w", err)
+// printStatus prints the connection details of a running gof5 process and
+// returns an error, when gof5 is not running; the control socket is preferred
+// over the PID and state files
+func printStatus(pidPath, statePath, controlSocket string) error {
+	if _, err := os.Stat(controlSocket); controlSocket != "" && err == nil {
+		status, err := client.ControlGetStatus(controlSocket)
+		if err == nil {
+			if !status.Connected {
+				fmt.Printf("gof5 is running (PID: %d), connection is not established yet\n", status.PID)
+				return nil
+			}
+			fmt.Printf("gof5 is running (PID: %d)\n", status.PID)
+			fmt.Printf("Server:    %s\n", status.Server)
+			fmt.Printf("VPN IP:    %s\n", status.LocalIP)
+			fmt.Printf("Interface: %s\n", status.Interface)
+			fmt.Printf("Uptime:    %s\n", time.Duration(status.Uptime)*time.Second)
+			fmt.Printf("Received:  %d bytes\n", status.BytesIn)
+			fmt.Printf("Sent:      %d bytes\n", status.BytesOut)
+			return nil
+		}
+		log.Printf("Failed to query the control socket, falling back to the PID file: %s", err)
 	}
 
-	// Write the PID to file
-	pid := os.Getpid()
-	if err := os.WriteFile(pidPath, []byte(strconv.Itoa(pid)), 0644); err != nil {
-		return fmt.Errorf("failed to write PID file: %w", err)
+	pid, err := readPIDFile(pidPath)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("gof5 is not running")
+	}
+	if err != nil {
+		return err
+	}
+
+	process, err := os.FindProcess(pid)
+	if err == nil {
+		err = process.Signal(syscall.Signal(0))
+	}
+	if err != nil {
+		return fmt.Errorf("gof5 is not running (stale PID file)")
+	}
+
+	state, err := client.ReadState(statePath)
+	if err != nil || state.PID != pid {
+		fmt.Printf("gof5 is running (PID: %d), connection is not established yet\n", pid)
+		return nil
 	}
 
+	fmt.Printf("gof5 is running (PID: %d)\n", pid)
+	fmt.Printf("Server:    %s\n", state.Server)
+	fmt.Printf("VPN IP:    %s\n", state.LocalIP)
+	fmt.Printf("Interface: %s\n", state.Interface)
+	fmt.Printf("Uptime:    %s\n", time.Since(state.Since).Round(time.Second))
+
 	return nil
 }
 
-func removePIDFile(pidPath string) {
-	if err := os.Remove(pidPath); err != nil {
-		log.Printf("Warning: failed to remove PID file: %s", err)
+// stopDaemon terminates a running gof5 process referenced by the control
+// socket or the PID file and waits until it exits, so the session is closed and
+// the config is restored
+func stopDaemon(pidPath, controlSocket string) error {
+	if _, err := os.Stat(controlSocket); controlSocket != "" && err == nil {
+		err := client.ControlAction(controlSocket, "disconnect")
+		if err == nil {
+			fmt.Println("Stopping gof5...")
+			// the socket is removed on exit
+			for deadline := time.Now().Add(stopTimeout); time.Now().Before(deadline); time.Sleep(100 * time.Millisecond) {
+				if _, err := os.Stat(controlSocket); os.IsNotExist(err) {
+					fmt.Println("gof5 stopped")
+					return nil
+				}
+			}
+			return fmt.Errorf("gof5 didn't stop within %s", stopTimeout)
+		}
+		log.Printf("Failed to use the control socket, falling back to the PID file: %s", err)
+	}
+
+	pid, err := readPIDFile(pidPath)
+	if os.IsNotExist(err) {
+		fmt.Println("gof5 is not running (PID file not found)")
+		return nil
 	}
+	if err != nil {
+		return err
+	}
+
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return fmt.Errorf("failed to find gof5 process (PID: %d): %w", pid, err)
+	}
+
+	if err := process.Signal(syscall.SIGTERM); err != nil {
+		if errors.Is(err, os.ErrProcessDone) || errors.Is(err, syscall.ESRCH) {
+			fmt.Println("gof5 is not running (stale PID file removed)")
+			removePIDFile(pidPath)
+			return nil
+		}
+		return fmt.Errorf("failed to stop gof5 (PID: %d): %w", pid, err)
+	}
+
+	fmt.Printf("Stopping gof5 (PID: %d)...\n", pid)
+	for deadline := time.Now().Add(stopTimeout); time.Now().Before(deadline); time.Sleep(100 * time.Millisecond) {
+		if err := process.Signal(syscall.Signal(0)); err != nil {
+			fmt.Println("gof5 stopped")
+			// the process removes its PID file on exit, cleanup leftovers
+			if _, err := os.Stat(pidPath); err == nil {
+				removePIDFile(pidPath)
+			}
+			return nil
+		}
+	}
+
+	return fmt.Errorf("gof5 (PID: %d) didn't stop within %s", pid, stopTimeout)
+}
+
+func readPassword() (string, error) {
+	fmt.Fprint(os.Stderr, "Password: ")
+	password, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("failed to read password: %w", err)
+	}
+	return string(password), nil
 }
 
 func main() {
+	// the requests to the F5 server carry the binary version
+	config.DefaultUserAgent = "gof5/" + Version
+
 	var version bool
 	var passwordFile string
 	var removePassFile bool
 	var logFilePath string
+	var pidFile string
+	var reconnect bool
+	var noRoutes bool
+	var netNS string
+	var instance string
+	var httpTimeout time.Duration
+	var maxDuration time.Duration
+	var proxy string
+	var useSyslog bool
+	var serviceMode bool
+	var printConfig bool
+	var dryRun bool
+	var debugUnsafe bool
+	var trace bool
+	var userAgent string
+	var listJSON bool
+	var useKeyring bool
+	var passwordStdin bool
 	var opts client.Options
+	opts.Signals = make(chan os.Signal, 1)
 
 	// Check if we're the daemon child process
 	if os.Getenv("__GOF5_DAEMONIZED") == "1" {
 		// Child process: read password from env var
 		opts.Password = os.Getenv("__GOF5_PASSWORD")
 		// Clear for security
-		os.Setenv("__GOF5_PASSWORD", "")
+		os.Unsetenv("__GOF5_PASSWORD")
 		// Clear passwordFile to prevent trying to read it again
 		passwordFile = ""
 	}
@@ -114,50 +372,341 @@ func main() {
 	flag.StringVar(&opts.Server, "server", "", "")
 	flag.StringVar(&opts.Username, "username", "", "")
 	flag.StringVar(&opts.Password, "password", "", "")
+	flag.StringVar(&opts.Token, "token", "", "One-time token for MFA logons")
+	flag.StringVar(&opts.NextToken, "next-token", "", "Next RSA SecurID token code, when the F5 server reports an out of sync token")
+	flag.StringVar(&opts.MFAMethod, "mfa-method", "", "Duo second factor method: push, phone, sms or passcode:<code>")
 	flag.StringVar(&passwordFile, "password-file", "", "Path to file containing password")
+	flag.BoolVar(&passwordStdin, "password-stdin", false, "Read password from stdin, disables the prompts")
 	flag.BoolVar(&removePassFile, "remove-password-file", false, "Delete password file immediately after reading")
+	flag.BoolVar(&useKeyring, "use-keyring", false, "Read password from the OS keyring, see the set-password command")
 	flag.StringVar(&opts.SessionID, "session", "", "Reuse a session ID")
-	flag.StringVar(&opts.CACert, "ca-cert", "", "Path to a custom CA certificate")
+	flag.Var(listFlag{&opts.CACert}, "ca-cert", "Path to a custom CA certificate or bundle, can be repeated or comma-separated")
+	flag.BoolVar(&opts.SystemCA, "system-ca", false, "Trust the system CA certificates together with the --ca-cert")
 	flag.StringVar(&opts.Cert, "cert", "", "Path to a user TLS certificate")
 	flag.StringVar(&opts.Key, "key", "", "Path to a user TLS key")
-	flag.StringVar(&opts.ConfigPath, "config", "", "Path to config file (default: ~/.gof5/config.yaml)")
+	flag.StringVar(&opts.KeyPassphrase, "key-passphrase", "", "Passphrase of the encrypted user TLS key")
+	flag.StringVar(&opts.PKCS12, "pkcs12", "", "Path to a PKCS#12 bundle with a user TLS certificate and key")
+	flag.StringVar(&opts.PKCS12Password, "pkcs12-password", "", "Password of the PKCS#12 bundle")
+	flag.StringVar(&opts.ConfigPath, "config", "", "Path to YAML or JSON config file (default: ~/.gof5/config.yaml or $XDG_CONFIG_HOME/gof5/config.yaml)")
+	flag.BoolVar(&opts.RequireConfig, "require-config", false, "Fail, when the config file is missing, instead of using the defaults")
+	flag.BoolVar(&opts.StrictPerms, "strict-perms", false, "Refuse the config, certificate and key files, accessible by group or others")
+	flag.StringVar(&opts.Profile, "profile", "", "Name of the server profile in the config file")
+	flag.StringVar(&instance, "instance", "", "Name of the instance, which separates the PID, log and state files, the interface and the DNS listener of simultaneous gof5 processes (default: --profile value)")
 	flag.BoolVar(&opts.CloseSession, "close-session", false, "Close HTTPS VPN session on exit")
+	flag.BoolVar(&opts.NoCookieCache, "no-cookie-cache", false, "Neither reuse nor save HTTPS VPN session cookies")
 	flag.BoolVar(&opts.Debug, "debug", false, "Show debug logs")
+	flag.BoolVar(&debugUnsafe, "debug-unsafe", false, "Show debug logs without redacting the credentials and cookies, implies --debug")
+	flag.BoolVar(&trace, "trace", false, "Log the duration of every connection phase and a summary table, when the tunnel is established")
+	flag.BoolVar(&nonInteractive, "non-interactive", false, "Never prompt, fail with the exit code 3, when a required input is missing")
+	flag.BoolVar(&reconnect, "reconnect", false, "Reconnect with exponential backoff, when the tunnel drops")
+	flag.StringVar(&netNS, "netns", "", "Move the tunnel interface into the named Linux network namespace, overrides the netns config option")
+	flag.BoolVar(&noRoutes, "no-routes", false, "Configure only the tunnel interface, log the routes and DNS servers instead of installing them")
+	flag.DurationVar(&httpTimeout, "timeout", 0, "Override both dialTimeout and requestTimeout config options")
+	flag.DurationVar(&maxDuration, "max-duration", 0, "Disconnect and exit after the duration, including the logon and reconnects, regardless of the activity")
+	flag.StringVar(&userAgent, "user-agent", "", "User-Agent of the requests to the F5 server, overrides the userAgent config option")
+	flag.StringVar(&proxy, "proxy", "", "Proxy URL for the logon HTTPS requests, overrides the proxy config option")
 	flag.BoolVar(&opts.Sel, "select", false, "Select a server from available F5 servers")
+	flag.IntVar(&opts.ServerIndex, "server-index", 0, "With --select choose server n (starting from 1) without the menu")
 	flag.IntVar(&opts.ProfileIndex, "profile-index", 0, "If multiple VPN profiles are found chose profile n")
+	flag.StringVar(&opts.ProfileName, "profile-name", "", "If multiple VPN profiles are found choose the profile by name, takes precedence over --profile-index")
 	flag.BoolVar(&version, "version", false, "Show version and exit cleanly")
-	flag.StringVar(&logFilePath, "log-file", "", "Path to log file for daemon mode (default: /tmp/gof5/<username>.log)")
+	flag.BoolVar(&printConfig, "print-config", false, "Print the effective config and exit")
+	flag.BoolVar(&listJSON, "json", false, "Print the list-profiles output as JSON")
+	flag.BoolVar(&dryRun, "dry-run", false, "Log in, print the VPN profile pushed by F5 and exit without configuring the system")
+	flag.StringVar(&pidFile, "pid-file", "", "Path to PID file (default: $XDG_RUNTIME_DIR/gof5/<username>.pid or /tmp/gof5/<username>.pid)")
+	flag.StringVar(&logFilePath, "log-file", "", "Path to log file for daemon mode (default: $XDG_RUNTIME_DIR/gof5/<username>.log or /tmp/gof5/<username>.log)")
+	flag.BoolVar(&useSyslog, "syslog", false, "Send logs to the local syslog")
+	flag.BoolVar(&serviceMode, "service", false, "Run under the Windows Service Control Manager, set by the install command")
 
 	flag.Parse()
+	opts.NonInteractive = nonInteractive
+	if debugUnsafe {
+		opts.Debug = true
+	}
+	if trace {
+		util.EnableTrace()
+	}
+
+	if os.Getenv("__GOF5_DAEMONIZED") != "1" {
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "password" {
+				log.Printf("Warning: --password is visible in the process list, use --password-stdin, --password-file or GOF5_PASSWORD environment variable instead")
+			}
+		})
+	}
+
+	// the daemon child has the password in the environment
+	if passwordStdin && os.Getenv("__GOF5_DAEMONIZED") != "1" {
+		if opts.Password != "" || passwordFile != "" {
+			fatal(fmt.Errorf("--password-stdin cannot be used together with --password or --password-file"))
+		}
+		// stdin is consumed by the password, nothing can be prompted
+		opts.NonInteractive = true
+	} else {
+		passwordStdin = false
+	}
 
 	if version {
 		fmt.Println(info)
 		os.Exit(0)
 	}
 
-	if opts.ProfileIndex < 0 {
-		fatal(fmt.Errorf("profile-index cannot be negative"))
+	// Get current user for PID/log file paths
+	usr, err := user.Current()
+	if err != nil {
+		fatal(fmt.Errorf("failed to get current user: %w", err))
 	}
 
-	if err := checkPermissions(); err != nil {
+	// simultaneous gof5 processes use separate files
+	if instance == "" {
+		instance = opts.Profile
+	}
+	if err := config.CheckInstance(instance); err != nil {
 		fatal(err)
 	}
+	baseName := usr.Username
+	if instance != "" {
+		baseName += "-" + instance
+	}
 
-	if flag.NArg() > 0 {
-		if err := client.UrlHandlerF5Vpn(&opts, flag.Arg(0)); err != nil {
+	// Set up PID and state file paths
+	runDir := runtimeDir()
+	defaultPIDPath := filepath.Join(runDir, baseName+".pid")
+	opts.StatePath = filepath.Join(runDir, baseName+".json")
+
+	switch flag.Arg(0) {
+	case "completion":
+		if err := printCompletion(flag.Arg(1)); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	case "register-handler":
+		// register the handler with the flags, preceding the command
+		if err := registerHandler(os.Args[1 : len(os.Args)-flag.NArg()]); err != nil {
+			fatal(err)
+		}
+		os.Exit(0)
+	case "set-password":
+		if err := setKeyringPassword(&opts); err != nil {
+			fatal(err)
+		}
+		os.Exit(0)
+	case "check-config":
+		errs := config.CheckConfig(opts.ConfigPath)
+		if err := client.CheckTLSFiles(&opts); err != nil {
+			errs = append(errs, err)
+		}
+		if len(errs) > 0 {
+			for _, err := range errs {
+				fmt.Println(err)
+			}
+			os.Exit(1)
+		}
+		fmt.Println("config OK")
+		os.Exit(0)
+	case "stop", "status":
+		// a broken config must not prevent stopping a running process
+		cfg, err := config.ReadConfig(opts.Debug, opts.ConfigPath, opts.Profile, opts.RequireConfig, opts.StrictPerms)
+		if err != nil {
+			cfg = nil
+		}
+		pidPath, err := resolvePIDPath(pidFile, cfg, defaultPIDPath)
+		if err != nil {
+			fatal(err)
+		}
+		var controlSocket string
+		if cfg != nil {
+			controlSocket = cfg.ControlSocket
+		}
+		if flag.Arg(0) == "status" {
+			if err := printStatus(pidPath, opts.StatePath, controlSocket); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			os.Exit(0)
+		}
+		if err := stopDaemon(pidPath, controlSocket); err != nil {
+			fatal(err)
+		}
+		os.Exit(0)
+	case "restore-dns":
+		cfg, err := config.ReadConfig(opts.Debug, opts.ConfigPath, opts.Profile, opts.RequireConfig, opts.StrictPerms)
+		if err != nil {
+			fatal(err)
+		}
+		cfg.SetInstance(instance)
+		if err := checkPermissions(); err != nil {
+			fatal(&permissionError{err})
+		}
+		if err := link.RestoreResolvConf(cfg); err != nil {
 			fatal(err)
 		}
+		fmt.Println("DNS settings restored")
+		os.Exit(0)
+	case "install":
+		// register the service with the flags, preceding the command
+		if err := installService(os.Args[1 : len(os.Args)-flag.NArg()]); err != nil {
+			fatal(err)
+		}
+		os.Exit(0)
+	case "uninstall":
+		if err := uninstallService(); err != nil {
+			fatal(err)
+		}
+		os.Exit(0)
+	case "install-agent":
+		if logFilePath == "" {
+			logFilePath = filepath.Join(runDir, baseName+".log")
+		}
+		// register the launchd agent with the flags, preceding the command
+		if err := installAgent(os.Args[1:len(os.Args)-flag.NArg()], logFilePath); err != nil {
+			fatal(err)
+		}
+		os.Exit(0)
+	case "uninstall-agent":
+		if err := uninstallAgent(); err != nil {
+			fatal(err)
+		}
+		os.Exit(0)
+	}
+
+	// list-profiles logs in like a connection, but exits before the tunnel
+	listProfiles := flag.Arg(0) == "list-profiles"
+	if listProfiles {
+		// the flags may follow the subcommand, e.g. "list-profiles --json"
+		flag.CommandLine.Parse(flag.Args()[1:])
+		if flag.NArg() > 0 {
+			fatal(fmt.Errorf("unexpected %q list-profiles arguments", flag.Args()))
+		}
+	}
+
+	if opts.ProfileIndex < 0 {
+		fatal(fmt.Errorf("profile-index cannot be negative"))
+	}
+
+	if opts.ServerIndex < 0 {
+		fatal(fmt.Errorf("server-index cannot be negative"))
+	}
+
+	if maxDuration < 0 {
+		fatal(fmt.Errorf("max-duration cannot be negative"))
 	}
 
 	// Read config before daemonizing so we can check the daemon flag
-	cfg, err := config.ReadConfig(opts.Debug, opts.ConfigPath)
+	cfg, err := config.ReadConfig(opts.Debug, opts.ConfigPath, opts.Profile, opts.RequireConfig, opts.StrictPerms)
 	if err != nil {
 		fatal(err)
 	}
 	opts.Config = *cfg
+	opts.Config.SetInstance(instance)
+	if err := util.SetLogFormat(cfg.LogFormat); err != nil {
+		fatal(err)
+	}
+	opts.Config.DebugUnsafe = debugUnsafe
+	if userAgent != "" {
+		opts.Config.UserAgent = userAgent
+	}
+	if reconnect {
+		opts.Config.Reconnect = true
+	}
+	if useSyslog {
+		opts.Config.Syslog = true
+	}
+	if netNS != "" {
+		opts.Config.NetNS = netNS
+		if err := config.CheckNetNS(&opts.Config); err != nil {
+			fatal(&config.Error{Err: err})
+		}
+	}
+	if err := config.CheckDropPrivileges(&opts.Config); err != nil {
+		// the flags may conflict with the option
+		fatal(&config.Error{Err: err})
+	}
+	if noRoutes {
+		if opts.Config.KillSwitch {
+			fatal(&config.Error{Err: fmt.Errorf("killSwitch option cannot be used with --no-routes")})
+		}
+		v := false
+		opts.Config.ManageRoutes = &v
+	}
+	if httpTimeout > 0 {
+		opts.Config.DialTimeout = httpTimeout
+		opts.Config.RequestTimeout = httpTimeout
+	}
+	if proxy != "" {
+		if err := config.CheckProxy(proxy); err != nil {
+			fatal(&config.Error{Err: err})
+		}
+		opts.Config.Proxy = proxy
+	}
+	// flags take precedence over the config and profile options
+	if opts.Server == "" {
+		opts.Server = opts.Config.Server
+	}
+	if opts.Username == "" {
+		opts.Username = opts.Config.Username
+	}
+	applyConnection(&opts)
+
+	pidPath, err := resolvePIDPath(pidFile, cfg, defaultPIDPath)
+	if err != nil {
+		fatal(err)
+	}
+	if !printConfig && !dryRun && !listProfiles {
+		if err := checkPIDDir(pidPath, opts.Config.FileMode); err != nil {
+			fatal(err)
+		}
+	}
+
+	if dryRun {
+		opts.DryRun = true
+		// the dry run exits after printing the profile
+		opts.Config.Daemon = false
+		opts.Config.Reconnect = false
+	}
+
+	// printing the config, the dry run and the netstack driver don't require
+	// elevated permissions
+	if !printConfig && !dryRun && !listProfiles && opts.Driver != "netstack" {
+		if err := checkPermissions(); err != nil {
+			fatal(&permissionError{err})
+		}
+	}
+
+	if flag.NArg() > 0 && !listProfiles {
+		if err := client.UrlHandlerF5Vpn(&opts, flag.Arg(0)); err != nil {
+			fatal(err)
+		}
+	}
+
+	if printConfig {
+		v, err := yaml.Marshal(&opts.Config)
+		if err != nil {
+			fatal(fmt.Errorf("failed to marshal config: %s", err))
+		}
+		fmt.Print(string(v))
+		os.Exit(0)
+	}
 
-	// Load password from file or environment variable if not provided via flag
+	// Load password from keyring, file or environment variable if not provided via flag
 	// Skip if already set from daemon env var
+	if passwordStdin {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fatal(fmt.Errorf("failed to read password from stdin: %s", err))
+		}
+		opts.Password = strings.TrimSuffix(strings.TrimSuffix(string(data), "\n"), "\r")
+		if opts.Password == "" {
+			fatal(client.InputError("password from stdin is empty"))
+		}
+	}
+	if opts.Password == "" && useKeyring {
+		opts.Password, err = readKeyringPassword(&opts)
+		if err != nil {
+			log.Printf("Warning: %s", err)
+		}
+	}
 	if opts.Password == "" {
 		if passwordFile != "" {
 			data, err := os.ReadFile(passwordFile)
@@ -169,30 +718,98 @@ func main() {
 			opts.Password = envPassword
 		}
 	}
+	// don't leak the password into the child processes, e.g. scripts and pppd
+	os.Unsetenv("GOF5_PASSWORD")
 
-	// Get current user for PID/log file paths
-	usr, err := user.Current()
-	if err != nil {
-		fatal(fmt.Errorf("failed to get current user: %w", err))
+	if opts.Token == "" {
+		opts.Token = os.Getenv("GOF5_TOKEN")
 	}
+	os.Unsetenv("GOF5_TOKEN")
 
-	// Set up PID file path
-	pidPath := filepath.Join("/tmp", "gof5", usr.Username+".pid")
+	if err := client.CheckMFAMethod(opts.MFAMethod); err != nil {
+		fatal(err)
+	}
+
+	if opts.KeyPassphrase == "" {
+		opts.KeyPassphrase = os.Getenv("GOF5_KEY_PASSPHRASE")
+	}
+	os.Unsetenv("GOF5_KEY_PASSPHRASE")
+
+	if opts.PKCS12Password == "" {
+		opts.PKCS12Password = os.Getenv("GOF5_PKCS12_PASSWORD")
+	}
+	os.Unsetenv("GOF5_PKCS12_PASSWORD")
+
+	// the PEM values keep the certificates and keys off the disk, the file
+	// flags take precedence; like the password they are not passed to the
+	// child processes
+	opts.CACertPEM = os.Getenv("GOF5_CA_CERT_PEM")
+	opts.CertPEM = os.Getenv("GOF5_CLIENT_CERT_PEM")
+	opts.KeyPEM = os.Getenv("GOF5_CLIENT_KEY_PEM")
+	for _, v := range []string{"GOF5_CA_CERT_PEM", "GOF5_CLIENT_CERT_PEM", "GOF5_CLIENT_KEY_PEM"} {
+		os.Unsetenv(v)
+	}
+
+	// Ask for the password interactively, when stdin is a terminal
+	// The daemonized child has no TTY, thus never prompt there
+	if opts.Password == "" && opts.SessionID == "" && !nonInteractive && os.Getenv("__GOF5_DAEMONIZED") != "1" && term.IsTerminal(int(os.Stdin.Fd())) {
+		opts.Password, err = readPassword()
+		if err != nil {
+			fatal(err)
+		}
+	}
+
+	if listProfiles {
+		list, err := client.ListProfiles(context.Background(), &opts)
+		if err != nil {
+			fatal(err)
+		}
+		if err := printProfiles(os.Stdout, list, listJSON); err != nil {
+			fatal(err)
+		}
+		os.Exit(0)
+	}
 
 	// Write PID file and schedule removal on exit
-	if err := writePIDFile(pidPath); err != nil {
-		fatal(err)
+	// the dry run must not overwrite the PID file of a running process
+	if !dryRun {
+		if err := writePIDFile(pidPath, opts.Config.FileMode); err != nil {
+			fatal(err)
+		}
+		defer removePIDFile(pidPath)
+	}
+
+	// Set default log file path if not specified
+	logFileSet := logFilePath != ""
+	if !logFileSet {
+		logFilePath = filepath.Join(runDir, baseName+".log")
 	}
-	defer removePIDFile(pidPath)
 
 	// Check if daemon mode is enabled (skip if already daemonized)
 	if opts.Daemon && os.Getenv("__GOF5_DAEMONIZED") != "1" {
 		if opts.Password == "" {
-			fatal(fmt.Errorf("password is required for daemon mode; use --password, --password-file, or GOF5_PASSWORD environment variable"))
+			fatal(client.InputError("password is required for daemon mode; use --password, --password-file, or GOF5_PASSWORD environment variable"))
+		}
+
+		// Ask for the TLS key passphrase, while the terminal is available
+		if err := client.CheckTLSFiles(&opts); err != nil {
+			fatal(err)
 		}
 
 		// Set environment variables for child process
 		os.Setenv("__GOF5_PASSWORD", opts.Password)
+		for k, v := range map[string]string{
+			"GOF5_TOKEN":           opts.Token,
+			"GOF5_KEY_PASSPHRASE":  opts.KeyPassphrase,
+			"GOF5_PKCS12_PASSWORD": opts.PKCS12Password,
+			"GOF5_CA_CERT_PEM":     opts.CACertPEM,
+			"GOF5_CLIENT_CERT_PEM": opts.CertPEM,
+			"GOF5_CLIENT_KEY_PEM":  opts.KeyPEM,
+		} {
+			if v != "" {
+				os.Setenv(k, v)
+			}
+		}
 		os.Setenv("__GOF5_DAEMONIZED", "1")
 
 		// Delete password file if it exists and remove option is set
@@ -202,27 +819,52 @@ func main() {
 			}
 		}
 
-		// Set default log file path if not specified
-		if logFilePath == "" {
-			logFilePath = filepath.Join("/tmp", "gof5", usr.Username+".log")
-		}
-
-		logFile, err := daemonize(logFilePath)
+		logFile, err := daemonize(logFilePath, opts.Config.FileMode)
 		if err != nil {
 			fatal(err)
 		}
 		// We're now in the child process (daemon)
 		// Redirect log output to the log file
-		log.SetOutput(logFile)
+		util.SetLogOutput(logFile)
 		// Also redirect stderr for future error output
-		syscall.Dup2(int(logFile.Fd()), int(os.Stderr.Fd()))
+		redirectStderr(logFile)
 
 		// Rewrite PID file with child's PID
-		if err := writePIDFile(pidPath); err != nil {
+		if err := writePIDFile(pidPath, opts.Config.FileMode); err != nil {
 			log.Printf("Warning: failed to rewrite PID file: %s", err)
 		}
 	}
 
+	var logOutput io.Writer = os.Stderr
+	if serviceMode || os.Getenv("__GOF5_DAEMONIZED") == "1" && opts.Config.LogMaxSizeMB > 0 {
+		// the daemon stderr is the log file opened by the parent process,
+		// reopen it in order to be able to rotate it
+		// the Windows service has no stderr at all
+		w, err := util.NewRotateWriter(logFilePath, int64(opts.Config.LogMaxSizeMB)<<20, opts.Config.LogMaxBackups, opts.Config.FileMode)
+		if err != nil {
+			fatal(err)
+		}
+		defer w.Close()
+		logOutput = w
+	}
+
+	if opts.Config.Syslog {
+		w, err := util.NewSyslogWriter()
+		if err != nil {
+			log.Printf("Warning: ignoring the syslog option: %s", err)
+		} else if opts.Daemon && !logFileSet {
+			// syslog replaces the default daemon log file, an explicit
+			// --log-file keeps both
+			logOutput = w
+		} else {
+			logOutput = io.MultiWriter(logOutput, w)
+		}
+	}
+
+	if logOutput != os.Stderr {
+		util.SetLogOutput(logOutput)
+	}
+
 	if opts.Config.Timeout != "" && opts.Config.Timeout != "-1" {
 		timeout, err := parseTimeout(opts.Config.Timeout)
 		if err != nil {
@@ -234,15 +876,104 @@ func main() {
 		go func() {
 			time.Sleep(timeout)
 			log.Printf("Timeout reached, stopping gof5...")
-			os.Exit(0)
+			// trigger the graceful teardown to restore routes and DNS, the
+			// stop must not be dropped, when the channel buffer is full
+			opts.Signals <- syscall.SIGTERM
 		}()
 	}
 
-	if err := client.Connect(&opts); err != nil {
+	if maxDuration > 0 && (serviceMode || len(opts.Config.OnDemandDomains) > 0) {
+		fatal(fmt.Errorf("max-duration cannot be used with the service and on-demand modes"))
+	}
+
+	if serviceMode {
+		if err := runService(&opts); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	if len(opts.Config.OnDemandDomains) > 0 {
+		if err := client.OnDemand(&opts); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	ctx := context.Background()
+	if maxDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, maxDuration)
+		defer cancel()
+		log.Printf("Max duration set to %s, gof5 disconnects after it", maxDuration)
+	}
+	err = client.ConnectContext(ctx, &opts)
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		// the tunnel is torn down like on a signal
+		log.Printf("Max duration of %s reached, disconnected", maxDuration)
+		return
+	}
+	if err != nil {
 		fatal(err)
 	}
 }
 
+// applyConnection fills the options, which are not set by the flags, the
+// config or the profile, from the connection block
+func applyConnection(opts *client.Options) {
+	c := opts.Config.Connection
+	if c == nil {
+		return
+	}
+	path := func(v string) string {
+		if v == "" || filepath.IsAbs(v) {
+			return v
+		}
+		return filepath.Join(opts.Config.Path, v)
+	}
+
+	if opts.Server == "" {
+		opts.Server = c.Server
+	}
+	if opts.Username == "" {
+		opts.Username = c.Username
+	}
+	if opts.CACert == "" {
+		opts.CACert = path(c.CACert)
+	}
+	if opts.Cert == "" && opts.Key == "" && opts.PKCS12 == "" {
+		opts.Cert = path(c.Cert)
+		opts.Key = path(c.Key)
+	}
+	if opts.ProfileName == "" {
+		opts.ProfileName = c.ProfileName
+	}
+}
+
+// printProfiles prints the servers and the VPN profiles as tables or JSON
+func printProfiles(w io.Writer, list *client.ProfileList, asJSON bool) error {
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(list)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if len(list.Servers) > 0 {
+		// the index is the --server-index value
+		fmt.Fprintln(tw, "SERVER INDEX\tADDRESS\tALIAS")
+		for i, s := range list.Servers {
+			fmt.Fprintf(tw, "%d\t%s\t%s\n", i+1, s.Address, s.Alias)
+		}
+		fmt.Fprintln(tw)
+	}
+	fmt.Fprintln(tw, "INDEX\tNAME\tCAPTION\tID")
+	for _, p := range list.Profiles {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", p.Index, p.Name, p.Caption, p.ID)
+	}
+	return tw.Flush()
+}
+
 func parseTimeout(s string) (time.Duration, error) {
 	if strings.HasSuffix(s, "d") {
 		daysStr := strings.TrimSuffix(s, "d")
@@ -0,0 +1,24 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/kayrus/gof5/pkg/client"
+)
+
+var errNoService = fmt.Errorf("service mode is supported only on Windows, use daemon mode instead")
+
+func runService(opts *client.Options) error {
+	return errNoService
+}
+
+func installService(args []string) error {
+	return errNoService
+}
+
+func uninstallService() error {
+	return errNoService
+}
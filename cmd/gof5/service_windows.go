@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/kayrus/gof5/pkg/client"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+const serviceName = "gof5"
+
+type service struct {
+	opts *client.Options
+}
+
+// Execute runs the VPN connection and handles the Service Control Manager
+// requests
+func (s *service) Execute(args []string, r <-chan svc.ChangeRequest, changes chan<- svc.Status) (bool, uint32) {
+	changes <- svc.Status{State: svc.StartPending}
+
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- client.Connect(s.opts)
+	}()
+
+	changes <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+
+	for {
+		select {
+		case err := <-errChan:
+			if err != nil {
+				log.Printf("Connection failed: %s", err)
+				return false, 1
+			}
+			return false, 0
+		case c := <-r:
+			switch c.Cmd {
+			case svc.Interrogate:
+				changes <- c.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				changes <- svc.Status{State: svc.StopPending}
+				// trigger the graceful teardown to restore routes and DNS
+				select {
+				case s.opts.Signals <- os.Interrupt:
+				default:
+				}
+				select {
+				case err := <-errChan:
+					if err != nil {
+						log.Printf("Connection failed: %s", err)
+					}
+				case <-time.After(stopTimeout):
+					log.Printf("gof5 did not stop within %s", stopTimeout)
+				}
+				return false, 0
+			}
+		}
+	}
+}
+
+func runService(opts *client.Options) error {
+	return svc.Run(serviceName, &service{opts: opts})
+}
+
+func installService(args []string) error {
+	// the service command line is readable by every local user
+	args, err := filterArgs(args)
+	if err != nil {
+		return err
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to detect executable path: %s", err)
+	}
+
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to Service Control Manager: %s", err)
+	}
+	defer m.Disconnect()
+
+	if s, err := m.OpenService(serviceName); err == nil {
+		s.Close()
+		return fmt.Errorf("%s service already exists", serviceName)
+	}
+
+	s, err := m.CreateService(serviceName, exe, mgr.Config{
+		DisplayName: "gof5 VPN client",
+		Description: "F5 VPN client",
+		StartType:   mgr.StartAutomatic,
+	}, append(args, "--service")...)
+	if err != nil {
+		return fmt.Errorf("failed to create %s service: %s", serviceName, err)
+	}
+	defer s.Close()
+
+	log.Printf("%s service is installed", serviceName)
+
+	return nil
+}
+
+func uninstallService() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to Service Control Manager: %s", err)
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("%s service is not installed", serviceName)
+	}
+	defer s.Close()
+
+	if err = s.Delete(); err != nil {
+		return fmt.Errorf("failed to delete %s service: %s", serviceName, err)
+	}
+
+	log.Printf("%s service is removed", serviceName)
+
+	return nil
+}
@@ -1,43 +1,111 @@
 package client
 
 import (
+	"context"
+	"crypto/sha256"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
 	"os"
+	"os/exec"
 	"os/signal"
 	"runtime"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	"github.com/kayrus/gof5/pkg/config"
 	"github.com/kayrus/gof5/pkg/cookie"
 	"github.com/kayrus/gof5/pkg/link"
+	"github.com/kayrus/gof5/pkg/metrics"
+	"github.com/kayrus/gof5/pkg/systemd"
+	"github.com/kayrus/gof5/pkg/util"
+)
+
+const (
+	minReconnectBackoff = time.Second
+	maxReconnectBackoff = 60 * time.Second
+	// interval of the throughput debug logs
+	throughputInterval = 10 * time.Second
 )
 
 type Options struct {
 	config.Config
-	Server        string
-	Username      string
-	Password      string
-	PasswordFile  string
-	SessionID     string
-	CACert        string
-	Cert          string
-	Key           string
-	CloseSession  bool
-	Debug         bool
-	Sel           bool
-	Version       bool
-	ProfileIndex  int
-	ProfileName   string
-	ConfigPath    string
+	Server       string
+	Username     string
+	Password     string
+	Token        string
+	PasswordFile string
+	SessionID    string
+	// CACert is a comma-separated list of the CA certificate paths
+	CACert       string
+	Cert         string
+	Key          string
+	CloseSession bool
+	Debug        bool
+	Sel          bool
+	Version      bool
+	ProfileIndex int
+	ProfileName  string
+	ConfigPath   string
+	// RequireConfig fails, when the default config file is missing
+	RequireConfig bool
+	// StrictPerms refuses the config, TLS certificate and key files, which
+	// are accessible by group or others
+	StrictPerms bool
+	// Profile is a name of the server profile in the config file
+	Profile string
+	// ServerIndex selects the Nth server, starting from 1, from the list
+	// without a menu, when Sel is set
+	ServerIndex int
+	// StatePath is a path to the JSON file, describing the established connection
+	StatePath string
+	// NoCookieCache disables the saved HTTPS session cookies
+	NoCookieCache bool
+	// NonInteractive disables all prompts, a missing input results in the
+	// InputError
+	NonInteractive bool
+	// NextToken is the next RSA SecurID token code, requested when the token
+	// is out of sync
+	NextToken string
+	// password is wiped after the logon, unless the reconnect is enabled
+	password secret
+	// MFAMethod answers the Duo second factor challenge: push, phone, sms or
+	// passcode:<code>
+	MFAMethod string
+	// KeyPassphrase decrypts the encrypted user TLS key
+	KeyPassphrase string
+	// SystemCA appends the CACert to the system certificate pool instead of
+	// replacing it
+	SystemCA bool
+	// PKCS12 is a path to the PKCS#12 bundle with the user TLS certificate
+	// chain and key, mutually exclusive with Cert and Key
+	PKCS12 string
+	// PKCS12Password decrypts the PKCS#12 bundle
+	PKCS12Password string
+	// CACertPEM, CertPEM and KeyPEM are the PEM encoded CA certificates, the
+	// user TLS certificate and key, used when the CA and the user TLS files
+	// are not set
+	CACertPEM string
+	CertPEM   string
+	KeyPEM    string
+	// DryRun prints the VPN profile, pushed by F5, and exits without
+	// configuring the system
+	DryRun bool
+	// Signals is used to terminate the connection programmatically, when nil
+	// only OS signals are handled
+	Signals       chan os.Signal
 	Renegotiation tls.RenegotiationSupport
 }
 
+// UrlHandlerF5Vpn parses the "f5-vpn://" URL, opened by the F5 web portal,
+// and exchanges its one-time code for a session ID
 func UrlHandlerF5Vpn(opts *Options, s string) error {
 	u, err := url.Parse(s)
 	if err != nil {
@@ -57,70 +125,230 @@ func UrlHandlerF5Vpn(opts *Options, s string) error {
 	resourceNames := m["resourcename"]
 	if len(resourceTypes) == len(resourceNames) {
 		for i := range resourceTypes {
-			if resourceTypes[i] == "network_access" {
+			// the explicit profile name takes precedence
+			if resourceTypes[i] == "network_access" && opts.ProfileName == "" {
 				opts.ProfileName = resourceNames[i]
 				break
 			}
 		}
 	}
 
-	opts.Server = m["server"][0]
-	tokenUrl := fmt.Sprintf("%s://%s:%s/vdesk/get_sessid_for_token.php3", m["protocol"][0], opts.Server, m["port"][0])
+	otc := m["otc"]
+	if m.Get("server") == "" || len(otc) == 0 {
+		return fmt.Errorf("invalid f5-vpn URL: server and otc parameters are required")
+	}
+	opts.Server = m.Get("server")
+
+	protocol := m.Get("protocol")
+	if protocol == "" {
+		protocol = "https"
+	}
+	host := opts.Server
+	if port := m.Get("port"); port != "" {
+		host = net.JoinHostPort(opts.Server, port)
+	}
+
+	tokenUrl := fmt.Sprintf("%s://%s/vdesk/get_sessid_for_token.php3", protocol, host)
 	request, err := http.NewRequest(http.MethodGet, tokenUrl, nil)
 	if err != nil {
 		return err
 	}
-	otc := m["otc"]
 	request.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 	request.Header.Add("X-Access-Session-Token", otc[len(otc)-1])
 
-	response, err := http.DefaultClient.Do(request)
+	tlsConf, err := tlsConfig(opts, false)
+	if err != nil {
+		return fmt.Errorf("failed to build TLS config: %v", err)
+	}
+	transport, err := newTransport(&opts.Config, tlsConf)
 	if err != nil {
 		return err
 	}
+	client := &http.Client{
+		Transport: transport,
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
 
 	opts.SessionID = response.Header.Get("X-Access-Session-ID")
+	if opts.SessionID == "" {
+		return fmt.Errorf("failed to exchange the one-time code for a session ID: %s", response.Status)
+	}
+
 	return nil
 }
 
+// Connect establishes the VPN tunnel and blocks until it is terminated
 func Connect(opts *Options) error {
+	return ConnectContext(context.Background(), opts)
+}
+
+// ConnectContext establishes the VPN tunnel and blocks until it is terminated
+// or the context is cancelled. The cancelled context aborts the logon
+// requests, the reconnect backoff and tears the established tunnel down, the
+// context error is returned.
+func ConnectContext(ctx context.Context, opts *Options) error {
+	client, u, tlsConf, err := newClient(ctx, opts)
+	if err != nil {
+		return err
+	}
+	cfg := &opts.Config
+
+	// close HTTPS VPN session
+	// next VPN connection will require credentials to auth
+	if opts.CloseSession {
+		defer closeVPNSession(client, opts.Server)
+	}
+
+	if cfg.MetricsListen != "" {
+		stop, err := metrics.Start(cfg.MetricsListen)
+		if err != nil {
+			return err
+		}
+		defer stop()
+	}
+
+	var ctl *controlServer
+	if cfg.ControlSocket != "" {
+		ctl, err = startControl(cfg, opts.Server)
+		if err != nil {
+			return err
+		}
+		defer ctl.stop()
+	}
+
+	if cfg.KillSwitch {
+		// stale rules of a crashed process prevent the logon
+		link.DisableKillSwitch()
+		defer link.DisableKillSwitch()
+	}
+
+	defer systemd.StartWatchdog()()
+
+	termChan := opts.Signals
+	if termChan == nil {
+		termChan = make(chan os.Signal, 1)
+	}
+	signal.Notify(termChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGPIPE)
+
+	reloadChan := make(chan os.Signal, 1)
+	notifyReload(reloadChan)
+
+	if !cfg.Reconnect {
+		for {
+			err = connect(ctx, client, u, opts, tlsConf, ctl, termChan, reloadChan, nil)
+			if err != errControlReconnect {
+				return err
+			}
+			log.Printf("Reconnecting to %s", opts.Server)
+		}
+	}
+
+	backoff := minReconnectBackoff
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		err = connect(ctx, client, u, opts, tlsConf, ctl, termChan, reloadChan, nil)
+		if err == nil {
+			// terminated by a signal
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err == errControlReconnect {
+			log.Printf("Reconnecting to %s", opts.Server)
+			backoff = minReconnectBackoff
+			continue
+		}
+
+		var authErr AuthError
+		if errors.As(err, &authErr) {
+			return err
+		}
+
+		// reset the backoff, when the connection was stable enough
+		if time.Since(start) > maxReconnectBackoff {
+			backoff = minReconnectBackoff
+		}
+
+		log.Printf("Connection failed: %s", err)
+		log.Printf("Reconnecting in %s", backoff)
+		select {
+		case sig := <-termChan:
+			log.Printf("received %s signal, exiting", sig)
+			forceExitOnSignal(termChan)
+			return nil
+		case action := <-ctl.Actions():
+			if action == controlDisconnect {
+				log.Printf("Disconnect is requested using the control socket, exiting")
+				return nil
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		log.Printf("Reconnect attempt #%d to %s", attempt, opts.Server)
+		metrics.Reconnects.Inc()
+
+		backoff *= 2
+		if backoff > maxReconnectBackoff {
+			backoff = maxReconnectBackoff
+		}
+	}
+}
+
+// loadConfig reads the config, if not already loaded
+func loadConfig(opts *Options) (*config.Config, error) {
+	if opts.Config.Driver != "" {
+		return &opts.Config, nil
+	}
+	cfg, err := config.ReadConfig(opts.Debug, opts.ConfigPath, opts.Profile, opts.RequireConfig, opts.StrictPerms)
+	if err != nil {
+		return nil, err
+	}
+	opts.Config = *cfg
+	return &opts.Config, nil
+}
+
+// newClient parses the server address, reads the config and returns the HTTP
+// client with the saved cookies
+func newClient(ctx context.Context, opts *Options) (*http.Client, *url.URL, *tls.Config, error) {
 	if opts.Server == "" {
+		if opts.NonInteractive {
+			return nil, nil, nil, InputError("server address is required; use --server flag")
+		}
 		fmt.Print("Enter server address: ")
 		fmt.Scanln(&opts.Server)
 	}
 
 	u, err := url.Parse(opts.Server)
 	if err != nil {
-		return fmt.Errorf("failed to parse server hostname: %s", err)
+		return nil, nil, nil, fmt.Errorf("failed to parse server hostname: %s", err)
 	}
 	if u.Scheme != "https" {
 		u, err = url.Parse(fmt.Sprintf("https://%s", u.Host))
 		if err != nil {
-			return fmt.Errorf("failed to parse server hostname: %s", err)
+			return nil, nil, nil, fmt.Errorf("failed to parse server hostname: %s", err)
 		}
 	}
 	if u.Host == "" {
 		u, err = url.Parse(fmt.Sprintf("https://%s", opts.Server))
 		if err != nil {
-			return fmt.Errorf("failed to parse server hostname: %s", err)
+			return nil, nil, nil, fmt.Errorf("failed to parse server hostname: %s", err)
 		}
 		if u.Host == "" {
-			return fmt.Errorf("failed to parse server hostname: %s", err)
+			return nil, nil, nil, fmt.Errorf("failed to parse server hostname: %s", err)
 		}
 	}
 	opts.Server = u.Host
 
-	// read config if not already loaded
-	var cfg *config.Config
-	if opts.Config.Driver == "" {
-		var err error
-		cfg, err = config.ReadConfig(opts.Debug, opts.ConfigPath)
-		if err != nil {
-			return err
-		}
-		opts.Config = *cfg
-	} else {
-		cfg = &opts.Config
+	cfg, err := loadConfig(opts)
+	if err != nil {
+		return nil, nil, nil, err
 	}
 
 	switch cfg.Renegotiation {
@@ -131,28 +359,43 @@ func Connect(opts *Options) error {
 	case "RenegotiateNever", "":
 		opts.Renegotiation = tls.RenegotiateNever
 	default:
-		return fmt.Errorf("unknown renegotiation value: '%s'", cfg.Renegotiation)
+		return nil, nil, nil, fmt.Errorf("unknown renegotiation value: '%s'", cfg.Renegotiation)
 	}
 
 	cookieJar, err := cookiejar.New(nil)
 	if err != nil {
-		return fmt.Errorf("failed to create cookie jar: %s", err)
+		return nil, nil, nil, fmt.Errorf("failed to create cookie jar: %s", err)
 	}
 
-	client := &http.Client{Jar: cookieJar}
+	client := &http.Client{
+		Jar:     cookieJar,
+		Timeout: cfg.RequestTimeout,
+	}
 	client.CheckRedirect = checkRedirect(client)
 
 	tlsConf, err := tlsConfig(opts, cfg.InsecureTLS)
 	if err != nil {
-		return fmt.Errorf("failed to build TLS config: %v", err)
+		return nil, nil, nil, fmt.Errorf("failed to build TLS config: %v", err)
+	}
+	transport, err := newTransport(cfg, tlsConf)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	transport = ctxTransport{ctx: ctx, rt: transport}
+	ua := cfg.UserAgent
+	if ua == "" {
+		// the config is not read by ReadConfig
+		ua = config.DefaultUserAgent
 	}
-	transport := &http.Transport{
-		TLSClientConfig: tlsConf,
+	transport = uaTransport{ua: ua, rt: transport}
+	if util.TraceEnabled() {
+		transport = traceTransport{rt: transport}
 	}
 	if opts.Debug {
 		client.Transport = &RoundTripper{
 			Rt:     transport,
 			Logger: &logger{},
+			Unsafe: cfg.DebugUnsafe,
 		}
 	} else {
 		client.Transport = transport
@@ -160,57 +403,115 @@ func Connect(opts *Options) error {
 
 	// when server select list has been chosen
 	if opts.Sel {
-		u, err = getServersList(client, opts.Server)
+		u, err = getServersList(client, opts)
 		if err != nil {
-			return err
+			return nil, nil, nil, err
 		}
 		opts.Server = u.Host
 	}
+	util.SetLogField("server", opts.Server)
 
 	// read cookies
-	cookie.ReadCookies(client, u, cfg, opts.SessionID)
+	if opts.NoCookieCache {
+		cookie.SetSessionID(client, u, opts.SessionID)
+	} else {
+		cookie.ReadCookies(client, u, cfg, opts.SessionID)
+	}
+
+	return client, u, tlsConf, nil
+}
+
+// dropPrivileges switches the process to the invoking user, the failure is
+// not fatal
+func dropPrivileges(cfg *config.Config) {
+	if cfg.Uid == 0 {
+		log.Printf("Warning: gof5 is run by root without sudo, keeping the privileges")
+		return
+	}
+	if err := util.DropPrivileges(cfg.Uid, cfg.Gid); err != nil {
+		log.Printf("Warning: failed to drop privileges: %s", err)
+		return
+	}
+	log.Printf("Dropped privileges to %d UID and %d GID", cfg.Uid, cfg.Gid)
+}
 
-	if len(client.Jar.Cookies(u)) == 0 {
+// forceExitOnSignal exits immediately on the next signal, when the graceful
+// teardown hangs
+func forceExitOnSignal(termChan chan os.Signal) {
+	go func() {
+		sig := <-termChan
+		log.Printf("received %s signal again, forcing exit", sig)
+		os.Exit(1)
+	}()
+}
+
+// authProfiles logs in, when there is no valid saved HTTPS VPN session, and
+// returns the VPN profiles response
+func authProfiles(ctx context.Context, client *http.Client, u *url.URL, opts *Options) (*http.Response, error) {
+	reused := len(client.Jar.Cookies(u)) > 0
+	if !reused {
 		// need to login
-		if err := login(client, opts.Server, &opts.Username, &opts.Password); err != nil {
-			return fmt.Errorf("failed to login: %s", err)
+		if err := login(ctx, client, opts); err != nil {
+			return nil, fmt.Errorf("failed to login: %w", err)
 		}
 	} else {
 		log.Printf("Reusing saved HTTPS VPN session for %s", u.Host)
 	}
 
-	if opts.Password == "" {
-		if envPassword := os.Getenv("GOF5_PASSWORD"); envPassword != "" {
-			opts.Password = envPassword
-		}
-	}
-
 	resp, err := getProfiles(client, opts.Server)
 	if err != nil {
-		return fmt.Errorf("failed to get VPN profiles: %s", err)
+		return nil, fmt.Errorf("failed to get VPN profiles: %s", err)
 	}
 
-	if resp.StatusCode == 302 {
+	if resp.StatusCode == 302 || reused && (resp.StatusCode == 401 || resp.StatusCode == 403) {
 		// need to relogin
+		if resp.StatusCode != 302 {
+			log.Printf("Saved HTTPS VPN session is rejected, logging in")
+		}
 		_, err = io.Copy(io.Discard, resp.Body)
 		if err != nil {
-			return fmt.Errorf("failed to read response body: %s", err)
+			return nil, fmt.Errorf("failed to read response body: %s", err)
 		}
 		resp.Body.Close()
 
-		if err := login(client, opts.Server, &opts.Username, &opts.Password); err != nil {
-			return fmt.Errorf("failed to login: %s", err)
+		if err := login(ctx, client, opts); err != nil {
+			return nil, fmt.Errorf("failed to login: %w", err)
 		}
 
 		// new request
 		resp, err = getProfiles(client, opts.Server)
 		if err != nil {
-			return fmt.Errorf("failed to get VPN profiles: %s", err)
+			return nil, fmt.Errorf("failed to get VPN profiles: %s", err)
 		}
 	}
 
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("wrong response code on profiles get: %d", resp.StatusCode)
+	switch resp.StatusCode {
+	case 200:
+	case 401, 403:
+		resp.Body.Close()
+		return nil, AuthError(fmt.Sprintf("wrong response code on profiles get: %d", resp.StatusCode))
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("wrong response code on profiles get: %d", resp.StatusCode)
+	}
+
+	return resp, nil
+}
+
+// connect authenticates, establishes the tunnel and blocks until the tunnel
+// is down; nil is returned, when the tunnel was terminated by a signal, and
+// the context error, when the context is cancelled. The session, when set,
+// is notified about the established tunnel.
+func connect(ctx context.Context, client *http.Client, u *url.URL, opts *Options, tlsConf *tls.Config, ctl *controlServer, termChan, reloadChan chan os.Signal, s *Session) error {
+	cfg := &opts.Config
+	util.TraceStart()
+	// logs the phases until the failure, a no-op after the summary of the
+	// established tunnel
+	defer util.TraceSummary()
+
+	resp, err := authProfiles(ctx, client, u, opts)
+	if err != nil {
+		return err
 	}
 
 	profile, err := parseProfile(resp.Body, opts.ProfileIndex, opts.ProfileName)
@@ -223,33 +524,109 @@ func Connect(opts *Options) error {
 	if err != nil {
 		return fmt.Errorf("failed to get VPN connection options: %s", err)
 	}
+	// the session ID is a credential, log only its hash
+	util.SetLogField("session", fmt.Sprintf("%x", sha256.Sum256([]byte(cfg.F5Config.Object.SessionID)))[:12])
 
 	// save cookies
-	if err := cookie.SaveCookies(client, u, cfg); err != nil {
-		return fmt.Errorf("failed to save cookies: %s", err)
-	}
-
-	// close HTTPS VPN session
-	// next VPN connection will require credentials to auth
-	if opts.CloseSession {
-		defer closeVPNSession(client, opts.Server)
+	if !opts.NoCookieCache {
+		if err := cookie.SaveCookies(client, u, cfg); err != nil {
+			return fmt.Errorf("failed to save cookies: %s", err)
+		}
 	}
 
 	// TLS
-	l, err := link.InitConnection(opts.Server, cfg, tlsConf)
+	traceDone := util.TracePhase("tunnel connect")
+	l, err := link.InitConnection(ctx, opts.Server, cfg, tlsConf)
+	traceDone()
 	if err != nil {
 		return err
 	}
 	defer l.HTTPConn.Close()
 
+	if opts.DryRun {
+		return l.PrintProfile(os.Stdout, cfg)
+	}
+
 	cmd := link.Cmd(cfg)
 
-	termChan := make(chan os.Signal, 1)
-	signal.Notify(termChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGPIPE, syscall.SIGHUP)
+	// the disconnected event is sent after the teardown, the reason is set,
+	// when the tunnel goes down
+	hook := newWebhook(cfg, opts)
+	reason := "tunnel setup failed"
+	defer func() { hook.sendDisconnected(reason) }()
+
+	// the down script runs after the teardown of the established tunnel
+	var established atomic.Bool
+	if cfg.DownScript != "" {
+		defer func() {
+			if !established.Load() {
+				return
+			}
+			if err := runScript(cfg.DownScript, scriptDown, l, cfg, opts, reason); err != nil {
+				log.Printf("Warning: %s", err)
+			}
+		}()
+	}
 
 	// set routes and DNS after the PPP/TUN is up
 	go l.WaitAndConfig(cfg)
 
+	go func() {
+		select {
+		case <-l.Established:
+		case <-l.TunDown:
+			return
+		}
+		established.Store(true)
+		util.TraceSummary()
+		if cfg.UpScript != "" {
+			if err := runScript(cfg.UpScript, scriptUp, l, cfg, opts, ""); err != nil {
+				if cfg.UpScriptFatal {
+					select {
+					case l.ErrChan <- err:
+					case <-l.TunDown:
+					}
+					return
+				}
+				log.Printf("Warning: %s", err)
+			}
+		}
+		if err := systemd.Notify(systemd.Ready); err != nil {
+			log.Printf("Warning: %s", err)
+		}
+		since := time.Now()
+		metrics.Connected.Set(1)
+		metrics.ConnectionStartTime.Set(float64(since.Unix()))
+		state := &State{
+			PID:       os.Getpid(),
+			Server:    opts.Server,
+			Interface: l.Name(),
+			LocalIP:   l.LocalIPv4(),
+			Since:     since,
+		}
+		ctl.setLink(state, l)
+		s.setEstablished(l, cfg)
+		hook.sendConnected(state.LocalIP)
+		if opts.StatePath != "" {
+			if err := writeState(opts.StatePath, state, cfg.FileMode); err != nil {
+				log.Printf("Warning: %s", err)
+			}
+		}
+		if cfg.DropPrivileges && !l.DropPrivileges(func() { dropPrivileges(cfg) }) {
+			log.Printf("Tunnel is going down, keeping the privileges for the teardown")
+		}
+	}()
+	defer metrics.Connected.Set(0)
+	defer ctl.setLink(nil, nil)
+
+	if opts.StatePath != "" {
+		defer util.Privileged(func() {
+			if err := os.Remove(opts.StatePath); err != nil && !os.IsNotExist(err) {
+				log.Printf("Warning: failed to remove state file: %s", err)
+			}
+		})
+	}
+
 	// 1. stop ppp/pppd child at the very end
 	defer l.StopPPPDChild(cmd)
 	// 0. restore the config first
@@ -284,7 +661,10 @@ func Connect(opts *Options) error {
 
 		err = cmd.Start()
 		if err != nil {
-			return fmt.Errorf("failed to start pppd: %s", err)
+			if errors.Is(err, exec.ErrNotFound) {
+				return &config.DriverError{Err: fmt.Errorf("failed to start pppd: %s, install pppd or set the pppdPath option", err)}
+			}
+			return &config.DriverError{Err: fmt.Errorf("failed to start pppd: %s", err)}
 		}
 
 		// catch ppp/pppd child termination
@@ -296,20 +676,72 @@ func Connect(opts *Options) error {
 		// pppd tun->http go routine
 		go l.PppdTunToHTTP(stdout)
 	} else {
-		// http->tun go routine
-		go l.HttpToTun()
+		l.Forward()
+
+		if cfg.KeepaliveInterval > 0 {
+			go l.Keepalive(cfg.KeepaliveInterval)
+		}
+	}
+
+	if opts.Debug {
+		go l.LogThroughput(throughputInterval)
+	}
 
-		// tun->http go routine
-		go l.TunToHTTP()
+	if cfg.IdleTimeout > 0 {
+		go l.WatchIdle(cfg.IdleTimeout)
 	}
 
-	select {
-	case sig := <-termChan:
-		log.Printf("received %s signal, exiting", sig)
-	case err = <-l.ErrChan:
-		// error received
-	case err = <-l.PppdErrChan:
-		// ppp/pppd child error received
+loop:
+	for {
+		select {
+		case sig := <-termChan:
+			log.Printf("received %s signal, exiting", sig)
+			forceExitOnSignal(termChan)
+		case sig := <-reloadChan:
+			log.Printf("received %s signal, reloading config", sig)
+			newCfg, err := config.ReadConfig(opts.Debug, opts.ConfigPath, opts.Profile, opts.RequireConfig, opts.StrictPerms)
+			if err == nil {
+				newCfg.SetInstance(cfg.Instance)
+				err = l.Reload(cfg, newCfg)
+			}
+			if err != nil {
+				log.Printf("Failed to reload config: %s", err)
+			}
+			continue
+		case action := <-ctl.Actions():
+			log.Printf("%s is requested using the control socket", action)
+			if action == controlReconnect {
+				if cfg.DropPrivileges {
+					log.Printf("Reconnect is not possible after dropping privileges, disconnecting")
+				} else {
+					err = errControlReconnect
+				}
+			}
+		case <-ctx.Done():
+			log.Printf("Context is cancelled, disconnecting")
+			err = ctx.Err()
+		case err = <-l.ErrChan:
+			// error received
+		case err = <-l.PppdErrChan:
+			// ppp/pppd child error received
+		}
+		break loop
+	}
+
+	if errors.Is(err, link.ErrIdleTimeout) {
+		// the clean disconnect frees the F5 license, the next logon
+		// requires the credentials
+		closeVPNSession(client, opts.Server)
+		reason = "idle timeout"
+		err = nil
+	} else if err == nil {
+		// terminated by a signal
+		reason = "terminated"
+		if err := systemd.Notify(systemd.Stopping); err != nil {
+			log.Printf("Warning: %s", err)
+		}
+	} else {
+		reason = err.Error()
 	}
 
 	// notify tun readers and writes to stop
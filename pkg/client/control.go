@@ -0,0 +1,267 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/kayrus/gof5/pkg/config"
+	"github.com/kayrus/gof5/pkg/util"
+)
+
+const (
+	controlDisconnect = "disconnect"
+	controlReconnect  = "reconnect"
+)
+
+// errControlReconnect terminates the tunnel on the control socket request
+var errControlReconnect = errors.New("reconnect is requested using the control socket")
+
+// ControlStatus is returned by the GET /status control endpoint
+type ControlStatus struct {
+	State
+	Connected bool `json:"connected"`
+	// Uptime is in seconds
+	Uptime   int64  `json:"uptime"`
+	BytesIn  uint64 `json:"bytesIn"`
+	BytesOut uint64 `json:"bytesOut"`
+}
+
+// HealthStatus is returned by the GET /healthz control endpoint
+type HealthStatus struct {
+	Healthy bool `json:"healthy"`
+	// LastPacket is the time in seconds since the last received packet
+	LastPacket float64 `json:"lastPacket,omitempty"`
+	Reason     string  `json:"reason,omitempty"`
+}
+
+// linkStatus reports the traffic of the established tunnel
+type linkStatus interface {
+	Bytes() (uint64, uint64)
+	Health() (bool, time.Duration)
+}
+
+// controlServer serves the local control API on a Unix socket, a nil server
+// is a no-op
+type controlServer struct {
+	srv     *http.Server
+	path    string
+	actions chan string
+
+	mu     sync.Mutex
+	server string
+	state  *State
+	link   linkStatus
+}
+
+// startControl listens on the control socket, the socket is accessible only
+// by the user, who invoked gof5
+func startControl(cfg *config.Config, server string) (*controlServer, error) {
+	path := cfg.ControlSocket
+	if info, err := os.Lstat(path); err == nil {
+		// never remove a misconfigured path, e.g. a regular file
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("control socket path %s exists and is not a socket", path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("control socket %s is already in use", path)
+		}
+		// stale socket of a crashed process
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale control socket: %s", err)
+		}
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set control socket listener: %s", err)
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("failed to set control socket permissions: %s", err)
+	}
+	if runtime.GOOS != "windows" {
+		if err := os.Chown(path, cfg.Uid, cfg.Gid); err != nil {
+			l.Close()
+			return nil, fmt.Errorf("failed to set an owner for the control socket: %s", err)
+		}
+	}
+
+	s := &controlServer{
+		path:    path,
+		actions: make(chan string, 1),
+		server:  server,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", s.handleStatus)
+	mux.HandleFunc("/healthz", s.handleHealth)
+	mux.HandleFunc("/reconnect", s.handleAction(controlReconnect))
+	mux.HandleFunc("/disconnect", s.handleAction(controlDisconnect))
+	s.srv = &http.Server{Handler: mux}
+
+	log.Printf("Serving control API on %s", path)
+	go func() {
+		if err := s.srv.Serve(l); err != nil && err != http.ErrServerClosed {
+			log.Printf("Failed to serve control API: %v", err)
+		}
+	}()
+
+	return s, nil
+}
+
+func (s *controlServer) stop() {
+	if s == nil {
+		return
+	}
+	s.srv.Shutdown(context.Background())
+	util.Privileged(func() { os.Remove(s.path) })
+}
+
+// Actions returns the channel with the requested actions
+func (s *controlServer) Actions() <-chan string {
+	if s == nil {
+		return nil
+	}
+	return s.actions
+}
+
+// setLink sets the established connection, nil resets it
+func (s *controlServer) setLink(state *State, link linkStatus) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state, s.link = state, link
+}
+
+func (s *controlServer) status() *ControlStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state == nil {
+		return &ControlStatus{State: State{PID: os.Getpid(), Server: s.server}}
+	}
+	status := &ControlStatus{
+		State:     *s.state,
+		Connected: true,
+		Uptime:    int64(time.Since(s.state.Since).Seconds()),
+	}
+	status.BytesIn, status.BytesOut = s.link.Bytes()
+	return status
+}
+
+func (s *controlServer) health() *HealthStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state == nil {
+		return &HealthStatus{Reason: "tunnel is not established"}
+	}
+	healthy, since := s.link.Health()
+	status := &HealthStatus{
+		Healthy:    healthy,
+		LastPacket: since.Round(time.Millisecond).Seconds(),
+	}
+	if !healthy {
+		status.Reason = "keepalive echo got no reply"
+	}
+	return status
+}
+
+func (s *controlServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.status())
+}
+
+// handleHealth responds with 503, when the tunnel is down or degraded
+func (s *controlServer) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	status := s.health()
+	code := http.StatusOK
+	if !status.Healthy {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, status)
+}
+
+func (s *controlServer) handleAction(action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		select {
+		case s.actions <- action:
+			writeJSON(w, http.StatusAccepted, map[string]string{"action": action})
+		default:
+			http.Error(w, "another action is in progress", http.StatusConflict)
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// controlClient returns the HTTP client, connected to the control socket
+func controlClient(path string) *http.Client {
+	return &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			},
+		},
+	}
+}
+
+// ControlGetStatus requests the tunnel status from the control socket
+func ControlGetStatus(path string) (*ControlStatus, error) {
+	resp, err := controlClient(path).Get("http://gof5/status")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("wrong control socket response code: %d", resp.StatusCode)
+	}
+
+	var status ControlStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, fmt.Errorf("failed to parse control socket response: %s", err)
+	}
+	return &status, nil
+}
+
+// ControlAction requests the disconnect or reconnect action from the control
+// socket
+func ControlAction(path, action string) error {
+	resp, err := controlClient(path).Post("http://gof5/"+action, "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		v, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to request %s: %s", action, v)
+	}
+	return nil
+}
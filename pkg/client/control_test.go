@@ -0,0 +1,92 @@
+package client
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kayrus/gof5/pkg/config"
+)
+
+type testLink struct {
+	healthy bool
+}
+
+func (l testLink) Bytes() (uint64, uint64) {
+	return 1, 2
+}
+
+func (l testLink) Health() (bool, time.Duration) {
+	return l.healthy, time.Second
+}
+
+func (l testLink) Name() string {
+	return "tun0"
+}
+
+func (l testLink) LocalIPv4() net.IP {
+	return net.IPv4(10, 0, 0, 2)
+}
+
+func (l testLink) Routes() []*net.IPNet {
+	return nil
+}
+
+func TestControl(t *testing.T) {
+	cfg := &config.Config{ControlSocket: filepath.Join(t.TempDir(), "gof5.sock")}
+	cfg.Uid, cfg.Gid = -1, -1
+	ctl, err := startControl(cfg, "vpn.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctl.stop()
+
+	status, err := ControlGetStatus(cfg.ControlSocket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Connected || status.Server != "vpn.example.com" {
+		t.Errorf("Unexpected status of the disconnected tunnel: %+v", status)
+	}
+
+	if v := ctl.health(); v.Healthy {
+		t.Errorf("Unexpected health of the disconnected tunnel: %+v", v)
+	}
+
+	ctl.setLink(&State{Server: "vpn.example.com", Since: time.Now().Add(-time.Minute)}, testLink{healthy: true})
+	status, err = ControlGetStatus(cfg.ControlSocket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.Connected || status.Uptime != 60 || status.BytesIn != 1 || status.BytesOut != 2 {
+		t.Errorf("Unexpected status of the established tunnel: %+v", status)
+	}
+
+	if v := ctl.health(); !v.Healthy || v.LastPacket != 1 {
+		t.Errorf("Unexpected health of the established tunnel: %+v", v)
+	}
+
+	if err := ControlAction(cfg.ControlSocket, controlReconnect); err != nil {
+		t.Fatal(err)
+	}
+	if v := <-ctl.Actions(); v != controlReconnect {
+		t.Errorf("Action %q doesn't correspond to expected %q", v, controlReconnect)
+	}
+}
+
+func TestControlRegularFile(t *testing.T) {
+	cfg := &config.Config{ControlSocket: filepath.Join(t.TempDir(), ".bashrc")}
+	cfg.Uid, cfg.Gid = -1, -1
+	if err := os.WriteFile(cfg.ControlSocket, []byte("export PATH\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if ctl, err := startControl(cfg, "vpn.example.com"); err == nil {
+		ctl.stop()
+		t.Fatalf("expected the regular file to be rejected")
+	}
+	if _, err := os.Stat(cfg.ControlSocket); err != nil {
+		t.Errorf("the regular file was removed: %s", err)
+	}
+}
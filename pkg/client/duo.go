@@ -0,0 +1,140 @@
+package client
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/manifoldco/promptui"
+	"golang.org/x/term"
+)
+
+// time to wait for a Duo push or phone call approval, Duo itself expires the
+// request after 60 seconds
+const duoApproveTimeout = 75 * time.Second
+
+var (
+	// Duo Authentication Proxy RADIUS challenge, relayed by the F5 logon page:
+	//
+	//	Enter a passcode or select one of the following options:
+	//	 1. Duo Push to XXX-XXX-1234
+	//	 2. Phone call to XXX-XXX-1234
+	//	 3. SMS passcodes to XXX-XXX-1234
+	duoOptionRegexp = regexp.MustCompile(`(?i)\b(\d+)\s*\.\s*(Duo Push|Phone call|SMS passcodes?)\b[^<\r\n]*`)
+	duoDenyMessages = []string{"denied", "rejected", "fraud"}
+	// F5 APM logon failure pages
+	logonErrorMessages = []string{"Access was denied", "Your session could not be established", "Access policy evaluation is already in progress"}
+
+	supportedMFAMethods = []string{"push", "phone", "sms", "passcode:<code>"}
+)
+
+type duoOption struct {
+	// index is the option number in the challenge
+	index string
+	// method is one of push, phone or sms
+	method string
+	text   string
+}
+
+// duoOptions returns the second factor options of the Duo challenge, nil is
+// returned when the challenge doesn't come from Duo
+func duoOptions(body []byte) []duoOption {
+	var options []duoOption
+	for _, v := range duoOptionRegexp.FindAllSubmatch(body, -1) {
+		o := duoOption{
+			index: string(v[1]),
+			text:  strings.TrimLeft(strings.TrimSpace(string(v[0][len(v[1]):])), ". "),
+		}
+		switch strings.ToLower(string(v[2][:3])) {
+		case "duo":
+			o.method = "push"
+		case "pho":
+			o.method = "phone"
+		default:
+			o.method = "sms"
+		}
+		options = append(options, o)
+	}
+	return options
+}
+
+// CheckMFAMethod validates the --mfa-method value
+func CheckMFAMethod(method string) error {
+	switch method {
+	case "", "push", "phone", "sms":
+		return nil
+	}
+	if code, ok := strings.CutPrefix(method, "passcode:"); ok && code != "" {
+		return nil
+	}
+	return fmt.Errorf("unsupported MFA method %q, supported methods: %s", method, strings.Join(supportedMFAMethods, ", "))
+}
+
+// duoResponse returns the Duo challenge response and the chosen method: push,
+// phone, sms or passcode
+func duoResponse(opts *Options, options []duoOption) (string, string, error) {
+	method := opts.MFAMethod
+	if method == "" && opts.Token != "" {
+		// one-time token is a Duo passcode
+		method = "passcode:" + opts.Token
+	}
+
+	if method == "" {
+		if opts.NonInteractive || !term.IsTerminal(int(os.Stdin.Fd())) {
+			return "", "", InputError("Duo second factor method is required; use --mfa-method flag")
+		}
+		items := make([]string, 0, len(options)+1)
+		for _, o := range options {
+			items = append(items, o.text)
+		}
+		items = append(items, "Enter a passcode")
+		prompt := promptui.Select{
+			Label: "Select a Duo second factor",
+			Items: items,
+		}
+		i, _, err := prompt.Run()
+		if err != nil {
+			return "", "", err
+		}
+		if i < len(options) {
+			method = options[i].method
+		} else {
+			code, err := readSecret("Enter Duo passcode: ")
+			if err != nil {
+				return "", "", err
+			}
+			method = "passcode:" + code
+		}
+	}
+
+	if code, ok := strings.CutPrefix(method, "passcode:"); ok {
+		return code, "passcode", nil
+	}
+
+	for _, o := range options {
+		if o.method == method {
+			return o.index, method, nil
+		}
+	}
+	// the proxy accepts the method names as well
+	return method, method, nil
+}
+
+// duoDenied returns true, when the logon response reports a rejected Duo
+// approval
+func duoDenied(body []byte) bool {
+	s := strings.ToLower(string(body))
+	for _, v := range duoDenyMessages {
+		if strings.Contains(s, v) {
+			return true
+		}
+	}
+	for _, v := range logonErrorMessages {
+		if strings.Contains(s, strings.ToLower(v)) {
+			return true
+		}
+	}
+	return false
+}
@@ -2,55 +2,177 @@ package client
 
 import (
 	"bytes"
+	"context"
 	"crypto/hmac"
 	"crypto/md5"
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/hex"
+	"encoding/pem"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"net/http/cookiejar"
+	"net/http/httptrace"
 	"net/url"
 	"os"
+	"path/filepath"
 	"regexp"
+	"runtime"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/kayrus/gof5/pkg/config"
+	"github.com/kayrus/gof5/pkg/link"
+	"github.com/kayrus/gof5/pkg/util"
 
 	"github.com/manifoldco/promptui"
 	"github.com/mitchellh/go-homedir"
+	"github.com/youmark/pkcs8"
+	"golang.org/x/term"
+	"software.sslmate.com/src/go-pkcs12"
 )
 
 const (
-	userAgent        = "Mozilla/5.0 (X11; U; Linux i686; en-US; rv:1.9.1a2pre) Gecko/2008073000 Shredder/3.0a2pre ThunderBrowse/3.2.1.8"
+	lastServerName   = "last_server"
 	androidUserAgent = "Mozilla/5.0 (Linux; Android 10; SM-G975F Build/QP1A.190711.020) AppleWebKit/537.36 (KHTML, like Gecko) Version/4.0 Chrome/81.0.4044.138 Mobile Safari/537.36 EdgeClient/3.0.7 F5Access/3.0.7"
+	// max number of the second factor challenges in a single logon
+	maxChallenges = 3
+	// timeout of the hangup request, sent on shutdown
+	closeSessionTimeout = 5 * time.Second
 )
 
+var (
+	inputRegexp = regexp.MustCompile(`(?is)<input\s[^>]*>`)
+	attrRegexp  = regexp.MustCompile(`(?is)\b(name|type)\s*=\s*["']?([^"'\s>]*)`)
+	// RSA SecurID next token code challenge
+	nextTokenRegexp = regexp.MustCompile(`(?i)next\s*token\s*code|next\s*tokencode|next\s*token\b|wait\s+for\s+(the\s+)?token\s+to\s+change`)
+	// well known F5 APM second factor field names
+	challengeFields = []string{"_F5_challenge", "otp", "token", "passcode", "password1"}
+)
+
+// AuthError is returned, when the F5 server rejects the credentials or session
+type AuthError string
+
+func (e AuthError) Error() string {
+	return string(e)
+}
+
+// InputError is returned in the non-interactive mode, when a required input
+// would have to be prompted
+type InputError string
+
+func (e InputError) Error() string {
+	return string(e)
+}
+
+// NetworkError is returned, when the F5 server cannot be reached
+type NetworkError struct {
+	Err error
+}
+
+func (e *NetworkError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *NetworkError) Unwrap() error {
+	return e.Err
+}
+
 func tlsConfig(opts *Options, insecure bool) (*tls.Config, error) {
 	config := &tls.Config{
 		InsecureSkipVerify: insecure,
 		Renegotiation:      opts.Renegotiation,
 	}
 
-	if opts.CACert != "" {
-		caCert, err := readFile(opts.CACert)
+	switch opts.Config.TLSMinVersion {
+	case "1.2", "":
+		config.MinVersion = tls.VersionTLS12
+	case "1.3":
+		config.MinVersion = tls.VersionTLS13
+	default:
+		return nil, fmt.Errorf("unknown minimum TLS version: '%s'", opts.Config.TLSMinVersion)
+	}
+
+	if pins := opts.Config.ServerCertPins; len(pins) > 0 {
+		if opts.Config.PinOnly {
+			config.InsecureSkipVerify = true
+		}
+		config.VerifyPeerCertificate = verifyPins(pins)
+	}
+
+	caCerts := append(splitList(opts.CACert), opts.Config.CACerts...)
+	if len(caCerts) == 0 && opts.CACertPEM != "" {
+		// appendCACert reads a non-PEM value as a file path
+		if !strings.Contains(opts.CACertPEM, "-----BEGIN") {
+			return nil, InputError("GOF5_CA_CERT_PEM doesn't contain a PEM encoded certificate")
+		}
+		caCerts = []string{opts.CACertPEM}
+	}
+	if len(caCerts) > 0 {
+		var err error
+		if opts.SystemCA {
+			// the system pool is used by the platform verifier in Windows
+			// and macOS, thus enterprise and keychain CAs are trusted too
+			config.RootCAs, err = x509.SystemCertPool()
+			if err != nil {
+				return nil, fmt.Errorf("failed to load the system certificate pool: %s", err)
+			}
+		} else {
+			config.RootCAs = x509.NewCertPool()
+		}
+		for _, v := range caCerts {
+			if err := appendCACert(config.RootCAs, v, opts.StrictPerms); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if opts.PKCS12 != "" {
+		if opts.Cert != "" || opts.Key != "" {
+			return nil, fmt.Errorf("--pkcs12 cannot be used together with --cert or --key")
+		}
+		cert, err := readPKCS12(opts.PKCS12, opts.PKCS12Password, opts.StrictPerms)
 		if err != nil {
 			return nil, err
 		}
-		config.RootCAs = x509.NewCertPool()
-		config.RootCAs.AppendCertsFromPEM(caCert)
+		config.Certificates = []tls.Certificate{*cert}
 	}
 
-	if opts.Cert != "" && opts.Key != "" {
-		crt, err := readFile(opts.Cert)
+	// name of the user TLS key source, empty when the key is not set
+	var name string
+	var crt, key []byte
+	switch {
+	case opts.Cert != "" && opts.Key != "":
+		name = opts.Key
+		var err error
+		crt, err = readFile(opts.Cert, opts.StrictPerms)
 		if err != nil {
 			return nil, err
 		}
-		key, err := readFile(opts.Key)
+		key, err = readFile(opts.Key, opts.StrictPerms)
+		if err != nil {
+			return nil, err
+		}
+	case opts.Cert == "" && opts.Key == "" && opts.PKCS12 == "" && (opts.CertPEM != "" || opts.KeyPEM != ""):
+		// the file flags take precedence over the PEM environment variables
+		if opts.CertPEM == "" || opts.KeyPEM == "" {
+			return nil, fmt.Errorf("both GOF5_CLIENT_CERT_PEM and GOF5_CLIENT_KEY_PEM environment variables must be set")
+		}
+		name = "GOF5_CLIENT_KEY_PEM"
+		crt, key = []byte(opts.CertPEM), []byte(opts.KeyPEM)
+	}
+
+	if name != "" {
+		key, err := decryptKey(key, name, opts)
 		if err != nil {
 			return nil, err
 		}
@@ -66,7 +188,144 @@ func tlsConfig(opts *Options, insecure bool) (*tls.Config, error) {
 	return config, nil
 }
 
-func readFile(path string) ([]byte, error) {
+// splitList splits the comma-separated list, empty items are skipped
+func splitList(s string) []string {
+	var res []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+// appendCACert appends the CA certificates from the PEM file, which may be a
+// bundle, or from the inline PEM certificate
+func appendCACert(pool *x509.CertPool, v string, strictPerms bool) error {
+	if strings.Contains(v, "-----BEGIN") {
+		if !pool.AppendCertsFromPEM([]byte(v)) {
+			return fmt.Errorf("inline CA certificate contains no valid PEM certificates")
+		}
+		return nil
+	}
+
+	data, err := readFile(v, strictPerms)
+	if err != nil {
+		return err
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return fmt.Errorf("%q CA certificate file contains no valid PEM certificates", v)
+	}
+	return nil
+}
+
+// verifyPins returns a callback, which rejects the handshake, when the server
+// leaf certificate doesn't match any of the SHA-256 fingerprints
+func verifyPins(pins []string) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return fmt.Errorf("server didn't present a certificate")
+		}
+		sum := sha256.Sum256(rawCerts[0])
+		fingerprint := hex.EncodeToString(sum[:])
+		if !util.StrSliceContains(pins, fingerprint) {
+			return fmt.Errorf("server certificate fingerprint %s doesn't match any of the serverCertPins", fingerprint)
+		}
+		return nil
+	}
+}
+
+// readPKCS12 loads the user TLS certificate chain and key from the PKCS#12 bundle
+func readPKCS12(path, password string, strictPerms bool) (*tls.Certificate, error) {
+	path, err := homedir.Expand(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := config.CheckFilePerms(path, strictPerms); err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	key, leaf, chain, err := pkcs12.DecodeChain(data, password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %q PKCS#12 bundle: %s", path, err)
+	}
+
+	cert := &tls.Certificate{
+		Certificate: [][]byte{leaf.Raw},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}
+	for _, v := range chain {
+		cert.Certificate = append(cert.Certificate, v.Raw)
+	}
+
+	return cert, nil
+}
+
+// decryptKey decrypts a legacy encrypted PEM or an encrypted PKCS#8 key, the
+// passphrase is asked on a terminal, when it is not set; the name is used in
+// errors
+func decryptKey(key []byte, name string, opts *Options) ([]byte, error) {
+	block, _ := pem.Decode(key)
+	if block == nil {
+		return key, nil
+	}
+
+	pkcs8Encrypted := block.Type == "ENCRYPTED PRIVATE KEY"
+	//nolint:staticcheck // legacy PEM encryption is insecure, but still widely used
+	if !pkcs8Encrypted && !x509.IsEncryptedPEMBlock(block) {
+		return key, nil
+	}
+
+	if opts.KeyPassphrase == "" {
+		if opts.NonInteractive || !term.IsTerminal(int(os.Stdin.Fd())) {
+			return nil, InputError(fmt.Sprintf("%q key is encrypted; set GOF5_KEY_PASSPHRASE environment variable or use --key-passphrase flag", name))
+		}
+		fmt.Fprint(os.Stderr, "Enter TLS key passphrase: ")
+		v, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the TLS key passphrase: %s", err)
+		}
+		opts.KeyPassphrase = string(v)
+	}
+
+	if pkcs8Encrypted {
+		v, err := pkcs8.ParsePKCS8PrivateKey(block.Bytes, []byte(opts.KeyPassphrase))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt %q PKCS#8 key: %s", opts.Key, err)
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(v)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal %q key: %s", opts.Key, err)
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+	}
+
+	//nolint:staticcheck // see above
+	der, err := x509.DecryptPEMBlock(block, []byte(opts.KeyPassphrase))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %q key: %s", opts.Key, err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: block.Type, Bytes: der}), nil
+}
+
+// CheckTLSFiles verifies that the CA certificate and the user TLS
+// certificate with its key can be read and parsed
+func CheckTLSFiles(opts *Options) error {
+	if (opts.Cert == "") != (opts.Key == "") {
+		return fmt.Errorf("both --cert and --key must be specified")
+	}
+	_, err := tlsConfig(opts, false)
+	return err
+}
+
+func readFile(path string, strictPerms bool) ([]byte, error) {
 	if len(path) == 0 {
 		return nil, nil
 	}
@@ -79,7 +338,7 @@ func readFile(path string) ([]byte, error) {
 		}
 	}
 
-	if _, err := os.Stat(path); err != nil {
+	if err := config.CheckFilePerms(path, strictPerms); err != nil {
 		return nil, err
 	}
 
@@ -91,6 +350,168 @@ func readFile(path string) ([]byte, error) {
 	return bytes.TrimSpace(content), nil
 }
 
+// newTransport returns the logon HTTP transport with the configured timeouts
+// and the source address, the explicit proxy takes precedence over the
+// HTTPS_PROXY and NO_PROXY environment variables
+func newTransport(cfg *config.Config, tlsConf *tls.Config) (http.RoundTripper, error) {
+	dialer, err := link.SourceDialer(cfg, "tcp")
+	if err != nil {
+		return nil, err
+	}
+	proxy := http.ProxyFromEnvironment
+	if cfg.Proxy != "" {
+		// the URL is validated by the config
+		u, _ := url.Parse(cfg.Proxy)
+		proxy = http.ProxyURL(u)
+	}
+	return connTransport{
+		rt: &http.Transport{
+			Proxy:               proxy,
+			DialContext:         dialer.DialContext,
+			TLSClientConfig:     tlsConf,
+			TLSHandshakeTimeout: cfg.DialTimeout,
+		},
+	}, nil
+}
+
+// ctxTransport cancels the requests, when the context is done, the request
+// own context, e.g. the client timeout, is preserved
+type ctxTransport struct {
+	ctx context.Context
+	rt  http.RoundTripper
+}
+
+// detachedKey marks the request context, which must not be bound to the
+// ctxTransport context, e.g. the hangup request on shutdown
+type detachedKey struct{}
+
+func (t ctxTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Context().Value(detachedKey{}) != nil {
+		return t.rt.RoundTrip(req)
+	}
+	ctx, cancel := context.WithCancel(req.Context())
+	stop := context.AfterFunc(t.ctx, cancel)
+	done := func() {
+		stop()
+		cancel()
+	}
+	resp, err := t.rt.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		done()
+		return nil, err
+	}
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: done}
+	return resp, nil
+}
+
+// uaTransport sets the User-Agent of the requests, which don't set it
+// explicitly, e.g. the signed Android client requests keep their own one
+type uaTransport struct {
+	ua string
+	rt http.RoundTripper
+}
+
+func (t uaTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("User-Agent") == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("User-Agent", t.ua)
+	}
+	return t.rt.RoundTrip(req)
+}
+
+// traceTransport records the DNS resolution, TCP connect and TLS handshake
+// durations of the requests for the --trace summary
+type traceTransport struct {
+	rt http.RoundTripper
+}
+
+func (t traceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var mu sync.Mutex
+	var dnsStart, tlsStart time.Time
+	connStart := make(map[string]time.Time)
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			dnsStart = time.Now()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			util.TraceRecord("dns resolution", time.Since(dnsStart))
+		},
+		// the dialer may connect to several addresses in parallel
+		ConnectStart: func(network, addr string) {
+			mu.Lock()
+			defer mu.Unlock()
+			connStart[network+addr] = time.Now()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if err == nil {
+				util.TraceRecord("tcp connect", time.Since(connStart[network+addr]))
+			}
+		},
+		TLSHandshakeStart: func() {
+			tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			if err == nil {
+				util.TraceRecord("tls handshake", time.Since(tlsStart))
+			}
+		},
+	}
+	return t.rt.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+}
+
+// cancelBody releases the request context, when the body is closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel func()
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// connTransport annotates transport errors, so a connect timeout can be told
+// apart from a TLS handshake failure
+type connTransport struct {
+	rt http.RoundTripper
+}
+
+func (t connTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.rt.RoundTrip(req)
+	if err != nil {
+		return nil, connError(req.URL.Host, err)
+	}
+	return resp, nil
+}
+
+func connError(host string, err error) error {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		if opErr.Timeout() {
+			return &NetworkError{fmt.Errorf("connection to %s timed out: %w", host, err)}
+		}
+		return &NetworkError{fmt.Errorf("failed to connect to %s: %w", host, err)}
+	}
+
+	var certErr *tls.CertificateVerificationError
+	var recordErr tls.RecordHeaderError
+	var alertErr tls.AlertError
+	if errors.As(err, &certErr) || errors.As(err, &recordErr) || errors.As(err, &alertErr) ||
+		strings.Contains(err.Error(), "TLS handshake") || strings.HasPrefix(err.Error(), "tls: ") {
+		return fmt.Errorf("TLS handshake with %s failed: %w", host, err)
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return &NetworkError{fmt.Errorf("request to %s timed out: %w", host, err)}
+	}
+
+	return err
+}
+
 func checkRedirect(c *http.Client) func(*http.Request, []*http.Request) error {
 	return func(req *http.Request, via []*http.Request) error {
 		if req.URL.Path == "/my.logout.php3" || req.URL.Path == "/vdesk/hangup.php3" || req.URL.Query().Get("errorcode") != "" {
@@ -106,14 +527,14 @@ func checkRedirect(c *http.Client) func(*http.Request, []*http.Request) error {
 	}
 }
 
-func generateClientData(cData config.ClientData) (string, error) {
+func generateClientData(cData config.ClientData, hostname, platform string) (string, error) {
 	info := config.AgentInfo{
 		Type:       "standalone",
 		Version:    "2.0",
-		Platform:   "Linux",
+		Platform:   platform,
 		CPU:        "x64",
 		LandingURI: "/",
-		Hostname:   "test",
+		Hostname:   config.Hostname(hostname),
 	}
 
 	log.Print(cData.Token)
@@ -177,7 +598,7 @@ func generateClientData(cData config.ClientData) (string, error) {
 	return clientData, nil
 }
 
-func loginSignature(c *http.Client, server string, _, _ *string) error {
+func loginSignature(c *http.Client, server string, cfg *config.Config, _, _ *string) error {
 	log.Printf("Logging in...")
 	req, err := http.NewRequest("GET", fmt.Sprintf("https://%s/my.logon.php3?outform=xml&client_version=2.0&get_token=1", server), nil)
 	if err != nil {
@@ -198,7 +619,7 @@ func loginSignature(c *http.Client, server string, _, _ *string) error {
 		return err
 	}
 
-	clientData, err := generateClientData(cData)
+	clientData, err := generateClientData(cData, cfg.ReportedHostname, cfg.ReportedOS)
 	if err != nil {
 		return err
 	}
@@ -240,27 +661,80 @@ func loginSignature(c *http.Client, server string, _, _ *string) error {
 	return nil
 }
 
-func login(c *http.Client, server string, username, password *string) error {
+// appendClientInfo appends the reported hostname and OS to the logon form, F5
+// keeps them in the session.logon.last.hostname and session.logon.last.platform
+// variables for the access policy checks
+func appendClientInfo(data []byte, opts *Options) []byte {
+	if opts.ReportedHostname != "" {
+		data = append(data, "&hostname="+url.QueryEscape(opts.ReportedHostname)...)
+	}
+	if opts.ReportedOS != "" {
+		data = append(data, "&platform="+url.QueryEscape(opts.ReportedOS)...)
+	}
+	return data
+}
+
+// challengeField returns the name of the second factor input, when the logon
+// response contains an additional challenge form
+func challengeField(body []byte) string {
+	var fallback string
+	for _, input := range inputRegexp.FindAll(body, -1) {
+		var name, typ string
+		for _, attr := range attrRegexp.FindAllSubmatch(input, -1) {
+			switch strings.ToLower(string(attr[1])) {
+			case "name":
+				name = string(attr[2])
+			case "type":
+				typ = strings.ToLower(string(attr[2]))
+			}
+		}
+		switch name {
+		case "", "username", "password", "vhost":
+			continue
+		}
+		for _, v := range challengeFields {
+			if strings.EqualFold(name, v) {
+				return name
+			}
+		}
+		if typ == "password" && fallback == "" {
+			fallback = name
+		}
+	}
+	return fallback
+}
+
+func login(ctx context.Context, c *http.Client, opts *Options) error {
+	server, username := opts.Server, &opts.Username
 	if *username == "" {
-		fmt.Print("Enter VPN username: ")
+		if opts.NonInteractive {
+			return InputError("username is required; use --username flag")
+		}
+		fmt.Fprint(os.Stderr, "Enter VPN username: ")
 		fmt.Scanln(username)
 	}
-	if *password == "" {
-		if v := os.Getenv("GOF5_PASSWORD"); v != "" {
-			*password = v
-		} else {
-			return fmt.Errorf("password is required; set GOF5_PASSWORD environment variable or use --password flag")
+	if opts.password == nil {
+		if opts.Password == "" {
+			opts.Password = os.Getenv("GOF5_PASSWORD")
+			os.Unsetenv("GOF5_PASSWORD")
 		}
+		if opts.Password == "" {
+			return InputError("password is required; set GOF5_PASSWORD environment variable or use --password flag")
+		}
+		// the string cannot be wiped, drop the reference at least
+		opts.password = secret(opts.Password)
+		opts.Password = ""
 	}
 
 	log.Printf("Logging in...")
-	req, err := http.NewRequest("GET", fmt.Sprintf("https://%s", server), nil)
-	if err != nil {
-		return err
-	}
-	req.Proto = "HTTP/1.0"
-	req.Header.Set("User-Agent", userAgent)
-	resp, err := c.Do(req)
+	resp, err := doRetry(ctx, c, opts.LogonRetries, func() (*http.Request, error) {
+		req, err := http.NewRequest("GET", fmt.Sprintf("https://%s", server), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Proto = "HTTP/1.0"
+		return req, nil
+	})
 	if err != nil {
 		return err
 	}
@@ -270,25 +744,96 @@ func login(c *http.Client, server string, username, password *string) error {
 	}
 	resp.Body.Close()
 
-	data := url.Values{}
-	data.Set("username", *username)
-	data.Add("password", *password)
-	data.Add("vhost", "standard")
-	req, err = http.NewRequest("POST", fmt.Sprintf("https://%s/my.policy?outform=xml", server), strings.NewReader(data.Encode()))
+	data := []byte("username=" + url.QueryEscape(*username) + "&password=")
+	data = opts.password.appendQuery(data)
+	data = append(data, "&vhost=standard"...)
+	data = appendClientInfo(data, opts)
+	status, body, err := postPolicy(ctx, c, server, data, opts.LogonRetries)
+	secret(data).Zero()
 	if err != nil {
 		return err
 	}
-	req.Header.Set("Referer", fmt.Sprintf("https://%s/my.policy", server))
-	req.Header.Set("User-Agent", userAgent)
-	resp, err = c.Do(req)
-	if err != nil {
-		return err
+	// the password is required for the next logon, when reconnecting
+	if !opts.Reconnect {
+		opts.password.Zero()
+		opts.password = nil
 	}
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return err
+
+	// server requested a second factor, Duo SMS results in a further passcode
+	// challenge
+	var duoSMS bool
+	for i := 0; i < maxChallenges; i++ {
+		field := challengeField(body)
+		if status == 302 || field == "" {
+			break
+		}
+
+		var value, method string
+		if nextTokenRequested(body) {
+			if err = readNextToken(opts); err != nil {
+				return err
+			}
+			value, duoSMS = opts.NextToken, false
+		} else if options := duoOptions(body); len(options) > 0 && !duoSMS {
+			value, method, err = duoResponse(opts, options)
+			if err != nil {
+				return err
+			}
+			// SMS sends new passcodes and results in a new challenge
+			duoSMS = method == "sms"
+		} else {
+			if err = readToken(opts); err != nil {
+				return err
+			}
+			value, duoSMS = opts.Token, false
+		}
+
+		data := url.Values{}
+		data.Set(field, value)
+		data.Add("vhost", "standard")
+		if opts.ReportedHostname != "" {
+			data.Set("hostname", opts.ReportedHostname)
+		}
+		if opts.ReportedOS != "" {
+			data.Set("platform", opts.ReportedOS)
+		}
+		if method != "push" && method != "phone" {
+			if duoSMS {
+				log.Printf("Requesting Duo SMS passcodes")
+			} else {
+				log.Printf("Submitting one-time token")
+			}
+			status, body, err = postPolicy(ctx, c, server, []byte(data.Encode()), opts.LogonRetries)
+			// the token is valid once, a repeated challenge prompts for a new
+			// one instead of resubmitting the rejected token
+			opts.Token, opts.NextToken = "", ""
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		// Duo blocks the logon request until the push or the call is approved
+		log.Printf("Waiting for the Duo approval...")
+		timeout := c.Timeout
+		c.Timeout = duoApproveTimeout
+		// a retry would trigger another push
+		status, body, err = postPolicy(ctx, c, server, []byte(data.Encode()), 0)
+		c.Timeout = timeout
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				return AuthError(fmt.Sprintf("Duo request was not approved within %s", duoApproveTimeout))
+			}
+			return err
+		}
+		if status != 302 && (challengeField(body) != "" || duoDenied(body)) {
+			return AuthError("Duo request was denied")
+		}
+	}
+	if status != 302 && challengeField(body) != "" {
+		return AuthError("too many authentication challenges")
 	}
-	resp.Body.Close()
 
 	/*
 		if resp.StatusCode == 302 && resp.Header.Get("Location") == "/my.policy" {
@@ -297,32 +842,162 @@ func login(c *http.Client, server string, username, password *string) error {
 	*/
 
 	// TODO: parse response 302 location and error code
-	if resp.StatusCode == 302 || bytes.Contains(body, []byte("Session Expired/Timeout")) || bytes.Contains(body, []byte("The username or password is not correct")) {
-		return fmt.Errorf("wrong credentials")
+	if status == 302 || bytes.Contains(body, []byte("Session Expired/Timeout")) || bytes.Contains(body, []byte("The username or password is not correct")) {
+		return AuthError("wrong credentials")
 	}
 
 	return nil
 }
 
-func parseProfile(reader io.ReadCloser, profileIndex int, profileName string) (string, error) {
+// readToken prompts for the one-time token, unless it is already set
+func readToken(opts *Options) error {
+	if opts.Token != "" {
+		return nil
+	}
+	if opts.NonInteractive || !term.IsTerminal(int(os.Stdin.Fd())) {
+		return InputError("one-time token is required; set GOF5_TOKEN environment variable or use --token flag")
+	}
+	var err error
+	opts.Token, err = readSecret("Enter VPN token: ")
+	return err
+}
+
+// readSecret prompts for a secret on stderr and reads it from the terminal
+// with echo disabled
+func readSecret(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	v, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the input: %s", err)
+	}
+	return strings.TrimSpace(string(v)), nil
+}
+
+// doRetry sends the logon request and retries it with a backoff on 5xx
+// responses and reset connections, e.g. during the F5 failover; the last
+// response or error is returned, when the retries are exhausted
+func doRetry(ctx context.Context, c *http.Client, retries int, newReq func() (*http.Request, error)) (*http.Response, error) {
+	backoff := minReconnectBackoff
+	for attempt := 0; ; attempt++ {
+		req, err := newReq()
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.Do(req)
+
+		var reason string
+		switch {
+		case err != nil && isConnReset(err):
+			reason = err.Error()
+		case err != nil:
+			return nil, err
+		case resp.StatusCode >= 500:
+			reason = resp.Status
+		default:
+			return resp, nil
+		}
+		if attempt >= retries {
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		log.Printf("Logon request to %s failed: %s, retrying in %s (%d/%d)", req.URL.Host, reason, backoff, attempt+1, retries)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxReconnectBackoff {
+			backoff = maxReconnectBackoff
+		}
+	}
+}
+
+func isConnReset(err error) bool {
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		strings.Contains(err.Error(), "connection reset")
+}
+
+// readNextToken prompts for the next token code, when the token is out of sync
+func readNextToken(opts *Options) error {
+	if opts.NextToken != "" {
+		return nil
+	}
+	if opts.NonInteractive || !term.IsTerminal(int(os.Stdin.Fd())) {
+		return InputError("next token code is required; use --next-token flag")
+	}
+	var err error
+	opts.NextToken, err = readSecret("Wait for the token to change, then enter the next token code: ")
+	return err
+}
+
+// nextTokenRequested returns true, when the logon response asks for the next
+// token code of an out of sync RSA SecurID token
+func nextTokenRequested(body []byte) bool {
+	return nextTokenRegexp.Match(body)
+}
+
+// postPolicy submits the logon form and returns the response status and body
+func postPolicy(ctx context.Context, c *http.Client, server string, data []byte, retries int) (int, []byte, error) {
+	defer util.TracePhase("logon POST")()
+	resp, err := doRetry(ctx, c, retries, func() (*http.Request, error) {
+		req, err := http.NewRequest("POST", fmt.Sprintf("https://%s/my.policy?outform=xml", server), bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Referer", fmt.Sprintf("https://%s/my.policy", server))
+		return req, nil
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+	return resp.StatusCode, body, nil
+}
+
+func decodeProfiles(reader io.ReadCloser) (*config.Profiles, error) {
 	var profiles config.Profiles
 	dec := xml.NewDecoder(reader)
 	err := dec.Decode(&profiles)
 	reader.Close()
 	if err != nil {
-		return "", fmt.Errorf("failed to unmarshal a response: %s", err)
+		return nil, fmt.Errorf("failed to unmarshal a response: %s", err)
+	}
+	return &profiles, nil
+}
+
+func parseProfile(reader io.ReadCloser, profileIndex int, profileName string) (string, error) {
+	profiles, err := decodeProfiles(reader)
+	if err != nil {
+		return "", err
 	}
 
 	if profiles.Type == "VPN" {
 		prfls := make([]string, len(profiles.Favorites))
+		names := make([]string, len(profiles.Favorites))
+		found := false
 		for i, p := range profiles.Favorites {
-			if profileName != "" && profileName == p.Name {
-				profileIndex = i
+			if profileName != "" && profileName == p.Name && !found {
+				// the name takes precedence over the index
+				profileIndex, found = i, true
 			}
 			prfls[i] = fmt.Sprintf("%d:%s", i, p.Name)
+			names[i] = p.Name
 		}
 		log.Printf("Found F5 VPN profiles: %q", prfls)
 
+		if profileName != "" && !found {
+			return "", fmt.Errorf("profile %q is not found, available profiles: %q", profileName, names)
+		}
+
 		if profileIndex >= len(profiles.Favorites) {
 			return "", fmt.Errorf("profile %q index is out of range", profileIndex)
 		}
@@ -334,20 +1009,20 @@ func parseProfile(reader io.ReadCloser, profileIndex int, profileName string) (s
 }
 
 func getProfiles(c *http.Client, server string) (*http.Response, error) {
+	defer util.TracePhase("profiles fetch")()
 	req, err := http.NewRequest("GET", fmt.Sprintf("https://%s/vdesk/vpn/index.php3?outform=xml&client_version=2.0", server), nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to build a request: %s", err)
 	}
-	req.Header.Set("User-Agent", userAgent)
 	return c.Do(req)
 }
 
 func getConnectionOptions(c *http.Client, opts *Options, profile string) (*config.Favorite, error) {
+	defer util.TracePhase("connection options fetch")()
 	req, err := http.NewRequest("GET", fmt.Sprintf("https://%s/vdesk/vpn/connect.php3?%s&outform=xml&client_version=2.0", opts.Server, profile), nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to build a request: %s", err)
 	}
-	req.Header.Set("User-Agent", userAgent)
 	resp, err := c.Do(req)
 
 	if err != nil {
@@ -357,7 +1032,7 @@ func getConnectionOptions(c *http.Client, opts *Options, profile string) (*confi
 			Object: config.Object{
 				SessionID: opts.SessionID,
 				DNS:       opts.Config.OverrideDNS,
-				DNSSuffix: opts.Config.OverrideDNSSuffix,
+				DNSSuffix: appendSearchDomains(opts.Config.OverrideDNSSuffix, opts.Config.SearchDomains),
 			},
 		}, nil
 	}
@@ -376,29 +1051,60 @@ func getConnectionOptions(c *http.Client, opts *Options, profile string) (*confi
 		favorite.Object.SessionID = opts.SessionID
 	}
 	if len(opts.Config.OverrideDNS) > 0 {
-		favorite.Object.DNS = opts.Config.OverrideDNS
+		if opts.Config.DNSFallback {
+			log.Printf("Using %q DNS servers, pushed from F5, as a fallback", favorite.Object.DNS)
+			favorite.Object.DNS = append(append([]net.IP{}, opts.Config.OverrideDNS...), favorite.Object.DNS...)
+		} else {
+			favorite.Object.DNS = opts.Config.OverrideDNS
+			favorite.Object.DNS6 = nil
+		}
 	}
 	if len(opts.Config.OverrideDNSSuffix) > 0 {
 		favorite.Object.DNSSuffix = opts.Config.OverrideDNSSuffix
 	}
+	favorite.Object.DNSSuffix = appendSearchDomains(favorite.Object.DNSSuffix, opts.Config.SearchDomains)
 
 	return &favorite, nil
 }
 
+// appendSearchDomains returns the DNS suffixes with the extra search domains
+// appended, the duplicates are skipped
+func appendSearchDomains(suffixes, domains []string) []string {
+	res := append([]string{}, suffixes...)
+	for _, v := range domains {
+		if !util.StrSliceContains(res, v) {
+			res = append(res, v)
+		}
+	}
+	if len(res) == 0 {
+		return nil
+	}
+	return res
+}
+
 func closeVPNSession(c *http.Client, server string) {
-	// close session
-	r, err := http.NewRequest("GET", fmt.Sprintf("https://%s/vdesk/hangup.php3?hangup_error=1", server), nil)
+	// the hangup is sent on shutdown, when the connection context is already
+	// cancelled, thus the request is detached from it and has its own timeout
+	ctx, cancel := context.WithTimeout(context.WithValue(context.Background(), detachedKey{}, true), closeSessionTimeout)
+	defer cancel()
+	r, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("https://%s/vdesk/hangup.php3?hangup_error=1", server), nil)
 	if err != nil {
 		log.Printf("Failed to create a request to close the VPN session %s", err)
+		return
 	}
 	resp, err := c.Do(r)
 	if err != nil {
 		log.Printf("Failed to close the VPN session %s", err)
+		return
 	}
 	defer resp.Body.Close()
 }
 
-func getServersList(c *http.Client, server string) (*url.URL, error) {
+// getServersList selects a server from the list, served by the F5 server, the
+// last interactive choice is highlighted
+// fetchServers returns the servers list, which the F5 server offers to the
+// clients
+func fetchServers(c *http.Client, server string) (*config.PreConfigProfile, error) {
 	r, err := http.NewRequest("GET", fmt.Sprintf("https://%s/pre/config.php", server), nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create a request to get servers list: %s", err)
@@ -416,14 +1122,57 @@ func getServersList(c *http.Client, server string) (*url.URL, error) {
 		return nil, fmt.Errorf("failed to unmarshal servers list: %s", err)
 	}
 
-	prompt := promptui.Select{
-		Label: "Select Server",
-		Items: s.Servers,
+	if len(s.Servers) == 0 {
+		return nil, fmt.Errorf("servers list is empty")
 	}
+	return &s, nil
+}
 
-	i, _, err := prompt.Run()
+func getServersList(c *http.Client, opts *Options) (*url.URL, error) {
+	cfg := &opts.Config
+	index := opts.ServerIndex
+	s, err := fetchServers(c, opts.Server)
 	if err != nil {
-		return nil, fmt.Errorf("prompt failed: %s", err)
+		return nil, err
+	}
+
+	var i int
+	switch {
+	case index > 0:
+		if index > len(s.Servers) {
+			return nil, fmt.Errorf("server index %d is out of range, %d servers are available", index, len(s.Servers))
+		}
+		i = index - 1
+	case len(s.Servers) == 1:
+		log.Printf("Skipping the selection, %q is the only server", s.Servers[0].Address)
+	case opts.NonInteractive:
+		return nil, InputError("server selection is required; use --server-index flag")
+	default:
+		prompt := promptui.Select{
+			Label: "Select Server",
+			Items: s.Servers,
+			Size:  5,
+		}
+		last := readLastServer(cfg)
+		for j, v := range s.Servers {
+			if v.Address == last {
+				prompt.CursorPos = j
+				break
+			}
+		}
+
+		scroll := prompt.CursorPos - prompt.Size + 1
+		if scroll < 0 {
+			scroll = 0
+		}
+		i, _, err = prompt.RunCursorAt(prompt.CursorPos, scroll)
+		if err != nil {
+			return nil, fmt.Errorf("prompt failed: %s", err)
+		}
+
+		if err = saveLastServer(cfg, s.Servers[i].Address); err != nil {
+			log.Printf("Warning: %s", err)
+		}
 	}
 
 	u, err := url.Parse(s.Servers[i].Address)
@@ -441,3 +1190,26 @@ func getServersList(c *http.Client, server string) (*url.URL, error) {
 
 	return u, nil
 }
+
+func readLastServer(cfg *config.Config) string {
+	v, err := os.ReadFile(filepath.Join(cfg.CookiePath, lastServerName))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(v))
+}
+
+func saveLastServer(cfg *config.Config, server string) error {
+	path := filepath.Join(cfg.CookiePath, lastServerName)
+	if err := os.WriteFile(path, []byte(server+"\n"), 0600); err != nil {
+		return fmt.Errorf("failed to save the last selected server: %s", err)
+	}
+
+	if runtime.GOOS != "windows" {
+		if err := os.Chown(path, cfg.Uid, cfg.Gid); err != nil {
+			return fmt.Errorf("failed to set an owner for the last selected server file: %s", err)
+		}
+	}
+
+	return nil
+}
@@ -1,14 +1,25 @@
 package client
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync/atomic"
 	"testing"
 
 	"github.com/kayrus/gof5/pkg/config"
 )
 
 func TestSignature(t *testing.T) {
-	s, err := generateClientData(config.ClientData{Token: "1"})
+	s, err := generateClientData(config.ClientData{Token: "1"}, "test", "Linux")
 	if err != nil {
 		t.Errorf("Signature is wrong: %s", err)
 	}
@@ -27,3 +38,159 @@ func TestUnmarshal(t *testing.T) {
 		t.Errorf("failed to unmarshal a response: %s", err)
 	}
 }
+
+func TestChallengeField(t *testing.T) {
+	for body, expected := range map[string]string{
+		`<form><input type="text" name="username"><input type="password" name="password"></form>`: "",
+		`<form><input type="password" name="_F5_challenge" value=""><input name="vhost"></form>`:  "_F5_challenge",
+		`<form><INPUT TYPE=password NAME=mfa_code autocomplete="off"></form>`:                     "mfa_code",
+		`<form><input type="text" name="otp"><input type="password" name="secret"></form>`:        "otp",
+		`<?xml version="1.0"?><results><auth_result>ok</auth_result></results>`:                   "",
+	} {
+		if v := challengeField([]byte(body)); v != expected {
+			t.Errorf("Challenge field %q doesn't correspond to expected %q", v, expected)
+		}
+	}
+}
+
+func TestVerifyPins(t *testing.T) {
+	cert := []byte("certificate")
+	sum := sha256.Sum256(cert)
+	pin := hex.EncodeToString(sum[:])
+
+	if err := verifyPins([]string{pin})([][]byte{cert}, nil); err != nil {
+		t.Errorf("pinned certificate was rejected: %s", err)
+	}
+
+	err := verifyPins([]string{strings.Repeat("0", 64)})([][]byte{cert}, nil)
+	if err == nil {
+		t.Errorf("certificate with a wrong pin was accepted")
+	} else if !strings.Contains(err.Error(), pin) {
+		t.Errorf("error doesn't contain the actual fingerprint: %s", err)
+	}
+}
+
+func TestDuoOptions(t *testing.T) {
+	body := []byte(`<form><table><tr><td>Enter a passcode or select one of the following options:<br>
+ 1. Duo Push to XXX-XXX-1234<br>
+ 2. Phone call to XXX-XXX-1234<br>
+ 3. SMS passcodes to XXX-XXX-1234<br></td></tr></table>
+<input type="password" name="_F5_challenge" value=""></form>`)
+	options := duoOptions(body)
+	expected := []duoOption{
+		{index: "1", method: "push", text: "Duo Push to XXX-XXX-1234"},
+		{index: "2", method: "phone", text: "Phone call to XXX-XXX-1234"},
+		{index: "3", method: "sms", text: "SMS passcodes to XXX-XXX-1234"},
+	}
+	if !reflect.DeepEqual(options, expected) {
+		t.Errorf("Duo options %+v don't correspond to expected %+v", options, expected)
+	}
+	if v := duoOptions([]byte(`<form><input type="password" name="_F5_challenge"></form>`)); v != nil {
+		t.Errorf("Unexpected Duo options %+v", v)
+	}
+}
+
+func TestNextTokenRequested(t *testing.T) {
+	for body, expected := range map[string]bool{
+		`<td>Wait for token to change, then enter the new tokencode</td><input type="password" name="_F5_challenge">`: true,
+		`<td>Please enter the Next Token Code</td><input type="password" name="_F5_challenge">`:                       true,
+		`<td>Enter your token code</td><input type="password" name="_F5_challenge">`:                                  false,
+	} {
+		if v := nextTokenRequested([]byte(body)); v != expected {
+			t.Errorf("Next token request %t for %q doesn't correspond to expected %t", v, body, expected)
+		}
+	}
+}
+
+func TestLoginChallengeToken(t *testing.T) {
+	var tokens []string
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			raw, _ := io.ReadAll(r.Body)
+			form, _ := url.ParseQuery(string(raw))
+			if v := form.Get("_F5_challenge"); v != "" {
+				tokens = append(tokens, v)
+			}
+		}
+		// every submitted token is rejected with a new challenge
+		fmt.Fprint(w, `<form><input type="password" name="_F5_challenge" value=""></form>`)
+	}))
+	defer ts.Close()
+
+	opts := &Options{
+		Server:         ts.Listener.Addr().String(),
+		Username:       "user",
+		Password:       "pass",
+		Token:          "123456",
+		NonInteractive: true,
+	}
+	err := login(context.Background(), ts.Client(), opts)
+	if _, ok := err.(InputError); !ok {
+		t.Errorf("expected a token prompt error, got: %v", err)
+	}
+	if !reflect.DeepEqual(tokens, []string{"123456"}) {
+		t.Errorf("the one-time token was submitted %d times", len(tokens))
+	}
+}
+
+func TestLoginClientInfo(t *testing.T) {
+	var form url.Values
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			raw, _ := io.ReadAll(r.Body)
+			form, _ = url.ParseQuery(string(raw))
+		}
+	}))
+	defer ts.Close()
+
+	opts := &Options{
+		Server:   ts.Listener.Addr().String(),
+		Username: "user",
+		Password: "pass",
+	}
+	opts.ReportedHostname = "workstation 1"
+	opts.ReportedOS = "Windows"
+	if err := login(context.Background(), ts.Client(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if v := form.Get("hostname"); v != "workstation 1" {
+		t.Errorf("unexpected %q hostname in the logon request", v)
+	}
+	if v := form.Get("platform"); v != "Windows" {
+		t.Errorf("unexpected %q platform in the logon request", v)
+	}
+}
+
+// hangupServer counts the hangup requests
+func hangupServer(t *testing.T) (*httptest.Server, *atomic.Int32) {
+	var hangups atomic.Int32
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/vdesk/hangup.php3" {
+			hangups.Add(1)
+		}
+	}))
+	t.Cleanup(ts.Close)
+	return ts, &hangups
+}
+
+func TestCloseVPNSessionCancelled(t *testing.T) {
+	ts, hangups := hangupServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &http.Client{Transport: ctxTransport{ctx: ctx, rt: ts.Client().Transport}}
+	cancel()
+
+	if _, err := c.Get(ts.URL); err == nil {
+		t.Fatalf("expected the request with the cancelled context to fail")
+	}
+	closeVPNSession(c, ts.Listener.Addr().String())
+	if hangups.Load() != 1 {
+		t.Errorf("the hangup request was not sent after the context was cancelled")
+	}
+}
+
+func TestTLSConfigCACertPEM(t *testing.T) {
+	opts := &Options{CACertPEM: "/etc/ssl/certs/ca-certificates.crt"}
+	if _, err := tlsConfig(opts, false); err == nil {
+		t.Errorf("expected the non-PEM GOF5_CA_CERT_PEM value to fail")
+	}
+}
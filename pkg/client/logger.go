@@ -4,9 +4,10 @@ import (
 	"bytes"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"strings"
+
+	"github.com/kayrus/gof5/pkg/util"
 )
 
 // Logger is an interface representing the Logger struct
@@ -21,13 +22,13 @@ type logger struct {
 
 func (lg logger) RequestPrintf(format string, args ...interface{}) {
 	for _, v := range strings.Split(fmt.Sprintf(format, args...), "\n") {
-		log.Printf("-> %s", v)
+		util.DebugLog.Printf("-> %s", v)
 	}
 }
 
 func (lg logger) ResponsePrintf(format string, args ...interface{}) {
 	for _, v := range strings.Split(fmt.Sprintf(format, args...), "\n") {
-		log.Printf("<- %s", v)
+		util.DebugLog.Printf("<- %s", v)
 	}
 }
 
@@ -48,10 +49,16 @@ type RoundTripper struct {
 	// If Logger is not nil, then RoundTrip method will debug the JSON
 	// requests and responses
 	Logger Logger
+	// Unsafe logs the credentials and cookies as is, they are redacted by
+	// default
+	Unsafe bool
 }
 
 // formatHeaders converts standard http.Header type to a string with separated headers.
 func (rt *RoundTripper) formatHeaders(headers http.Header, separator string) string {
+	if !rt.Unsafe {
+		headers = util.RedactHeaders(headers)
+	}
 	result := make([]string, len(headers))
 
 	i := 0
@@ -74,7 +81,11 @@ func (rt *RoundTripper) RoundTrip(request *http.Request) (*http.Response, error)
 	var err error
 
 	if rt.Logger != nil {
-		rt.log().RequestPrintf("URL: %s %s", request.Method, request.URL)
+		if rt.Unsafe {
+			rt.log().RequestPrintf("URL: %s %s", request.Method, request.URL)
+		} else {
+			rt.log().RequestPrintf("URL: %s %s", request.Method, util.RedactURL(request.URL))
+		}
 		rt.log().RequestPrintf("Headers:\n%s", rt.formatHeaders(request.Header, "\n"))
 
 		if request.Body != nil {
@@ -120,7 +131,7 @@ func (rt *RoundTripper) logRequest(original io.ReadCloser, contentType string) (
 		return nil, err
 	}
 
-	rt.log().RequestPrintf("Body: %s", bs.String())
+	rt.log().RequestPrintf("Body: %s", rt.redact(bs.String()))
 
 	return io.NopCloser(bytes.NewReader(bs.Bytes())), nil
 }
@@ -135,11 +146,20 @@ func (rt *RoundTripper) logResponse(original io.ReadCloser, contentType string)
 		return nil, err
 	}
 
-	rt.log().ResponsePrintf("Body: %s", bs.String())
+	rt.log().ResponsePrintf("Body: %s", rt.redact(bs.String()))
 
 	return io.NopCloser(bytes.NewReader(bs.Bytes())), nil
 }
 
+// redact replaces the credentials in the body, unless the unsafe output is
+// requested
+func (rt *RoundTripper) redact(body string) string {
+	if rt.Unsafe {
+		return body
+	}
+	return util.RedactBody(body)
+}
+
 func (rt *RoundTripper) log() Logger {
 	// this is concurrency safe
 	l := rt.Logger
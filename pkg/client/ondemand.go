@@ -0,0 +1,166 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/kayrus/gof5/pkg/dns"
+)
+
+// failed on-demand connections are not retried within this interval, thus a
+// burst of DNS queries doesn't result in a burst of logons
+const onDemandRetryInterval = 10 * time.Second
+
+// onDemand establishes the tunnel on the first on-demand DNS query and closes
+// it, when no traffic passes the tunnel within the idle timeout
+type onDemand struct {
+	opts  *Options
+	fatal chan error
+
+	mu      sync.Mutex
+	session *Session
+	// the time of the last tunnel traffic and the byte counters at that time
+	last     time.Time
+	lastIn   uint64
+	lastOut  uint64
+	failedAt time.Time
+}
+
+// OnDemand serves the DNS stub for the onDemandDomains and blocks until it is
+// terminated by a signal. The first query for the on-demand domains
+// establishes the tunnel, the tunnel is closed, when no traffic passes it
+// within the onDemandIdleTimeout, and established again on the next query.
+func OnDemand(opts *Options) error {
+	cfg, err := loadConfig(opts)
+	if err != nil {
+		return err
+	}
+	if len(cfg.OnDemandDomains) == 0 {
+		return fmt.Errorf("onDemandDomains option is required for the on-demand mode")
+	}
+	// the password is required for the next logon
+	opts.Reconnect = true
+
+	d := &onDemand{
+		opts:  opts,
+		fatal: make(chan error, 1),
+	}
+	defer d.close()
+
+	termChan := opts.Signals
+	if termChan == nil {
+		termChan = make(chan os.Signal, 1)
+	}
+	signal.Notify(termChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGPIPE)
+
+	stop := make(chan struct{})
+	defer close(stop)
+	errChan := make(chan error, 2)
+	if err := dns.StartOnDemand(cfg, d.connect, errChan, stop); err != nil {
+		return err
+	}
+	log.Printf("Waiting for %q DNS queries on %s", cfg.OnDemandDomains, cfg.OnDemandListen)
+
+	ticker := time.NewTicker(cfg.OnDemandIdleTimeout / 10)
+	defer ticker.Stop()
+	for {
+		select {
+		case sig := <-termChan:
+			log.Printf("received %s signal, exiting", sig)
+			forceExitOnSignal(termChan)
+			return nil
+		case err := <-errChan:
+			return err
+		case err := <-d.fatal:
+			return err
+		case <-ticker.C:
+			d.closeIdle(cfg.OnDemandIdleTimeout)
+		}
+	}
+}
+
+// connect returns the VPN DNS servers of the established tunnel, the tunnel
+// is established, when it is down
+func (d *onDemand) connect() ([]net.IP, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.session != nil {
+		return d.session.DNSServers, nil
+	}
+	if time.Since(d.failedAt) < onDemandRetryInterval {
+		return nil, fmt.Errorf("the previous connection failed less than %s ago", onDemandRetryInterval)
+	}
+
+	log.Printf("Connecting on demand")
+	s, err := Dial(context.Background(), d.opts)
+	if err != nil {
+		d.failedAt = time.Now()
+		var authErr AuthError
+		if errors.As(err, &authErr) {
+			// the next logon fails the same way
+			select {
+			case d.fatal <- err:
+			default:
+			}
+		}
+		return nil, err
+	}
+	d.session = s
+	d.last, d.lastIn, d.lastOut = time.Now(), 0, 0
+
+	go func() {
+		if err := s.Wait(); err != nil {
+			log.Printf("Connection failed: %s", err)
+		}
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if d.session == s {
+			d.session = nil
+		}
+	}()
+
+	return s.DNSServers, nil
+}
+
+// closeIdle closes the tunnel, when the tunnel byte counters didn't change
+// within the timeout, thus a long download or an SSH session keeps the tunnel
+// up without the further DNS queries
+func (d *onDemand) closeIdle(timeout time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.session == nil {
+		return
+	}
+	if in, out := d.session.Bytes(); in != d.lastIn || out != d.lastOut {
+		d.last, d.lastIn, d.lastOut = time.Now(), in, out
+		return
+	}
+	if time.Since(d.last) < timeout {
+		return
+	}
+	log.Printf("No tunnel traffic within %s, disconnecting", timeout)
+	if err := d.session.Close(); err != nil {
+		log.Printf("Connection failed: %s", err)
+	}
+	d.session = nil
+}
+
+func (d *onDemand) close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.session != nil {
+		d.session.Close()
+		d.session = nil
+	}
+}
@@ -0,0 +1,31 @@
+package client
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOnDemandCloseIdle(t *testing.T) {
+	var in uint64
+	done := make(chan struct{})
+	close(done)
+	s := &Session{
+		cancel: func() {},
+		bytes:  func() (uint64, uint64) { return in, 0 },
+		done:   done,
+	}
+	d := &onDemand{session: s, last: time.Now().Add(-time.Hour)}
+
+	// the traffic since the last check resets the idle time
+	in = 100
+	d.closeIdle(time.Minute)
+	if d.session == nil {
+		t.Fatal("the tunnel with traffic was closed")
+	}
+
+	d.last = time.Now().Add(-time.Hour)
+	d.closeIdle(time.Minute)
+	if d.session != nil {
+		t.Error("the idle tunnel was not closed")
+	}
+}
@@ -0,0 +1,80 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/kayrus/gof5/pkg/config"
+	"github.com/kayrus/gof5/pkg/cookie"
+)
+
+// Profile is the VPN profile, available for the authenticated user
+type Profile struct {
+	Index   int    `json:"index"`
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Caption string `json:"caption"`
+	Params  string `json:"params"`
+}
+
+// ProfileList is the servers list, offered by the F5 server, and the VPN
+// profiles of the authenticated user
+type ProfileList struct {
+	Servers  []config.Server `json:"servers"`
+	Profiles []Profile       `json:"profiles"`
+}
+
+// ListProfiles authenticates and returns the servers list and the available
+// VPN profiles without establishing the tunnel. The HTTPS VPN session is
+// closed, when the CloseSession option is set, otherwise it is saved for the
+// next connection.
+func ListProfiles(ctx context.Context, opts *Options) (*ProfileList, error) {
+	client, u, _, err := newClient(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &opts.Config
+
+	// the servers list is optional and is available without the logon
+	servers := []config.Server{}
+	if s, err := fetchServers(client, opts.Server); err != nil {
+		log.Printf("The servers list is not available: %s", err)
+	} else {
+		servers = s.Servers
+	}
+
+	resp, err := authProfiles(ctx, client, u, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.CloseSession {
+		defer closeVPNSession(client, opts.Server)
+	} else if !opts.NoCookieCache {
+		if err := cookie.SaveCookies(client, u, cfg); err != nil {
+			log.Printf("Failed to save cookies: %s", err)
+		}
+	}
+
+	profiles, err := decodeProfiles(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse VPN profiles: %s", err)
+	}
+	if profiles.Type != "VPN" {
+		return nil, fmt.Errorf("VPN profile was not found")
+	}
+
+	list := make([]Profile, len(profiles.Favorites))
+	for i, p := range profiles.Favorites {
+		list[i] = Profile{
+			Index:   i,
+			ID:      p.ID,
+			Name:    p.Name,
+			Caption: p.Caption,
+			Params:  p.Params,
+		}
+	}
+
+	return &ProfileList{Servers: servers, Profiles: list}, nil
+}
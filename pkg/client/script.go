@@ -0,0 +1,89 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/kayrus/gof5/pkg/config"
+	"github.com/kayrus/gof5/pkg/link"
+)
+
+const (
+	scriptUp   = "up"
+	scriptDown = "down"
+)
+
+// scriptEnv returns the environment of the up and down scripts, the lists are
+// space separated
+func scriptEnv(event string, l sessionLink, cfg *config.Config, opts *Options, reason string) []string {
+	join := func(v []net.IP) string {
+		s := make([]string, len(v))
+		for i := range v {
+			s[i] = v[i].String()
+		}
+		return strings.Join(s, " ")
+	}
+	routes := make([]string, 0, len(l.Routes()))
+	for _, v := range l.Routes() {
+		routes = append(routes, v.String())
+	}
+
+	// the inherited GOF5_* variables may contain the credentials, e.g.
+	// GOF5_PASSWORD or GOF5_CLIENT_KEY_PEM
+	var env []string
+	for _, v := range os.Environ() {
+		if !strings.HasPrefix(v, "GOF5_") {
+			env = append(env, v)
+		}
+	}
+	env = append(env,
+		"GOF5_EVENT="+event,
+		"GOF5_SERVER="+opts.Server,
+		"GOF5_USERNAME="+opts.Username,
+		"GOF5_INTERFACE="+l.Name(),
+		"GOF5_LOCAL_IP="+l.LocalIPv4().String(),
+		"GOF5_DNS="+join(link.DNSServers(cfg)),
+		"GOF5_DNS_SUFFIX="+strings.Join(cfg.F5Config.Object.DNSSuffix, " "),
+		"GOF5_ROUTES="+strings.Join(routes, " "),
+	)
+	if cfg.NetNS != "" {
+		env = append(env, "GOF5_NETNS="+cfg.NetNS)
+	}
+	if reason != "" {
+		env = append(env, "GOF5_REASON="+reason)
+	}
+	return env
+}
+
+// runScript runs the up or down script with the tunnel settings in the
+// environment, the output is logged; the script is killed after the
+// scriptTimeout
+func runScript(path, event string, l sessionLink, cfg *config.Config, opts *Options, reason string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ScriptTimeout)
+	defer cancel()
+
+	log.Printf("Running %s script %s", event, path)
+	cmd := exec.CommandContext(ctx, path)
+	cmd.Env = scriptEnv(event, l, cfg, opts, reason)
+	out, err := cmd.CombinedOutput()
+
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		log.Printf("%s script: %s", event, s.Text())
+	}
+
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("%s script timed out after %s", event, cfg.ScriptTimeout)
+	}
+	if err != nil {
+		return fmt.Errorf("%s script failed: %s", event, err)
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+package client
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kayrus/gof5/pkg/config"
+)
+
+func TestScriptEnv(t *testing.T) {
+	t.Setenv("GOF5_PASSWORD", "SECRET123")
+	t.Setenv("GOF5_CLIENT_KEY_PEM", "SECRET123")
+
+	cfg := &config.Config{F5Config: &config.Favorite{}}
+	env := scriptEnv(scriptUp, testLink{}, cfg, &Options{Server: "vpn.example.com"}, "")
+	var iface bool
+	for _, v := range env {
+		if strings.Contains(v, "SECRET123") {
+			t.Errorf("the script environment contains the credentials: %s", v)
+		}
+		iface = iface || v == "GOF5_INTERFACE=tun0"
+	}
+	if !iface {
+		t.Errorf("the script environment doesn't contain the tunnel settings")
+	}
+}
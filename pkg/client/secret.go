@@ -0,0 +1,28 @@
+package client
+
+// secret keeps a credential in a mutable buffer, unlike a string it can be
+// wiped, when the credential is not needed anymore
+type secret []byte
+
+// Zero overwrites the secret with zeros
+func (s secret) Zero() {
+	clear(s)
+}
+
+// appendQuery appends the form encoded secret to the buffer without
+// intermediate string copies
+func (s secret) appendQuery(dst []byte) []byte {
+	const hex = "0123456789ABCDEF"
+	for _, c := range s {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			dst = append(dst, c)
+		case c == ' ':
+			dst = append(dst, '+')
+		default:
+			dst = append(dst, '%', hex[c>>4], hex[c&15])
+		}
+	}
+	return dst
+}
@@ -0,0 +1,136 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/kayrus/gof5/pkg/config"
+	"github.com/kayrus/gof5/pkg/link"
+)
+
+// sessionLink reports the settings of the established tunnel
+type sessionLink interface {
+	Name() string
+	LocalIPv4() net.IP
+	Routes() []*net.IPNet
+	Bytes() (uint64, uint64)
+}
+
+// Session is the established VPN tunnel, returned by Dial. The packets are
+// forwarded in the background until the session is closed or the tunnel is
+// terminated.
+type Session struct {
+	// VPNAddress is the IPv4 address, assigned to the client
+	VPNAddress net.IP
+	// DNSServers are the DNS servers, provided by F5
+	DNSServers []net.IP
+	// Routes are the routes, installed on the VPN interface
+	Routes []*net.IPNet
+	// Interface is the VPN interface name
+	Interface string
+
+	cancel      context.CancelFunc
+	bytes       func() (uint64, uint64)
+	established chan struct{}
+	done        chan struct{}
+	closed      atomic.Bool
+	closeOnce   sync.Once
+	err         error
+}
+
+// Dial authenticates and establishes the VPN tunnel, the tunnel settings are
+// available in the returned session. Unlike Connect, Dial doesn't handle
+// signals, the control socket and reconnects; the cancelled context tears the
+// tunnel down.
+func Dial(ctx context.Context, opts *Options) (*Session, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	client, u, tlsConf, err := newClient(ctx, opts)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	cfg := &opts.Config
+
+	if cfg.KillSwitch {
+		// stale rules of a crashed process prevent the logon
+		link.DisableKillSwitch()
+	}
+
+	s := &Session{
+		cancel:      cancel,
+		established: make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	go func() {
+		defer close(s.done)
+		s.err = connect(ctx, client, u, opts, tlsConf, nil, nil, nil, s)
+		if cfg.KillSwitch {
+			link.DisableKillSwitch()
+		}
+		if opts.CloseSession {
+			// the context is cancelled by Close, the hangup request is
+			// detached from it
+			closeVPNSession(client, opts.Server)
+		}
+	}()
+
+	select {
+	case <-s.established:
+		return s, nil
+	case <-s.done:
+		cancel()
+		if s.err == nil {
+			// dry run
+			return s, nil
+		}
+		return nil, s.err
+	}
+}
+
+// setEstablished fills the session with the tunnel settings, a nil session
+// is a no-op
+func (s *Session) setEstablished(l sessionLink, cfg *config.Config) {
+	if s == nil {
+		return
+	}
+	s.VPNAddress = l.LocalIPv4()
+	s.DNSServers = link.DNSServers(cfg)
+	s.Routes = l.Routes()
+	s.Interface = l.Name()
+	s.bytes = l.Bytes
+	close(s.established)
+}
+
+// Bytes returns the received and sent bytes of the tunnel, the keepalive
+// echoes are not counted
+func (s *Session) Bytes() (uint64, uint64) {
+	if s.bytes == nil {
+		// dry run
+		return 0, 0
+	}
+	return s.bytes()
+}
+
+// Wait blocks until the tunnel is down and returns the reason, nil is
+// returned, when the session was closed
+func (s *Session) Wait() error {
+	<-s.done
+	if s.closed.Load() && errors.Is(s.err, context.Canceled) {
+		return nil
+	}
+	return s.err
+}
+
+// Close tears the tunnel down, restores the routes and DNS and waits for the
+// teardown to complete; with the CloseSession option the F5 session is closed
+// too, the hangup request is bounded by its own timeout
+func (s *Session) Close() error {
+	s.closeOnce.Do(func() {
+		s.closed.Store(true)
+		s.cancel()
+	})
+	return s.Wait()
+}
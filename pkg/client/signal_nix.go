@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package client
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyReload relays SIGHUP, which triggers the config reload
+func notifyReload(c chan os.Signal) {
+	signal.Notify(c, syscall.SIGHUP)
+}
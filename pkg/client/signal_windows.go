@@ -0,0 +1,8 @@
+package client
+
+import (
+	"os"
+)
+
+// notifyReload is a noop in Windows, where there is no SIGHUP
+func notifyReload(c chan os.Signal) {}
@@ -0,0 +1,53 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/kayrus/gof5/pkg/util"
+)
+
+// State describes an established VPN connection
+type State struct {
+	PID       int       `json:"pid"`
+	Server    string    `json:"server"`
+	Interface string    `json:"interface"`
+	LocalIP   net.IP    `json:"localIP"`
+	Since     time.Time `json:"since"`
+}
+
+func writeState(path string, state *State, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), util.DirMode(mode)); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to marshal state: %w", err)
+	}
+
+	if err := util.WriteFile(path, data, mode); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+
+	return nil
+}
+
+// ReadState reads the state file, written by a running client
+func ReadState(path string) (*State, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var state State
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse state file: %w", err)
+	}
+
+	return &state, nil
+}
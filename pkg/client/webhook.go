@@ -0,0 +1,115 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/kayrus/gof5/pkg/config"
+)
+
+const (
+	webhookTimeout      = 5 * time.Second
+	webhookConnected    = "connected"
+	webhookDisconnected = "disconnected"
+)
+
+// webhookEvent is posted to the webhookURL on connect and disconnect
+type webhookEvent struct {
+	Event     string    `json:"event"`
+	Timestamp time.Time `json:"timestamp"`
+	Server    string    `json:"server"`
+	Username  string    `json:"username,omitempty"`
+	LocalIP   net.IP    `json:"localIP,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+}
+
+// webhook notifies about the tunnel state changes, a nil webhook is a no-op
+type webhook struct {
+	url        string
+	authHeader string
+	server     string
+	username   string
+	client     *http.Client
+	// connected is set, when the connected event is sent, thus the
+	// disconnected event is sent only for the established tunnel
+	connected atomic.Bool
+	localIP   atomic.Value
+}
+
+func newWebhook(cfg *config.Config, opts *Options) *webhook {
+	if cfg.WebhookURL == "" {
+		return nil
+	}
+	return &webhook{
+		url:        cfg.WebhookURL,
+		authHeader: cfg.WebhookAuthHeader,
+		server:     opts.Server,
+		username:   opts.Username,
+		client:     &http.Client{Timeout: webhookTimeout},
+	}
+}
+
+// sendConnected sends the connected event in the background
+func (w *webhook) sendConnected(localIP net.IP) {
+	if w == nil {
+		return
+	}
+	w.connected.Store(true)
+	w.localIP.Store(localIP)
+	go w.send(webhookConnected, localIP, "")
+}
+
+// sendDisconnected sends the disconnected event, it blocks not longer than
+// the webhook timeout
+func (w *webhook) sendDisconnected(reason string) {
+	if w == nil || !w.connected.Load() {
+		return
+	}
+	localIP, _ := w.localIP.Load().(net.IP)
+	w.send(webhookDisconnected, localIP, reason)
+}
+
+func (w *webhook) send(event string, localIP net.IP, reason string) {
+	if err := w.post(event, localIP, reason); err != nil {
+		log.Printf("Warning: failed to send %s webhook event: %s", event, err)
+	}
+}
+
+func (w *webhook) post(event string, localIP net.IP, reason string) error {
+	body, err := json.Marshal(&webhookEvent{
+		Event:     event,
+		Timestamp: time.Now().UTC(),
+		Server:    w.server,
+		Username:  w.username,
+		LocalIP:   localIP,
+		Reason:    reason,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.authHeader != "" {
+		req.Header.Set("Authorization", w.authHeader)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("wrong response code: %d", resp.StatusCode)
+	}
+	return nil
+}
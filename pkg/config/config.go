@@ -1,14 +1,26 @@
 package config
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"log"
 	"net"
+	"net/url"
 	"os"
+	"os/exec"
 	"os/user"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/kayrus/gof5/pkg/util"
 
@@ -18,16 +30,104 @@ import (
 const (
 	configDir  = ".gof5"
 	configName = "config.yaml"
+	minMTU     = 576
+	dnsPort    = 53
+	maxMTU     = 9000
+
+	defaultDialTimeout    = 10 * time.Second
+	defaultRequestTimeout = 30 * time.Second
+	defaultDNSTimeout     = 2 * time.Second
+	defaultDNSNegativeTTL = 30 * time.Second
+	defaultOnDemandListen = "127.0.0.1:5354"
+	defaultOnDemandIdle   = 10 * time.Minute
+	defaultSOCKSListen    = "127.0.0.1:1080"
+	defaultScriptTimeout  = 30 * time.Second
+	defaultWinTunAttempts = 3
+	defaultWinTunDelay    = time.Second
+
+	minTunnelBufferSize = 1500
+	maxTunnelBufferSize = 65536
+	maxTunnelQueueDepth = 4096
+	maxDNSAttempts      = 5
+	maxWinTunAttempts   = 10
+	maxTunnelBatchSize  = 256
+	maxInstanceLen      = 32
 )
 
+// DefaultUserAgent is the User-Agent of the logon, profile and session
+// requests, when the userAgent option is not set; the gof5 binary appends its
+// version
+var DefaultUserAgent = "gof5"
+
 var (
 	defaultDNSListenAddr = net.IPv4(127, 0, 0, 0xf5).To4()
 	// BSD systems don't support listeniing on 127.0.0.1+N
 	defaultBSDDNSListenAddr = net.IPv4(127, 0, 0, 1).To4()
-	supportedDrivers        = []string{"wireguard", "pppd"}
+	supportedDrivers        = []string{"wireguard", "pppd", "netstack"}
+	supportedLogFormats     = []string{"text", "json"}
+	supportedTLSVersions    = []string{"1.2", "1.3"}
+	supportedDNSMethods     = []string{"auto", "resolvconf", "resolved", "nmcli"}
+	supportedFamilies       = []string{"both", "ipv4", "ipv6"}
+	utunRegexp              = regexp.MustCompile(`^utun[0-9]*$`)
+	envRegexp               = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+	// client OS names, reported to F5
+	reportedOSNames = map[string]string{
+		"linux":   "Linux",
+		"darwin":  "MacOS",
+		"windows": "Windows",
+		"freebsd": "FreeBSD",
+	}
 )
 
-func ReadConfig(debug bool, customConfigPath string) (*Config, error) {
+// Error is returned, when the config cannot be read or contains invalid
+// options
+type Error struct {
+	Err error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// DriverError is returned, when the configured tunnel driver is not available
+// on the system
+type DriverError struct {
+	Err error
+}
+
+func (e *DriverError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *DriverError) Unwrap() error {
+	return e.Err
+}
+
+// Dir returns the gof5 config directory in the home directory:
+// $XDG_CONFIG_HOME/gof5, when the variable is set and the legacy ~/.gof5
+// directory doesn't exist, and ~/.gof5 otherwise. Windows and macOS always use
+// ~/.gof5.
+func Dir(homeDir string) string {
+	legacy := filepath.Join(homeDir, configDir)
+	if runtime.GOOS == "windows" || runtime.GOOS == "darwin" {
+		return legacy
+	}
+	xdg := os.Getenv("XDG_CONFIG_HOME")
+	if xdg == "" {
+		return legacy
+	}
+	if _, err := os.Stat(legacy); err == nil {
+		return legacy
+	}
+	return filepath.Join(xdg, "gof5")
+}
+
+// lookupUser returns the current user or the sudo user
+func lookupUser() (*user.User, error) {
 	var err error
 	var usr *user.User
 
@@ -51,6 +151,32 @@ func ReadConfig(debug bool, customConfigPath string) (*Config, error) {
 		}
 	}
 
+	return usr, nil
+}
+
+// ReadConfig reads the config file, the named profile, when not empty, is
+// merged over the top-level options. A missing default config file results in
+// the defaults, unless requireConfig is set, a missing custom config file is
+// always an error. The config file, accessible by group or others, is refused
+// with strictPerms. The returned error is either an *Error or a *DriverError.
+func ReadConfig(debug bool, customConfigPath, profile string, requireConfig, strictPerms bool) (*Config, error) {
+	cfg, err := readConfig(debug, customConfigPath, profile, requireConfig, strictPerms)
+	if err != nil {
+		var e *DriverError
+		if errors.As(err, &e) {
+			return nil, err
+		}
+		return nil, &Error{err}
+	}
+	return cfg, nil
+}
+
+func readConfig(debug bool, customConfigPath, profile string, requireConfig, strictPerms bool) (*Config, error) {
+	usr, err := lookupUser()
+	if err != nil {
+		return nil, err
+	}
+
 	var configPath string
 	var configFile string
 
@@ -60,10 +186,26 @@ func ReadConfig(debug bool, customConfigPath string) (*Config, error) {
 		configPath = filepath.Dir(customConfigPath)
 	} else {
 		// Use default config path
-		configPath = filepath.Join(usr.HomeDir, configDir)
+		configPath = Dir(usr.HomeDir)
 		configFile = filepath.Join(configPath, configName)
 	}
 
+	cfg := &Config{}
+	// read config file, a mistyped custom path must not create a directory
+	// if the default config doesn't exist, use defaults
+	if raw, err := os.ReadFile(configFile); err == nil {
+		if err = CheckFilePerms(configFile, strictPerms); err != nil {
+			return nil, err
+		}
+		if err = unmarshalConfig(raw, cfg, configFile); err != nil {
+			return nil, fmt.Errorf("cannot parse %s file: %v", configFile, err)
+		}
+	} else if customConfigPath != "" || requireConfig {
+		return nil, fmt.Errorf("cannot read config file: %s", err)
+	} else {
+		log.Printf("Cannot read config file: %s", err)
+	}
+
 	var uid, gid int
 	// windows preserves the original user parameters, no need to detect uid/gid
 	if runtime.GOOS != "windows" {
@@ -92,50 +234,21 @@ func ReadConfig(debug bool, customConfigPath string) (*Config, error) {
 		return nil, fmt.Errorf("failed to get %q directory stat: %s", configPath, err)
 	}
 
-	cfg := &Config{}
-	// read config file
-	// if config doesn't exist, use defaults
-	if raw, err := os.ReadFile(configFile); err == nil {
-		if err = yaml.Unmarshal(raw, cfg); err != nil {
-			return nil, fmt.Errorf("cannot parse %s file: %v", configFile, err)
-		}
-	} else {
-		log.Printf("Cannot read config file: %s", err)
-	}
-
-	// set default driver
-	if cfg.Driver == "" {
-		cfg.Driver = "wireguard"
-	}
-
-	if cfg.Driver == "wireguard" {
-		if err := checkWinTunDriver(); err != nil {
+	if profile != "" {
+		if err := cfg.applyProfile(profile); err != nil {
 			return nil, err
 		}
 	}
 
-	if cfg.Driver == "pppd" && runtime.GOOS == "windows" {
-		return nil, fmt.Errorf("pppd driver is not supported in Windows")
-	}
-
-	if !util.StrSliceContains(supportedDrivers, cfg.Driver) {
-		return nil, fmt.Errorf("%q driver is unsupported, supported drivers are: %q", cfg.Driver, supportedDrivers)
-	}
-
-	if cfg.ListenDNS == nil {
-		switch runtime.GOOS {
-		case "freebsd",
-			"darwin":
-			cfg.ListenDNS = defaultBSDDNSListenAddr
-		default:
-			cfg.ListenDNS = defaultDNSListenAddr
-		}
+	if errs := cfg.validate(); len(errs) > 0 {
+		return nil, errs[0]
 	}
 
 	cfg.Path = configPath
 
-	// Always use ~/.gof5 for cookies regardless of custom config path
-	cookiePath := filepath.Join(usr.HomeDir, configDir)
+	// Always use the default config directory for cookies regardless of custom
+	// config path
+	cookiePath := Dir(usr.HomeDir)
 	if cookiePath != configPath {
 		// Ensure the cookie directory exists when using custom config
 		if _, err := os.Stat(cookiePath); os.IsNotExist(err) {
@@ -160,3 +273,776 @@ func ReadConfig(debug bool, customConfigPath string) (*Config, error) {
 
 	return cfg, nil
 }
+
+// CheckConfig parses and validates the config file without creating any
+// directories, all found problems are returned
+func CheckConfig(customConfigPath string) []error {
+	configFile := customConfigPath
+	if configFile == "" {
+		usr, err := lookupUser()
+		if err != nil {
+			return []error{err}
+		}
+		configFile = filepath.Join(Dir(usr.HomeDir), configName)
+	}
+
+	raw, err := os.ReadFile(configFile)
+	if err != nil {
+		return []error{fmt.Errorf("cannot read config file: %s", err)}
+	}
+
+	cfg := &Config{}
+	if err = unmarshalConfig(raw, cfg, configFile); err != nil {
+		return []error{fmt.Errorf("cannot parse %s file: %v", configFile, err)}
+	}
+
+	// validate every profile merged over the top-level options, the problems
+	// inherited from the top-level options are reported only once
+	errs := cfg.validate()
+	var names []string
+	for name := range cfg.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		p := &Config{}
+		if err = unmarshalConfig(raw, p, configFile); err != nil {
+			return []error{fmt.Errorf("cannot parse %s file: %v", configFile, err)}
+		}
+		if err = p.applyProfile(name); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, err := range p.validate() {
+			if !containsError(errs, err) {
+				errs = append(errs, fmt.Errorf("%q profile: %v", name, err))
+			}
+		}
+	}
+
+	return errs
+}
+
+// unmarshalConfig expands the ${VAR} and ${VAR:-default} environment variables
+// in the raw YAML or JSON config, unless disableEnvExpansion is set, and
+// parses it
+func unmarshalConfig(raw []byte, cfg *Config, path string) error {
+	if isJSON(raw, path) {
+		// convert JSON to YAML to reuse the YAML unmarshallers
+		var v interface{}
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return err
+		}
+		var err error
+		if raw, err = yaml.Marshal(v); err != nil {
+			return err
+		}
+	}
+
+	var v struct {
+		DisableEnvExpansion bool `yaml:"disableEnvExpansion"`
+	}
+	if err := yaml.Unmarshal(raw, &v); err != nil {
+		return err
+	}
+
+	if !v.DisableEnvExpansion {
+		raw = expandEnv(raw)
+	}
+
+	return yaml.Unmarshal(raw, cfg)
+}
+
+// isJSON detects the config format by the file extension or by the first
+// non-space character, when the extension is unknown
+func isJSON(raw []byte, path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return true
+	case ".yaml", ".yml":
+		return false
+	}
+	v := bytes.TrimSpace(raw)
+	return len(v) > 0 && v[0] == '{'
+}
+
+// expandEnv replaces only the ${VAR} and ${VAR:-default} forms, thus a
+// literal "$" is preserved, missing variables expand to an empty string
+func expandEnv(raw []byte) []byte {
+	return envRegexp.ReplaceAllFunc(raw, func(m []byte) []byte {
+		v := envRegexp.FindSubmatch(m)
+		if val, ok := os.LookupEnv(string(v[1])); ok && (val != "" || v[2] == nil) {
+			return []byte(val)
+		}
+		return v[3]
+	})
+}
+
+func containsError(errs []error, err error) bool {
+	for _, e := range errs {
+		if e.Error() == err.Error() {
+			return true
+		}
+	}
+	return false
+}
+
+// validate sets the defaults and returns all found config problems
+func (r *Config) validate() []error {
+	var errs []error
+
+	// set default driver
+	if r.Driver == "" {
+		r.Driver = "wireguard"
+	}
+
+	if r.WinTunAttempts == 0 {
+		r.WinTunAttempts = defaultWinTunAttempts
+	}
+	if r.WinTunAttempts < 0 || r.WinTunAttempts > maxWinTunAttempts {
+		errs = append(errs, fmt.Errorf("wintunAttempts must be between 1 and %d", maxWinTunAttempts))
+		r.WinTunAttempts = 1
+	}
+	if r.WinTunRetryDelay == 0 {
+		r.WinTunRetryDelay = defaultWinTunDelay
+	}
+	if r.WinTunRetryDelay < 0 {
+		errs = append(errs, fmt.Errorf("wintunRetryDelay cannot be negative"))
+		r.WinTunRetryDelay = 0
+	}
+
+	if r.Driver == "auto" {
+		driver, err := detectDriver(r)
+		if err != nil {
+			errs = append(errs, &DriverError{err})
+		} else {
+			log.Printf("Auto-detected %q driver", driver)
+			r.Driver = driver
+		}
+	}
+
+	if r.Driver == "wireguard" {
+		if err := checkWinTunDriver(r.WinTunAttempts, r.WinTunRetryDelay); err != nil {
+			errs = append(errs, &DriverError{err})
+		}
+	}
+
+	if r.Driver == "pppd" && runtime.GOOS == "windows" {
+		errs = append(errs, &DriverError{fmt.Errorf("pppd driver is not supported in Windows")})
+	}
+
+	if r.PPPdPath != "" {
+		if err := checkPPPdPath(r.PPPdPath); err != nil {
+			errs = append(errs, &DriverError{err})
+		}
+	}
+
+	if r.Driver != "auto" && !util.StrSliceContains(supportedDrivers, r.Driver) {
+		errs = append(errs, fmt.Errorf("%q driver is unsupported, supported drivers are: %q", r.Driver, supportedDrivers))
+	}
+
+	if r.Driver == "netstack" {
+		if r.SOCKSListen == "" {
+			r.SOCKSListen = defaultSOCKSListen
+		}
+		if _, _, err := net.SplitHostPort(r.SOCKSListen); err != nil {
+			errs = append(errs, fmt.Errorf("invalid socksListen address: %s", err))
+		}
+	}
+
+	if r.ProxyListen != "" {
+		if r.Driver == "netstack" {
+			errs = append(errs, fmt.Errorf("proxyListen option cannot be used with the netstack driver, use socksListen instead"))
+		} else if _, _, err := net.SplitHostPort(r.ProxyListen); err != nil {
+			errs = append(errs, fmt.Errorf("invalid proxyListen address: %s", err))
+		}
+	}
+
+	if r.InterfaceName != "" {
+		if err := checkInterfaceName(r.InterfaceName, r.Driver); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if r.DNSMethod == "" {
+		r.DNSMethod = "auto"
+	}
+
+	if !util.StrSliceContains(supportedDNSMethods, r.DNSMethod) {
+		errs = append(errs, fmt.Errorf("%q DNS method is unsupported, supported methods are: %q", r.DNSMethod, supportedDNSMethods))
+	} else if (r.DNSMethod == "resolved" || r.DNSMethod == "nmcli") && runtime.GOOS != "linux" {
+		errs = append(errs, fmt.Errorf("%q DNS method is supported only in Linux", r.DNSMethod))
+	} else if r.DNSMethod == "resolvconf" && (runtime.GOOS == "windows" || runtime.GOOS == "darwin") {
+		errs = append(errs, fmt.Errorf("%q DNS method is not supported in %s", r.DNSMethod, runtime.GOOS))
+	}
+
+	for i, v := range r.SearchDomains {
+		v = strings.Trim(strings.TrimSpace(v), ".")
+		if v == "" || strings.ContainsAny(v, " \t,") {
+			errs = append(errs, fmt.Errorf("invalid %q search domain", r.SearchDomains[i]))
+			continue
+		}
+		r.SearchDomains[i] = v
+	}
+
+	if r.LogFormat == "" {
+		r.LogFormat = "text"
+	}
+
+	if !util.StrSliceContains(supportedLogFormats, r.LogFormat) {
+		errs = append(errs, fmt.Errorf("%q log format is unsupported, supported formats are: %q", r.LogFormat, supportedLogFormats))
+	}
+
+	if r.TunnelBufferSize != 0 && (r.TunnelBufferSize < minTunnelBufferSize || r.TunnelBufferSize > maxTunnelBufferSize) {
+		errs = append(errs, fmt.Errorf("tunnelBufferSize must be between %d and %d", minTunnelBufferSize, maxTunnelBufferSize))
+	}
+
+	if r.TunnelQueueDepth < 0 || r.TunnelQueueDepth > maxTunnelQueueDepth {
+		errs = append(errs, fmt.Errorf("tunnelQueueDepth must be between 0 and %d", maxTunnelQueueDepth))
+	}
+
+	if r.TunnelBatchSize < 0 || r.TunnelBatchSize > maxTunnelBatchSize {
+		errs = append(errs, fmt.Errorf("tunnelBatchSize must be between 0 and %d", maxTunnelBatchSize))
+	}
+
+	if r.WebhookURL != "" {
+		if u, err := url.Parse(r.WebhookURL); err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			errs = append(errs, fmt.Errorf("webhookURL must be an http:// or https:// URL"))
+		}
+	}
+
+	if r.ScriptTimeout == 0 {
+		r.ScriptTimeout = defaultScriptTimeout
+	}
+	if r.ScriptTimeout < 0 {
+		errs = append(errs, fmt.Errorf("scriptTimeout cannot be negative"))
+	}
+	for _, v := range []string{r.UpScript, r.DownScript} {
+		if v != "" && !filepath.IsAbs(v) {
+			errs = append(errs, fmt.Errorf("%q script path must be absolute", v))
+		}
+	}
+
+	if r.Proxy != "" {
+		if err := CheckProxy(r.Proxy); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if r.RequestedAddress != "" {
+		if ip := net.ParseIP(r.RequestedAddress); ip == nil || ip.To4() == nil {
+			errs = append(errs, fmt.Errorf("requestedAddress %q is not an IPv4 address", r.RequestedAddress))
+		}
+	}
+
+	if r.SourceAddress != "" || r.SourceInterface != "" {
+		if err := checkSource(r.SourceAddress, r.SourceInterface); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if r.LogonRetries < 0 {
+		errs = append(errs, fmt.Errorf("logonRetries cannot be negative"))
+	}
+
+	if r.RouteMetric < 0 {
+		errs = append(errs, fmt.Errorf("routeMetric cannot be negative"))
+	}
+
+	if r.LogMaxSizeMB < 0 || r.LogMaxBackups < 0 {
+		errs = append(errs, fmt.Errorf("logMaxSizeMB and logMaxBackups cannot be negative"))
+	}
+
+	if r.RuntimeFileMode == "" {
+		r.RuntimeFileMode = "0644"
+	}
+	if v, err := strconv.ParseUint(r.RuntimeFileMode, 8, 32); err != nil || v > 0777 {
+		errs = append(errs, fmt.Errorf("invalid %q runtimeFileMode, an octal mode, e.g. \"0600\", is expected", r.RuntimeFileMode))
+	} else if v&0600 != 0600 {
+		errs = append(errs, fmt.Errorf("%q runtimeFileMode must allow the owner to read and write", r.RuntimeFileMode))
+	} else {
+		r.FileMode = os.FileMode(v)
+	}
+
+	if r.KeepaliveInterval != 0 && r.KeepaliveInterval < time.Second {
+		errs = append(errs, fmt.Errorf("%s keepalive interval is too short, it must be at least 1s", r.KeepaliveInterval))
+	}
+
+	if r.IdleTimeout != 0 {
+		switch {
+		case r.IdleTimeout < 10*time.Second:
+			errs = append(errs, fmt.Errorf("%s idleTimeout is too short, it must be at least 10s", r.IdleTimeout))
+		case r.Driver == "pppd":
+			// the raw pppd stream contains the pppd LCP echoes
+			errs = append(errs, fmt.Errorf("idleTimeout option cannot be used with the pppd driver"))
+		}
+	}
+
+	if r.ReportedHostname == "" {
+		if v, err := os.Hostname(); err == nil {
+			r.ReportedHostname = v
+		}
+	}
+
+	if r.UserAgent == "" {
+		r.UserAgent = DefaultUserAgent
+	}
+
+	if r.ReportedOS == "" {
+		r.ReportedOS = reportedOSNames[runtime.GOOS]
+		if r.ReportedOS == "" {
+			r.ReportedOS = runtime.GOOS
+		}
+	}
+
+	if r.DialTimeout == 0 {
+		r.DialTimeout = defaultDialTimeout
+	}
+
+	if r.RequestTimeout == 0 {
+		r.RequestTimeout = defaultRequestTimeout
+	}
+
+	if r.DialTimeout < 0 || r.RequestTimeout < 0 {
+		errs = append(errs, fmt.Errorf("dialTimeout and requestTimeout cannot be negative"))
+	}
+
+	if r.DNSTimeout == 0 {
+		r.DNSTimeout = defaultDNSTimeout
+	}
+
+	if r.DNSAttempts == 0 {
+		r.DNSAttempts = 1
+	}
+
+	if r.DNSTimeout < 0 {
+		errs = append(errs, fmt.Errorf("dnsTimeout cannot be negative"))
+	}
+
+	if r.DNSAttempts < 0 || r.DNSAttempts > maxDNSAttempts {
+		errs = append(errs, fmt.Errorf("dnsAttempts must be between 1 and %d", maxDNSAttempts))
+	}
+
+	if r.DNSNegativeCacheTTL == 0 {
+		r.DNSNegativeCacheTTL = defaultDNSNegativeTTL
+	}
+
+	if len(r.OnDemandDomains) > 0 {
+		if r.OnDemandListen == "" {
+			r.OnDemandListen = defaultOnDemandListen
+		}
+		if _, _, err := net.SplitHostPort(r.OnDemandListen); err != nil {
+			errs = append(errs, fmt.Errorf("failed to parse onDemandListen: %s", err))
+		}
+		if r.OnDemandIdleTimeout == 0 {
+			r.OnDemandIdleTimeout = defaultOnDemandIdle
+		}
+		if r.OnDemandIdleTimeout < 10*time.Second {
+			errs = append(errs, fmt.Errorf("%s onDemandIdleTimeout is too short, it must be at least 10s", r.OnDemandIdleTimeout))
+		}
+	}
+
+	if r.DNSCacheSize < 0 || r.DNSNegativeCacheTTL < 0 {
+		errs = append(errs, fmt.Errorf("dnsCacheSize and dnsNegativeCacheTTL cannot be negative"))
+	}
+
+	if r.TLSMinVersion == "" {
+		r.TLSMinVersion = "1.2"
+	}
+
+	if !util.StrSliceContains(supportedTLSVersions, r.TLSMinVersion) {
+		errs = append(errs, fmt.Errorf("%q TLS version is unsupported, supported minimum versions are: %q", r.TLSMinVersion, supportedTLSVersions))
+	}
+
+	for i, pin := range r.ServerCertPins {
+		pin = strings.ToLower(strings.ReplaceAll(pin, ":", ""))
+		if v, err := hex.DecodeString(pin); err != nil || len(v) != sha256.Size {
+			errs = append(errs, fmt.Errorf("%q is not a valid SHA-256 certificate fingerprint", r.ServerCertPins[i]))
+			continue
+		}
+		r.ServerCertPins[i] = pin
+	}
+
+	if r.PinOnly && len(r.ServerCertPins) == 0 {
+		errs = append(errs, fmt.Errorf("pinOnly option requires serverCertPins"))
+	}
+
+	if r.ManageRoutes == nil {
+		v := true
+		r.ManageRoutes = &v
+	}
+
+	if err := CheckNetNS(r); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := CheckDropPrivileges(r); err != nil {
+		errs = append(errs, err)
+	}
+
+	if r.KillSwitch {
+		switch {
+		case !*r.ManageRoutes:
+			errs = append(errs, fmt.Errorf("killSwitch option cannot be used with manageRoutes: false"))
+		case runtime.GOOS != "linux" && runtime.GOOS != "darwin" && runtime.GOOS != "windows":
+			errs = append(errs, fmt.Errorf("killSwitch option is not supported in %s", runtime.GOOS))
+		case r.Driver == "netstack":
+			errs = append(errs, fmt.Errorf("killSwitch option cannot be used with the netstack driver"))
+		}
+	}
+
+	if r.DisableIPv6 && runtime.GOOS != "linux" {
+		errs = append(errs, fmt.Errorf("disableIPv6 option is supported only in Linux"))
+	}
+
+	if r.AddressFamilies == "" {
+		r.AddressFamilies = "both"
+	}
+
+	if !util.StrSliceContains(supportedFamilies, r.AddressFamilies) {
+		errs = append(errs, fmt.Errorf("%q address families are unsupported, supported values are: %q", r.AddressFamilies, supportedFamilies))
+	}
+
+	if r.AddressFamilies == "ipv6" {
+		if r.DisableIPv6 {
+			errs = append(errs, fmt.Errorf("addressFamilies: ipv6 option cannot be used with disableIPv6"))
+		}
+		// IPv6 must be negotiated with F5
+		r.IPv6 = true
+	}
+
+	if r.DisableIPv6 && r.IPv6 {
+		log.Printf("IPv6 is disabled, ignoring the ipv6 option")
+		r.IPv6 = false
+	}
+
+	if r.MTU != 0 && (r.MTU < minMTU || r.MTU > maxMTU) {
+		errs = append(errs, fmt.Errorf("%d MTU is out of range, it must be between %d and %d", r.MTU, minMTU, maxMTU))
+	}
+
+	if r.MTUProbe || r.AutoMTU {
+		switch {
+		case runtime.GOOS != "linux":
+			errs = append(errs, fmt.Errorf("mtuProbe and autoMTU options are supported only in Linux"))
+		case r.Driver == "netstack":
+			errs = append(errs, fmt.Errorf("mtuProbe and autoMTU options cannot be used with the netstack driver"))
+		}
+	}
+
+	if r.ListenDNS == nil {
+		switch runtime.GOOS {
+		case "freebsd",
+			"darwin":
+			r.ListenDNS = defaultBSDDNSListenAddr
+		default:
+			r.ListenDNS = defaultDNSListenAddr
+		}
+	}
+
+	if r.ListenDNSPort == 0 {
+		r.ListenDNSPort = dnsPort
+	}
+	if r.ListenDNSPort < 0 || r.ListenDNSPort > 65535 {
+		errs = append(errs, fmt.Errorf("%d DNS listen port is out of range", r.ListenDNSPort))
+	}
+
+	return errs
+}
+
+// CheckFilePerms warns, when the file is accessible by group or others, the
+// strict mode refuses such a file, like ssh does with the private keys. The
+// Unix permission bits don't apply in Windows, therefore the check is skipped.
+func CheckFilePerms(path string, strict bool) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	if perm := fi.Mode().Perm(); perm&0077 != 0 {
+		if strict {
+			return fmt.Errorf("%q permissions %04o are too open, it must not be accessible by group or others, run \"chmod 600 %s\"", path, perm, path)
+		}
+		log.Printf("Warning: %q permissions %04o are too open, it is accessible by group or others", path, perm)
+	}
+	return nil
+}
+
+// CheckProxy validates the logon proxy URL
+func CheckProxy(proxy string) error {
+	u, err := url.Parse(proxy)
+	if err != nil {
+		// the url.Error contains the URL with the credentials
+		if e, ok := err.(*url.Error); ok {
+			err = e.Err
+		}
+		return fmt.Errorf("failed to parse proxy URL: %s", err)
+	}
+	if u.Host == "" || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5") {
+		return fmt.Errorf("proxy must be an http://, https:// or socks5:// URL")
+	}
+	return nil
+}
+
+// CheckNetNS validates the netns option against the options, which cannot be
+// used in a network namespace
+func CheckNetNS(r *Config) error {
+	if r.NetNS == "" {
+		return nil
+	}
+	switch {
+	case runtime.GOOS != "linux":
+		return fmt.Errorf("netns option is supported only in Linux")
+	case r.Driver != "wireguard":
+		return fmt.Errorf("netns option requires the wireguard driver")
+	case r.KillSwitch:
+		return fmt.Errorf("netns option cannot be used with killSwitch")
+	case len(r.ExcludeRoutes) > 0:
+		return fmt.Errorf("netns option cannot be used with excludeRoutes, the namespace has no route to the excluded subnets")
+	case len(r.DNS) > 0 || r.ProxyListen != "" || r.SOCKSListen != "":
+		return fmt.Errorf("netns option cannot be used with the local DNS, HTTP and SOCKS proxies")
+	}
+	return nil
+}
+
+// CheckInstance validates the instance name, which is used in the file and
+// interface names
+func CheckInstance(name string) error {
+	if name == "" {
+		return nil
+	}
+	if len(name) > maxInstanceLen {
+		return fmt.Errorf("%q instance name is too long, it must not exceed %d characters", name, maxInstanceLen)
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return fmt.Errorf("%q instance name must contain only letters, digits, \"-\" and \"_\"", name)
+		}
+	}
+	return nil
+}
+
+// SkipFamily reports, whether the IPv4 or the IPv6 routes and DNS records are
+// excluded by the addressFamilies option or skipped, because the host has no
+// address of the family
+func (r *Config) SkipFamily(ipv6 bool) bool {
+	switch r.AddressFamilies {
+	case "ipv4":
+		return ipv6
+	case "ipv6":
+		return !ipv6
+	}
+	if ipv6 {
+		return r.NoLocalIPv6
+	}
+	return r.NoLocalIPv4
+}
+
+// TunnelIPv4 reports, whether the IPv4 routes and DNS servers are applied
+func (r *Config) TunnelIPv4() bool {
+	return !r.SkipFamily(false)
+}
+
+// TunnelIPv6 reports, whether IPv6 is negotiated with F5 and the IPv6
+// address, routes and DNS servers are applied
+func (r *Config) TunnelIPv6() bool {
+	return r.IPv6 && r.F5Config != nil && bool(r.F5Config.Object.IPv6) && !r.SkipFamily(true)
+}
+
+// TunneledIPs returns the addresses of the families, which are not skipped
+func (r *Config) TunneledIPs(ips []net.IP) []net.IP {
+	skip4, skip6 := r.SkipFamily(false), r.SkipFamily(true)
+	if !skip4 && !skip6 {
+		return ips
+	}
+	var v []net.IP
+	for _, ip := range ips {
+		if ip.To4() != nil && skip4 || ip.To4() == nil && skip6 {
+			continue
+		}
+		v = append(v, ip)
+	}
+	return v
+}
+
+// SetInstance separates the settings of the simultaneous gof5 processes: the
+// DNS listener in Linux gets an instance specific loopback address, unless a
+// custom listenDNS is set, and the Windows interface is named after the
+// instance, unless interfaceName is set. The instance names may share the
+// address, the DNS proxy shifts it on the bind failure, see
+// InstanceListenDNS.
+func (r *Config) SetInstance(name string) {
+	r.Instance = name
+	if name == "" {
+		return
+	}
+
+	if runtime.GOOS == "linux" && r.ListenDNS.Equal(defaultDNSListenAddr) {
+		h := fnv.New32a()
+		h.Write([]byte(name))
+		r.ListenDNS = net.IPv4(127, 0, byte(1+h.Sum32()%254), 0xf5).To4()
+		r.InstanceListenDNS = true
+	}
+	if runtime.GOOS == "windows" && r.InterfaceName == "" {
+		r.InterfaceName = "gof5-" + name
+	}
+}
+
+// CheckDropPrivileges validates the dropPrivileges option against the
+// options, which require the privileges after the tunnel is established
+func CheckDropPrivileges(r *Config) error {
+	if !r.DropPrivileges {
+		return nil
+	}
+	switch {
+	case runtime.GOOS != "linux":
+		return fmt.Errorf("dropPrivileges option is supported only in Linux")
+	case r.Driver == "pppd":
+		return fmt.Errorf("dropPrivileges option cannot be used with the pppd driver")
+	case r.KillSwitch:
+		return fmt.Errorf("dropPrivileges option cannot be used with killSwitch")
+	case r.NetNS != "":
+		return fmt.Errorf("dropPrivileges option cannot be used with netns")
+	case r.Reconnect || len(r.OnDemandDomains) > 0:
+		return fmt.Errorf("dropPrivileges option cannot be used with reconnect and onDemandDomains, the tunnel setup requires the privileges")
+	case r.DownScript != "":
+		return fmt.Errorf("dropPrivileges option cannot be used with downScript, the script would run without the privileges to undo the up script actions")
+	}
+	return nil
+}
+
+// checkSource verifies, that the source address and the source interface
+// belong to the local interfaces
+func checkSource(addr, name string) error {
+	var ip net.IP
+	if addr != "" {
+		if ip = net.ParseIP(addr); ip == nil {
+			return fmt.Errorf("sourceAddress %q is not a valid IP address", addr)
+		}
+	}
+
+	var addrs []net.Addr
+	var err error
+	if name != "" {
+		iface, err := net.InterfaceByName(name)
+		if err != nil {
+			return fmt.Errorf("sourceInterface %q is not found: %s", name, err)
+		}
+		if ip == nil {
+			return nil
+		}
+		addrs, err = iface.Addrs()
+		if err != nil {
+			return fmt.Errorf("failed to get %s interface addresses: %s", name, err)
+		}
+	} else if addrs, err = net.InterfaceAddrs(); err != nil {
+		return fmt.Errorf("failed to get local interface addresses: %s", err)
+	}
+
+	for _, v := range addrs {
+		if n, ok := v.(*net.IPNet); ok && n.IP.Equal(ip) {
+			return nil
+		}
+	}
+	if name != "" {
+		return fmt.Errorf("sourceAddress %s doesn't belong to the %s interface", ip, name)
+	}
+	return fmt.Errorf("sourceAddress %s doesn't belong to any local interface", ip)
+}
+
+// checkInterfaceName verifies, that the tunnel interface name can be used in
+// the current OS
+func checkInterfaceName(name, driver string) error {
+	if driver == "pppd" && runtime.GOOS != "linux" {
+		return fmt.Errorf("interfaceName option with the pppd driver is supported only in Linux")
+	}
+
+	switch runtime.GOOS {
+	case "darwin":
+		if !utunRegexp.MatchString(name) {
+			return fmt.Errorf("%q interface name is invalid, only \"utun\" or \"utunN\" names are allowed in macOS", name)
+		}
+	case "windows":
+		if len(name) > 127 {
+			return fmt.Errorf("%q interface name is too long, the limit is 127 characters", name)
+		}
+	default:
+		// IFNAMSIZ includes the terminating zero
+		if len(name) > 15 {
+			return fmt.Errorf("%q interface name is too long, the limit is 15 characters", name)
+		}
+		if name == "." || name == ".." || strings.ContainsAny(name, "/:% \t\n") {
+			return fmt.Errorf("%q interface name is invalid", name)
+		}
+	}
+
+	return nil
+}
+
+// detectDriver returns the wireguard driver, when the tun device can be
+// created, and falls back to the pppd driver otherwise
+func detectDriver(r *Config) (string, error) {
+	err := checkTunDevice(r)
+	if err == nil {
+		return "wireguard", nil
+	}
+
+	if runtime.GOOS == "windows" {
+		return "", err
+	}
+
+	pppd := PPPdBinary(r.PPPdPath)
+	if _, e := exec.LookPath(pppd); e != nil {
+		return "", fmt.Errorf("failed to detect a driver: %s, and %s", err, e)
+	}
+
+	log.Printf("Falling back to the pppd driver: %s", err)
+	return "pppd", nil
+}
+
+// PPPdBinary returns the pppdPath option or the default pppd binary name, ppp
+// in FreeBSD
+func PPPdBinary(path string) string {
+	if path != "" {
+		return path
+	}
+	if runtime.GOOS == "freebsd" {
+		return "ppp"
+	}
+	return "pppd"
+}
+
+// checkPPPdPath verifies, that the pppdPath option is an absolute path to an
+// executable file
+func checkPPPdPath(path string) error {
+	if !filepath.IsAbs(path) {
+		return fmt.Errorf("%q pppdPath must be absolute", path)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("pppdPath is invalid: %s", err)
+	}
+	if !fi.Mode().IsRegular() || fi.Mode().Perm()&0111 == 0 {
+		return fmt.Errorf("%q pppdPath is not an executable file", path)
+	}
+	return nil
+}
+
+// checkTunDevice verifies, that the wireguard driver can create a tun device
+func checkTunDevice(r *Config) error {
+	switch runtime.GOOS {
+	case "windows":
+		return checkWinTunDriver(r.WinTunAttempts, r.WinTunRetryDelay)
+	case "linux":
+		if _, err := os.Stat("/dev/net/tun"); err != nil {
+			return fmt.Errorf("tun device is not available: %s", err)
+		}
+	case "freebsd":
+		if _, err := os.Stat("/dev/tun"); err != nil {
+			return fmt.Errorf("tun device is not available: %s", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestCheckConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("driver: foo\nmtu: 10\nlistenDNSPort: 70000\ntlsMinVersion: \"1.1\"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if errs := CheckConfig(path); len(errs) != 4 {
+		t.Errorf("expected 4 problems, got: %q", errs)
+	}
+
+	if err := os.WriteFile(path, []byte("driver: pppd\nroutes:\n- 10.0.0.0/8\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if errs := CheckConfig(path); len(errs) != 0 {
+		t.Errorf("expected no problems, got: %q", errs)
+	}
+}
+
+func TestExpandEnv(t *testing.T) {
+	t.Setenv("GOF5_TEST_SERVER", "vpn.example.com")
+	t.Setenv("GOF5_TEST_EMPTY", "")
+
+	for raw, expected := range map[string]string{
+		"server: ${GOF5_TEST_SERVER}":           "server: vpn.example.com",
+		"server: ${GOF5_TEST_MISSING}":          "server: ",
+		"server: ${GOF5_TEST_MISSING:-default}": "server: default",
+		"server: ${GOF5_TEST_EMPTY:-default}":   "server: default",
+		"server: ${GOF5_TEST_SERVER:-default}":  "server: vpn.example.com",
+		"password: pa$$word $GOF5_TEST_SERVER":  "password: pa$$word $GOF5_TEST_SERVER",
+	} {
+		if v := string(expandEnv([]byte(raw))); v != expected {
+			t.Errorf("expected %q, got %q", expected, v)
+		}
+	}
+}
+
+func TestCheckConfigJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte("{\n\t\"driver\": \"pppd\",\n\t\"routes\": [\"10.0.0.0/8\"],\n\t\"mtu\": 10\n}\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if errs := CheckConfig(path); len(errs) != 1 {
+		t.Errorf("expected 1 problem, got: %q", errs)
+	}
+}
+
+func TestCheckFilePerms(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Unix permissions don't apply in Windows")
+	}
+
+	path := filepath.Join(t.TempDir(), "user.key")
+	if err := os.WriteFile(path, []byte("key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckFilePerms(path, true); err != nil {
+		t.Errorf("expected 0600 file to pass, got: %s", err)
+	}
+
+	if err := os.Chmod(path, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckFilePerms(path, false); err != nil {
+		t.Errorf("expected a warning only, got: %s", err)
+	}
+	if err := CheckFilePerms(path, true); err == nil {
+		t.Errorf("expected 0644 file to be refused")
+	}
+}
+
+func TestCheckInstance(t *testing.T) {
+	for name, valid := range map[string]bool{
+		"":                                    true,
+		"customer1":                           true,
+		"corp_vpn-2":                          true,
+		"../etc":                              false,
+		"a b":                                 false,
+		strings.Repeat("a", maxInstanceLen+1): false,
+	} {
+		if err := CheckInstance(name); (err == nil) != valid {
+			t.Errorf("unexpected %q instance check result: %v", name, err)
+		}
+	}
+}
+
+func TestCheckPPPdPath(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Unix permissions don't apply in Windows")
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "pppd")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []string{"pppd", filepath.Join(dir, "missing"), dir, path} {
+		if err := checkPPPdPath(v); err == nil {
+			t.Errorf("expected %q pppdPath to fail", v)
+		}
+	}
+
+	if err := os.Chmod(path, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkPPPdPath(path); err != nil {
+		t.Errorf("expected executable pppdPath to pass, got: %s", err)
+	}
+}
+
+func TestSkipFamily(t *testing.T) {
+	ips := []net.IP{net.IPv4(10, 0, 0, 1), net.ParseIP("fd00::1")}
+	for _, v := range []struct {
+		families    string
+		noLocalIPv6 bool
+		skip4       bool
+		skip6       bool
+	}{
+		{"both", false, false, false},
+		{"both", true, false, true},
+		{"ipv4", false, false, true},
+		{"ipv6", true, true, false},
+	} {
+		cfg := &Config{AddressFamilies: v.families, NoLocalIPv6: v.noLocalIPv6}
+		if cfg.SkipFamily(false) != v.skip4 || cfg.SkipFamily(true) != v.skip6 {
+			t.Errorf("%+v: unexpected skipped families", v)
+		}
+		var expected []net.IP
+		if !v.skip4 {
+			expected = append(expected, ips[0])
+		}
+		if !v.skip6 {
+			expected = append(expected, ips[1])
+		}
+		if got := cfg.TunneledIPs(ips); fmt.Sprint(got) != fmt.Sprint(expected) {
+			t.Errorf("%+v: unexpected %v tunneled IPs", v, got)
+		}
+	}
+}
+
+func TestCheckDropPrivilegesDownScript(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("dropPrivileges option is supported only in Linux")
+	}
+
+	r := &Config{DropPrivileges: true, UpScript: "/usr/local/bin/vpn-up"}
+	if err := CheckDropPrivileges(r); err != nil {
+		t.Errorf("expected the up script to pass, got: %s", err)
+	}
+	r.DownScript = "/usr/local/bin/vpn-down"
+	if err := CheckDropPrivileges(r); err == nil {
+		t.Errorf("expected the down script to fail with dropPrivileges")
+	}
+}
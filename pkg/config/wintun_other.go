@@ -3,6 +3,10 @@
 
 package config
 
-func checkWinTunDriver() error {
+import (
+	"time"
+)
+
+func checkWinTunDriver(_ int, _ time.Duration) error {
 	return nil
 }
@@ -5,8 +5,10 @@ package config
 
 import (
 	"fmt"
+	"log"
 	"os"
 	"path/filepath"
+	"time"
 
 	"golang.org/x/sys/windows"
 )
@@ -16,15 +18,43 @@ const (
 	winTunSite = "https://www.wintun.net/"
 )
 
-func checkWinTunDriver() error {
-	err := windows.NewLazyDLL(winTun).Load()
+// checkWinTunDriver loads the wintun.dll, the load is retried, when the DLL
+// exists, since the driver may be not ready right after the installation
+func checkWinTunDriver(attempts int, delay time.Duration) error {
+	dir, err := filepath.Abs(filepath.Dir(os.Args[0]))
 	if err != nil {
-		dir, err := filepath.Abs(filepath.Dir(os.Args[0]))
-		if err != nil {
-			dir = "gof5"
+		dir = "gof5"
+	}
+
+	for i := 1; ; i++ {
+		err = windows.NewLazyDLL(winTun).Load()
+		if err == nil {
+			return nil
+		}
+		if !winTunExists(dir) {
+			return fmt.Errorf("the %s was not found, you can download it from %s and place it into the %q directory", winTun, winTunSite, dir)
 		}
-		return fmt.Errorf("the %s was not found, you can download it from %s and place it into the %q directory", winTun, winTunSite, dir)
+		if i >= attempts {
+			break
+		}
+		log.Printf("Failed to load the %s, retrying in %s: %s", winTun, delay, err)
+		time.Sleep(delay)
 	}
 
-	return nil
+	return fmt.Errorf("the %s exists, but cannot be loaded after %d attempts, reinstall it from %s or restart Windows: %s", winTun, attempts, winTunSite, err)
+}
+
+// winTunExists reports, whether the wintun.dll exists in the application or
+// the system directory, the DLL search paths
+func winTunExists(dir string) bool {
+	dirs := []string{dir}
+	if v, err := windows.GetSystemDirectory(); err == nil {
+		dirs = append(dirs, v)
+	}
+	for _, v := range dirs {
+		if _, err := os.Stat(filepath.Join(v, winTun)); err == nil {
+			return true
+		}
+	}
+	return false
 }
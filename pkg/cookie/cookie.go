@@ -8,6 +8,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
 	"syscall"
 
@@ -38,6 +39,16 @@ func parseCookies(configPath string) map[string][]string {
 	return cookies
 }
 
+// Servers returns a sorted list of servers with saved cookies
+func Servers(cookiePath string) []string {
+	var servers []string
+	for k := range parseCookies(cookiePath) {
+		servers = append(servers, k)
+	}
+	sort.Strings(servers)
+	return servers
+}
+
 func ReadCookies(c *http.Client, u *url.URL, cfg *config.Config, sessionID string) {
 	v := parseCookies(cfg.CookiePath)
 	if v, ok := v[u.Host]; ok {
@@ -50,6 +61,12 @@ func ReadCookies(c *http.Client, u *url.URL, cfg *config.Config, sessionID strin
 		c.Jar.SetCookies(u, cookies)
 	}
 
+	SetSessionID(c, u, sessionID)
+}
+
+// SetSessionID overrides the session cookie with the session ID from a CLI
+// argument
+func SetSessionID(c *http.Client, u *url.URL, sessionID string) {
 	if sessionID != "" {
 		log.Printf("Overriding session ID from a CLI argument")
 		// override session ID from CLI parameter
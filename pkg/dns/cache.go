@@ -0,0 +1,157 @@
+package dns
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// cache is an LRU cache of the DNS proxy responses, which honors the record
+// TTLs, a nil cache is a no-op
+type cache struct {
+	mu          sync.Mutex
+	size        int
+	negativeTTL time.Duration
+	lru         *list.List
+	items       map[cacheKey]*list.Element
+}
+
+type cacheKey struct {
+	name   string
+	qtype  uint16
+	qclass uint16
+}
+
+type cacheEntry struct {
+	key     cacheKey
+	msg     *dns.Msg
+	stored  time.Time
+	expires time.Time
+}
+
+func newCache(size int, negativeTTL time.Duration) *cache {
+	if size <= 0 {
+		return nil
+	}
+	return &cache{
+		size:        size,
+		negativeTTL: negativeTTL,
+		lru:         list.New(),
+		items:       make(map[cacheKey]*list.Element),
+	}
+}
+
+func keyOf(m *dns.Msg) cacheKey {
+	q := m.Question[0]
+	return cacheKey{strings.ToLower(q.Name), q.Qtype, q.Qclass}
+}
+
+// get returns the cached response for the query with the TTLs, decreased by
+// the time spent in the cache
+func (c *cache) get(m *dns.Msg) *dns.Msg {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.items[keyOf(m)]
+	if !ok {
+		return nil
+	}
+	entry := e.Value.(*cacheEntry)
+	now := time.Now()
+	if !now.Before(entry.expires) {
+		c.lru.Remove(e)
+		delete(c.items, entry.key)
+		return nil
+	}
+	c.lru.MoveToFront(e)
+
+	r := entry.msg.Copy()
+	r.Id = m.Id
+	elapsed := uint32(now.Sub(entry.stored).Seconds())
+	for _, rrs := range [][]dns.RR{r.Answer, r.Ns, r.Extra} {
+		for _, rr := range rrs {
+			if h := rr.Header(); h.Rrtype != dns.TypeOPT {
+				if h.Ttl > elapsed {
+					h.Ttl -= elapsed
+				} else {
+					h.Ttl = 0
+				}
+			}
+		}
+	}
+	return r
+}
+
+// set caches the successful and negative responses, the negative responses
+// (NXDOMAIN or no answer) are cached for the negativeTTL
+func (c *cache) set(m, r *dns.Msg) {
+	if c == nil || r == nil || r.Truncated {
+		return
+	}
+
+	var ttl time.Duration
+	switch {
+	case r.Rcode == dns.RcodeNameError, r.Rcode == dns.RcodeSuccess && len(r.Answer) == 0:
+		ttl = c.negativeTTL
+	case r.Rcode == dns.RcodeSuccess:
+		ttl = minTTL(r)
+	}
+	if ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := keyOf(m)
+	now := time.Now()
+	entry := &cacheEntry{
+		key:     key,
+		msg:     r.Copy(),
+		stored:  now,
+		expires: now.Add(ttl),
+	}
+	if e, ok := c.items[key]; ok {
+		e.Value = entry
+		c.lru.MoveToFront(e)
+		return
+	}
+	c.items[key] = c.lru.PushFront(entry)
+	if c.lru.Len() > c.size {
+		e := c.lru.Back()
+		c.lru.Remove(e)
+		delete(c.items, e.Value.(*cacheEntry).key)
+	}
+}
+
+// flush drops all the cached responses
+func (c *cache) flush() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lru.Init()
+	c.items = make(map[cacheKey]*list.Element)
+}
+
+// minTTL returns the lowest TTL of the response records
+func minTTL(r *dns.Msg) time.Duration {
+	var ttl uint32
+	found := false
+	for _, rrs := range [][]dns.RR{r.Answer, r.Ns, r.Extra} {
+		for _, rr := range rrs {
+			if h := rr.Header(); h.Rrtype != dns.TypeOPT && (!found || h.Ttl < ttl) {
+				ttl, found = h.Ttl, true
+			}
+		}
+	}
+	return time.Duration(ttl) * time.Second
+}
@@ -1,46 +1,81 @@
 package dns
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 
 	"github.com/kayrus/gof5/pkg/config"
+	"github.com/kayrus/gof5/pkg/util"
 
 	"github.com/miekg/dns"
 )
 
-func Start(cfg *config.Config, errChan chan error, tunDown chan struct{}) {
+var (
+	// zonesMu protects the DNS zones, which can be updated on config reload,
+	// and the cache of the running DNS proxy
+	zonesMu sync.RWMutex
+	// proxyCache is flushed, when the zones are updated
+	proxyCache *cache
+	// upstreamPort is the port of the VPN and the system DNS servers
+	upstreamPort = "53"
+)
+
+// SetZones updates the DNS zones served by the running DNS proxy
+func SetZones(cfg *config.Config, zones []string) {
+	zonesMu.Lock()
+	defer zonesMu.Unlock()
+
+	cfg.DNS = zones
+	// the cached responses may come from the servers of the previous zones
+	proxyCache.flush()
+}
+
+// Start binds the DNS proxy listeners and serves them in background
+func Start(cfg *config.Config, errChan chan error, tunDown chan struct{}) error {
+	// the cache lives as long as the tunnel, the DNS servers may change on
+	// reconnect
+	c := newCache(cfg.DNSCacheSize, cfg.DNSNegativeCacheTTL)
+	zonesMu.Lock()
+	proxyCache = c
+	zonesMu.Unlock()
+
 	dnsUDPHandler := func(w dns.ResponseWriter, m *dns.Msg) {
-		dnsHandler(w, m, cfg, "udp")
+		dnsHandler(w, m, cfg, "udp", c)
 	}
 
 	dnsTCPHandler := func(w dns.ResponseWriter, m *dns.Msg) {
-		dnsHandler(w, m, cfg, "tcp")
+		dnsHandler(w, m, cfg, "tcp", c)
+	}
+
+	pc, l, err := bind(cfg)
+	if err != nil {
+		return err
 	}
 
-	listen := net.JoinHostPort(cfg.ListenDNS.String(), "53")
 	srvUDP := &dns.Server{
-		Addr:    listen,
-		Net:     "udp",
-		Handler: dns.HandlerFunc(dnsUDPHandler),
+		PacketConn: pc,
+		Handler:    dns.HandlerFunc(dnsUDPHandler),
 	}
 	srvTCP := &dns.Server{
-		Addr:    listen,
-		Net:     "tcp",
-		Handler: dns.HandlerFunc(dnsTCPHandler),
+		Listener: l,
+		Handler:  dns.HandlerFunc(dnsTCPHandler),
 	}
 
 	go func() {
-		if err := srvUDP.ListenAndServe(); err != nil {
-			errChan <- fmt.Errorf("failed to set udp listener: %v", err)
+		if err := srvUDP.ActivateAndServe(); err != nil {
+			errChan <- fmt.Errorf("failed to serve udp listener: %v", err)
 			return
 		}
 	}()
 	go func() {
-		if err := srvTCP.ListenAndServe(); err != nil {
-			errChan <- fmt.Errorf("failed to set tcp listener: %v", err)
+		if err := srvTCP.ActivateAndServe(); err != nil {
+			errChan <- fmt.Errorf("failed to serve tcp listener: %v", err)
 			return
 		}
 	}()
@@ -50,37 +85,192 @@ func Start(cfg *config.Config, errChan chan error, tunDown chan struct{}) {
 		log.Printf("Shutting down DNS proxy")
 		srvUDP.Shutdown()
 		srvTCP.Shutdown()
+		c.flush()
 	}()
+
+	return nil
 }
 
-func dnsHandler(w dns.ResponseWriter, m *dns.Msg, cfg *config.Config, proto string) {
-	c := new(dns.Client)
-	for _, suffix := range cfg.DNS {
-		if strings.HasSuffix(m.Question[0].Name, suffix) {
-			if cfg.Debug {
-				log.Printf("Resolving %q using VPN DNS", m.Question[0].Name)
-			}
-			for _, s := range cfg.F5Config.Object.DNS {
-				if err := handleCustom(w, m, c, s); err == nil {
-					return
-				}
-			}
+// bind binds the listeners in advance to fail fast, when the port is already
+// in use; the instance specific address is shifted to the next loopback
+// address, when another instance with a colliding name listens on it
+func bind(cfg *config.Config) (net.PacketConn, net.Listener, error) {
+	for i := 0; ; i++ {
+		pc, l, err := bindAddr(net.JoinHostPort(cfg.ListenDNS.String(), strconv.Itoa(cfg.ListenDNSPort)))
+		if err == nil || !cfg.InstanceListenDNS || !errors.Is(err, syscall.EADDRINUSE) || i >= 253 {
+			return pc, l, err
 		}
+		ip := cfg.ListenDNS.To4()
+		next := net.IPv4(ip[0], ip[1], ip[2]%254+1, ip[3]).To4()
+		log.Printf("DNS proxy address %s is in use by another instance, trying %s", cfg.ListenDNS, next)
+		cfg.ListenDNS = next
 	}
-	for _, s := range cfg.DNSServers {
-		if err := handleCustom(w, m, c, s); err == nil {
-			return
+}
+
+func bindAddr(listen string) (net.PacketConn, net.Listener, error) {
+	pc, err := net.ListenPacket("udp", listen)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to set udp listener: %w", err)
+	}
+	l, err := net.Listen("tcp", listen)
+	if err != nil {
+		pc.Close()
+		return nil, nil, fmt.Errorf("failed to set tcp listener: %w", err)
+	}
+	return pc, l, nil
+}
+
+// isVPNDomain reports whether the name belongs to one of the DNS zones, which
+// must be resolved by VPN DNS servers, e.g. "corp.int", ".corp.int" and
+// "corp.int." zones match both "corp.int." and "host.corp.int." names
+// internalZones returns the zones except the root zone, which matches the
+// public names as well
+func internalZones(zones []string) []string {
+	var res []string
+	for _, zone := range zones {
+		if dns.Fqdn(strings.TrimPrefix(zone, ".")) != "." {
+			res = append(res, zone)
+		}
+	}
+	return res
+}
+
+func isVPNDomain(name string, zones []string) bool {
+	for _, zone := range zones {
+		if dns.IsSubDomain(dns.Fqdn(strings.TrimPrefix(zone, ".")), dns.Fqdn(name)) {
+			return true
 		}
 	}
+	return false
 }
 
-func handleCustom(w dns.ResponseWriter, o *dns.Msg, c *dns.Client, ip net.IP) error {
-	m := new(dns.Msg)
-	o.CopyTo(m)
-	r, _, err := c.Exchange(m, net.JoinHostPort(ip.String(), "53"))
-	if r == nil || err != nil {
-		return fmt.Errorf("failed to resolve %q", m.Question[0].Name)
+// dnsHandler forwards the query to the VPN DNS servers, when the name belongs
+// to the VPN DNS zones, and to the original system DNS servers otherwise or
+// when the VPN DNS servers fail. The F5Config DNS servers already contain the
+// overrideDNS servers first, followed by the F5 pushed ones with dnsFallback.
+func dnsHandler(w dns.ResponseWriter, m *dns.Msg, cfg *config.Config, proto string, c *cache) {
+	if len(m.Question) == 0 {
+		return
 	}
+
+	if r := c.get(m); r != nil {
+		if cfg.Debug {
+			util.DebugLog.Printf("Resolved %q from the DNS cache", m.Question[0].Name)
+		}
+		w.WriteMsg(r)
+		return
+	}
+
+	r := resolve(m, cfg, proto)
+	c.set(m, r)
 	w.WriteMsg(r)
-	return nil
+}
+
+func resolve(m *dns.Msg, cfg *config.Config, proto string) *dns.Msg {
+	name := m.Question[0].Name
+
+	zonesMu.RLock()
+	vpnDomain := isVPNDomain(name, cfg.DNS)
+	internal := isVPNDomain(name, internalZones(cfg.DNS))
+	zonesMu.RUnlock()
+
+	c := &dns.Client{
+		Net:     proto,
+		Timeout: cfg.DNSTimeout,
+	}
+
+	var vpnResp *dns.Msg
+	if vpnDomain {
+		if cfg.Debug {
+			util.DebugLog.Printf("Resolving %q using VPN DNS", name)
+		}
+		servers := cfg.F5Config.Object.DNS
+		if cfg.TunnelIPv6() {
+			servers = append(append([]net.IP{}, servers...), cfg.F5Config.Object.DNS6...)
+		}
+		servers = cfg.TunneledIPs(servers)
+		// the names, which match only the catch-all zones, e.g. the root
+		// zone, are likely public, don't wait for the slow VPN DNS servers
+		fast := !internal && !isVPNDomain(name, cfg.F5Config.Object.DNSSuffix)
+		if vpnResp = exchange(c, m, servers, cfg.DNSAttempts, fast); succeeded(vpnResp) {
+			return filterFamilies(vpnResp, cfg)
+		}
+		if cfg.Debug {
+			util.DebugLog.Printf("VPN DNS servers failed to resolve %q, falling back to the system DNS servers", name)
+		}
+	}
+
+	r := exchange(c, m, cfg.DNSServers, cfg.DNSAttempts, false)
+	switch {
+	case succeeded(r):
+	case vpnResp != nil:
+		r = vpnResp
+	case r == nil:
+		// reply immediately instead of letting the client time out
+		r = new(dns.Msg)
+		r.SetRcode(m, dns.RcodeServerFailure)
+		log.Printf("Failed to resolve %q: all DNS servers failed", name)
+	}
+	return r
+}
+
+// filterFamilies drops the A or AAAA records of the skipped address family
+// from the VPN DNS response, thus the clients don't try the addresses, which
+// are not routed through the tunnel
+func filterFamilies(r *dns.Msg, cfg *config.Config) *dns.Msg {
+	skip4, skip6 := cfg.SkipFamily(false), cfg.SkipFamily(true)
+	if !skip4 && !skip6 {
+		return r
+	}
+	answer := r.Answer[:0]
+	for _, rr := range r.Answer {
+		switch rr.(type) {
+		case *dns.A:
+			if skip4 {
+				continue
+			}
+		case *dns.AAAA:
+			if skip6 {
+				continue
+			}
+		}
+		answer = append(answer, rr)
+	}
+	r.Answer = answer
+	return r
+}
+
+// succeeded reports whether the response is final, SERVFAIL and REFUSED
+// responses are retried with the next server
+func succeeded(r *dns.Msg) bool {
+	return r != nil && r.Rcode != dns.RcodeServerFailure && r.Rcode != dns.RcodeRefused
+}
+
+// exchange queries the servers in order, every server up to attempts times,
+// on timeout. When fast is set, the first timeout skips the rest servers. The
+// last failed response is returned, when no server succeeded.
+func exchange(c *dns.Client, m *dns.Msg, servers []net.IP, attempts int, fast bool) *dns.Msg {
+	var last *dns.Msg
+	for _, ip := range servers {
+		addr := net.JoinHostPort(ip.String(), upstreamPort)
+		for i := 0; i < attempts; i++ {
+			r, _, err := c.Exchange(m.Copy(), addr)
+			if err != nil {
+				if e, ok := err.(net.Error); ok && e.Timeout() {
+					if fast {
+						return last
+					}
+					continue
+				}
+				// e.g. connection refused, the next attempt fails too
+				break
+			}
+			if succeeded(r) {
+				return r
+			}
+			last = r
+			break
+		}
+	}
+	return last
 }
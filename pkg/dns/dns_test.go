@@ -0,0 +1,177 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/kayrus/gof5/pkg/config"
+
+	"github.com/miekg/dns"
+)
+
+func TestIsVPNDomain(t *testing.T) {
+	zones := []string{".corp.int.", "example.com", "in-addr.arpa."}
+	for name, expected := range map[string]bool{
+		"host.corp.int.":         true,
+		"corp.int.":              true,
+		"HOST.Corp.Int.":         true,
+		"host.example.com.":      true,
+		"host.example.com":       true,
+		"example.com.":           true,
+		"notexample.com.":        false,
+		"example.com.evil.":      false,
+		"1.0.0.10.in-addr.arpa.": true,
+		"www.google.com.":        false,
+	} {
+		if v := isVPNDomain(name, zones); v != expected {
+			t.Errorf("%q VPN domain match is %t, expected %t", name, v, expected)
+		}
+	}
+
+	if !isVPNDomain("www.google.com.", []string{"."}) {
+		t.Errorf("root zone must match every name")
+	}
+}
+
+func TestCache(t *testing.T) {
+	query := func(name string) *dns.Msg {
+		m := new(dns.Msg)
+		m.SetQuestion(name, dns.TypeA)
+		return m
+	}
+	answer := func(m *dns.Msg, ttl uint32) *dns.Msg {
+		r := new(dns.Msg)
+		r.SetReply(m)
+		rr, err := dns.NewRR(fmt.Sprintf("%s %d IN A 10.0.0.1", m.Question[0].Name, ttl))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Answer = append(r.Answer, rr)
+		return r
+	}
+
+	c := newCache(2, time.Minute)
+	a, b, d := query("a.corp.int."), query("b.corp.int."), query("d.corp.int.")
+	c.set(a, answer(a, 60))
+	c.set(b, answer(b, 0))
+	if c.get(b) != nil {
+		t.Errorf("zero TTL response must not be cached")
+	}
+
+	nx := new(dns.Msg)
+	nx.SetRcode(d, dns.RcodeNameError)
+	c.set(d, nx)
+	if r := c.get(d); r == nil || r.Rcode != dns.RcodeNameError {
+		t.Errorf("expected cached NXDOMAIN response, got: %v", r)
+	}
+
+	q := query("A.Corp.Int.")
+	q.Id = 42
+	r := c.get(q)
+	if r == nil || r.Id != 42 || r.Answer[0].Header().Ttl > 60 {
+		t.Fatalf("expected cached response with the query ID, got: %v", r)
+	}
+
+	// a is the most recently used, d is evicted
+	c.set(b, answer(b, 60))
+	if c.get(d) != nil || c.get(a) == nil || c.get(b) == nil {
+		t.Errorf("expected the least recently used response to be evicted")
+	}
+
+	c.flush()
+	if c.get(a) != nil {
+		t.Errorf("expected empty cache after flush")
+	}
+}
+
+func TestBindInstanceCollision(t *testing.T) {
+	taken, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+	port := taken.LocalAddr().(*net.UDPAddr).Port
+
+	cfg := &config.Config{
+		ListenDNS:         net.IPv4(127, 0, 0, 1).To4(),
+		ListenDNSPort:     port,
+		InstanceListenDNS: true,
+	}
+	pc, l, err := bind(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pc.Close()
+	l.Close()
+	if !cfg.ListenDNS.Equal(net.IPv4(127, 0, 1, 1)) {
+		t.Errorf("unexpected %s shifted address", cfg.ListenDNS)
+	}
+
+	cfg.ListenDNS = net.IPv4(127, 0, 0, 1).To4()
+	cfg.InstanceListenDNS = false
+	if _, _, err = bind(cfg); err == nil {
+		t.Errorf("the custom address in use was shifted")
+	}
+}
+
+func TestResolveSlowVPNServer(t *testing.T) {
+	// the VPN DNS server never replies
+	vpn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer vpn.Close()
+	_, port, _ := net.SplitHostPort(vpn.LocalAddr().String())
+
+	queries := make(chan struct{}, 16)
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			if _, _, err := vpn.ReadFrom(buf); err != nil {
+				return
+			}
+			queries <- struct{}{}
+		}
+	}()
+
+	defer func(v string) { upstreamPort = v }(upstreamPort)
+	upstreamPort = port
+
+	for _, tc := range []struct {
+		name     string
+		zones    []string
+		expected int
+	}{
+		// no pushed suffixes, the zone is still internal
+		{"host.corp.int.", []string{"corp.int."}, 3},
+		{"host.corp.int.", []string{"corp.int.", "."}, 3},
+		// the catch-all zone gives up on the first timeout
+		{"www.example.com.", []string{"corp.int.", "."}, 1},
+	} {
+		cfg := &config.Config{
+			DNS:         tc.zones,
+			DNSTimeout:  20 * time.Millisecond,
+			DNSAttempts: 3,
+			F5Config:    new(config.Favorite),
+		}
+		cfg.F5Config.Object.DNS = []net.IP{net.IPv4(127, 0, 0, 1)}
+
+		name := tc.name
+		m := new(dns.Msg)
+		m.SetQuestion(name, dns.TypeA)
+		if r := resolve(m, cfg, "udp"); r.Rcode != dns.RcodeServerFailure {
+			t.Errorf("%q: unexpected %s response", name, dns.RcodeToString[r.Rcode])
+		}
+
+		// let the last query reach the server
+		time.Sleep(20 * time.Millisecond)
+		if n := len(queries); n != tc.expected {
+			t.Errorf("%q: VPN DNS server got %d queries, expected %d", name, n, tc.expected)
+		}
+		for len(queries) > 0 {
+			<-queries
+		}
+	}
+}
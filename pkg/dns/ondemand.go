@@ -0,0 +1,98 @@
+package dns
+
+import (
+	"fmt"
+	"log"
+	"net"
+
+	"github.com/kayrus/gof5/pkg/config"
+	"github.com/kayrus/gof5/pkg/util"
+
+	"github.com/miekg/dns"
+)
+
+// StartOnDemand binds the on-demand DNS stub listeners and serves them in
+// background until the stop channel is closed. A query for the on-demand
+// domains calls the connect func, which establishes the tunnel and returns
+// the VPN DNS servers, the query is forwarded to. Other queries are refused.
+func StartOnDemand(cfg *config.Config, connect func() ([]net.IP, error), errChan chan error, stop chan struct{}) error {
+	handler := func(proto string) dns.HandlerFunc {
+		return func(w dns.ResponseWriter, m *dns.Msg) {
+			onDemandHandler(w, m, cfg, proto, connect)
+		}
+	}
+
+	pc, err := net.ListenPacket("udp", cfg.OnDemandListen)
+	if err != nil {
+		return fmt.Errorf("failed to set on-demand udp listener: %v", err)
+	}
+	l, err := net.Listen("tcp", cfg.OnDemandListen)
+	if err != nil {
+		pc.Close()
+		return fmt.Errorf("failed to set on-demand tcp listener: %v", err)
+	}
+
+	srvUDP := &dns.Server{
+		PacketConn: pc,
+		Handler:    handler("udp"),
+	}
+	srvTCP := &dns.Server{
+		Listener: l,
+		Handler:  handler("tcp"),
+	}
+
+	go func() {
+		if err := srvUDP.ActivateAndServe(); err != nil {
+			errChan <- fmt.Errorf("failed to serve on-demand udp listener: %v", err)
+		}
+	}()
+	go func() {
+		if err := srvTCP.ActivateAndServe(); err != nil {
+			errChan <- fmt.Errorf("failed to serve on-demand tcp listener: %v", err)
+		}
+	}()
+
+	go func() {
+		<-stop
+		srvUDP.Shutdown()
+		srvTCP.Shutdown()
+	}()
+
+	return nil
+}
+
+func onDemandHandler(w dns.ResponseWriter, m *dns.Msg, cfg *config.Config, proto string, connect func() ([]net.IP, error)) {
+	if len(m.Question) == 0 {
+		return
+	}
+	name := m.Question[0].Name
+
+	r := new(dns.Msg)
+	if !isVPNDomain(name, cfg.OnDemandDomains) {
+		r.SetRcode(m, dns.RcodeRefused)
+		w.WriteMsg(r)
+		return
+	}
+
+	servers, err := connect()
+	if err != nil {
+		log.Printf("Failed to connect on %q DNS query: %s", name, err)
+		r.SetRcode(m, dns.RcodeServerFailure)
+		w.WriteMsg(r)
+		return
+	}
+
+	if cfg.Debug {
+		util.DebugLog.Printf("Resolving on-demand %q using VPN DNS", name)
+	}
+	c := &dns.Client{
+		Net:     proto,
+		Timeout: cfg.DNSTimeout,
+	}
+	if v := exchange(c, m, servers, cfg.DNSAttempts, false); v != nil {
+		r = v
+	} else {
+		r.SetRcode(m, dns.RcodeServerFailure)
+	}
+	w.WriteMsg(r)
+}
@@ -0,0 +1,33 @@
+package link
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// bindToInterface returns a socket control func, which binds the socket to the
+// interface regardless of the routing table
+func bindToInterface(name string) (func(string, string, syscall.RawConn) error, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to detect %s interface: %s", name, err)
+	}
+
+	return func(network, _ string, c syscall.RawConn) error {
+		var err error
+		if e := c.Control(func(fd uintptr) {
+			if strings.HasSuffix(network, "6") {
+				err = unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_BOUND_IF, iface.Index)
+				return
+			}
+			err = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_BOUND_IF, iface.Index)
+		}); e != nil {
+			return e
+		}
+		return err
+	}, nil
+}
@@ -0,0 +1,21 @@
+package link
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// bindToInterface returns a socket control func, which binds the socket to the
+// interface regardless of the routing table
+func bindToInterface(name string) (func(string, string, syscall.RawConn) error, error) {
+	return func(_, _ string, c syscall.RawConn) error {
+		var err error
+		if e := c.Control(func(fd uintptr) {
+			err = unix.BindToDevice(int(fd), name)
+		}); e != nil {
+			return e
+		}
+		return err
+	}, nil
+}
@@ -0,0 +1,15 @@
+//go:build !linux && !darwin && !windows
+// +build !linux,!darwin,!windows
+
+package link
+
+import (
+	"log"
+	"syscall"
+)
+
+// bindToInterface is not supported, the connections follow the routing table
+func bindToInterface(name string) (func(string, string, syscall.RawConn) error, error) {
+	log.Printf("Warning: binding to the %s interface is not supported, the connections follow the routes", name)
+	return nil, nil
+}
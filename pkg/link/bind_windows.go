@@ -0,0 +1,40 @@
+package link
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/windows"
+)
+
+// https://learn.microsoft.com/en-us/windows/win32/winsock/ipproto-ip-socket-options
+const unicastIf = 31
+
+// bindToInterface returns a socket control func, which binds the socket to the
+// interface regardless of the routing table
+func bindToInterface(name string) (func(string, string, syscall.RawConn) error, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to detect %s interface: %s", name, err)
+	}
+
+	return func(network, _ string, c syscall.RawConn) error {
+		var err error
+		if e := c.Control(func(fd uintptr) {
+			if strings.HasSuffix(network, "6") {
+				err = windows.SetsockoptInt(windows.Handle(fd), windows.IPPROTO_IPV6, unicastIf, iface.Index)
+				return
+			}
+			// IPv4 index must be in network byte order
+			var idx [4]byte
+			binary.BigEndian.PutUint32(idx[:], uint32(iface.Index))
+			err = windows.SetsockoptInt(windows.Handle(fd), windows.IPPROTO_IP, unicastIf, int(binary.LittleEndian.Uint32(idx[:])))
+		}); e != nil {
+			return e
+		}
+		return err
+	}, nil
+}
@@ -4,43 +4,73 @@
 package link
 
 import (
-	"log"
+	"fmt"
 	"os/exec"
 	"runtime"
+	"strconv"
+	"strings"
 	"syscall"
 
 	"github.com/kayrus/gof5/pkg/config"
+	"github.com/kayrus/gof5/pkg/util"
 )
 
 func Cmd(cfg *config.Config) *exec.Cmd {
 	var cmd *exec.Cmd
 	if cfg.Driver == "pppd" {
+		// copy the args, the command can be built several times on reconnect
+		args := append([]string{}, cfg.PPPdArgs...)
 		// VPN
-		if cfg.IPv6 && bool(cfg.F5Config.Object.IPv6) {
-			cfg.PPPdArgs = append(cfg.PPPdArgs,
+		if cfg.TunnelIPv6() {
+			args = append(args,
 				"ipv6cp-accept-local",
 				"ipv6cp-accept-remote",
 				"+ipv6",
 			)
 		} else {
-			cfg.PPPdArgs = append(cfg.PPPdArgs,
+			args = append(args,
 				// TODO: clarify why it doesn't work
 				"noipv6", // Unsupported protocol 'IPv6 Control Protocol' (0x8057) received
 			)
 		}
+		if cfg.MTU > 0 {
+			mtu := strconv.Itoa(cfg.MTU)
+			args = append(args,
+				"mtu", mtu,
+				"mru", mtu,
+			)
+		}
+		if cfg.RequestedAddress != "" {
+			// the local address is requested, ipcp-accept-local falls back
+			// to the assigned one
+			args = append(args, cfg.RequestedAddress+":")
+		}
+		if cfg.InterfaceName != "" {
+			// validated to be used only in Linux
+			args = append(args,
+				"ifname", cfg.InterfaceName,
+			)
+		}
+		if cfg.KeepaliveInterval > 0 {
+			// pppd sends LCP echo requests itself
+			args = append(args,
+				"lcp-echo-interval", strconv.Itoa(int(cfg.KeepaliveInterval.Seconds())),
+			)
+		}
 		if cfg.Debug {
-			cfg.PPPdArgs = append(cfg.PPPdArgs,
+			args = append(args,
 				"debug",
 				"kdebug", "1",
 			)
-			log.Printf("pppd args: %q", cfg.PPPdArgs)
+			util.DebugLog.Printf("pppd args: %q", args)
 		}
 
+		pppd := config.PPPdBinary(cfg.PPPdPath)
 		switch runtime.GOOS {
 		default:
-			cmd = exec.Command("pppd", cfg.PPPdArgs...)
+			cmd = exec.Command(pppd, args...)
 		case "freebsd":
-			cmd = exec.Command("ppp", "-direct")
+			cmd = exec.Command(pppd, "-direct")
 		}
 
 		// don't forward parent process signals to a child process
@@ -52,3 +82,21 @@ func Cmd(cfg *config.Config) *exec.Cmd {
 	}
 	return nil
 }
+
+// runCmd runs the command and returns its output in the error
+func runCmd(name string, args ...string) error {
+	return runCmdInput("", name, args...)
+}
+
+// runCmdInput runs the command with the input on stdin
+func runCmdInput(input, name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	if input != "" {
+		cmd.Stdin = strings.NewReader(input)
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to run %s %s: %s: %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
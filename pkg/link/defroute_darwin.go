@@ -0,0 +1,171 @@
+package link
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+
+	"github.com/kayrus/gof5/pkg/util"
+
+	"golang.org/x/net/route"
+	"golang.org/x/sys/unix"
+)
+
+// defaultRoute is an IPv4 default route, macOS keeps an unscoped default route
+// for the primary interface and scoped default routes for every interface
+type defaultRoute struct {
+	gw     net.IP
+	index  int
+	scoped bool
+}
+
+func (r defaultRoute) String() string {
+	name := fmt.Sprintf("%d", r.index)
+	if iface, err := net.InterfaceByIndex(r.index); err == nil {
+		name = iface.Name
+	}
+	res := fmt.Sprintf("default via %s dev %s", r.gw, name)
+	if r.scoped {
+		res += " scoped"
+	}
+	return res
+}
+
+func (r defaultRoute) equal(v defaultRoute) bool {
+	return r.gw.Equal(v.gw) && r.index == v.index && r.scoped == v.scoped
+}
+
+// getDefaultRoutes returns the IPv4 default routes with a gateway
+func getDefaultRoutes() ([]defaultRoute, error) {
+	rib, err := route.FetchRIB(unix.AF_INET, route.RIBTypeRoute, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch routing table: %s", err)
+	}
+	msgs, err := route.ParseRIB(route.RIBTypeRoute, rib)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse routing table: %s", err)
+	}
+
+	var res []defaultRoute
+	for _, m := range msgs {
+		rm, ok := m.(*route.RouteMessage)
+		if !ok || rm.Flags&unix.RTF_GATEWAY == 0 || len(rm.Addrs) <= unix.RTAX_NETMASK {
+			continue
+		}
+		dst, ok := rm.Addrs[unix.RTAX_DST].(*route.Inet4Addr)
+		if !ok || dst.IP != [4]byte{} {
+			continue
+		}
+		if mask, ok := rm.Addrs[unix.RTAX_NETMASK].(*route.Inet4Addr); ok && mask.IP != [4]byte{} {
+			continue
+		}
+		gw, ok := rm.Addrs[unix.RTAX_GATEWAY].(*route.Inet4Addr)
+		if !ok {
+			continue
+		}
+		res = append(res, defaultRoute{
+			gw:     net.IP(gw.IP[:]),
+			index:  rm.Index,
+			scoped: rm.Flags&unix.RTF_IFSCOPE != 0,
+		})
+	}
+	return res, nil
+}
+
+func addDefaultRoute(r defaultRoute) error {
+	flags := unix.RTF_UP | unix.RTF_GATEWAY | unix.RTF_STATIC
+	if r.scoped {
+		flags |= unix.RTF_IFSCOPE
+	}
+	gw := &route.Inet4Addr{}
+	copy(gw.IP[:], r.gw.To4())
+	msg := route.RouteMessage{
+		Version: unix.RTM_VERSION,
+		Type:    unix.RTM_ADD,
+		Flags:   flags,
+		Index:   r.index,
+		Seq:     1,
+		Addrs: []route.Addr{
+			unix.RTAX_DST:     &route.Inet4Addr{},
+			unix.RTAX_GATEWAY: gw,
+			unix.RTAX_NETMASK: &route.Inet4Addr{},
+		},
+	}
+	bin, err := msg.Marshal()
+	if err != nil {
+		return err
+	}
+
+	fd, err := unix.Socket(unix.AF_ROUTE, unix.SOCK_RAW, unix.AF_UNSPEC)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+	_, err = unix.Write(fd, bin)
+	return err
+}
+
+func formatDefaultRoutes(routes []defaultRoute) string {
+	if len(routes) == 0 {
+		return "none"
+	}
+	res := make([]string, 0, len(routes))
+	for _, r := range routes {
+		res = append(res, r.String())
+	}
+	return strings.Join(res, ", ")
+}
+
+// saveDefaultRoutes captures the default routes before the tunnel is
+// established
+func (l *vpnLink) saveDefaultRoutes() {
+	routes, err := getDefaultRoutes()
+	if err != nil {
+		log.Printf("Warning: %s", err)
+		return
+	}
+	if l.debug {
+		util.DebugLog.Printf("Default routes before connect: %s", formatDefaultRoutes(routes))
+	}
+	l.defaultRoutes = routes
+}
+
+// restoreDefaultRoutes reinstalls the default routes, which disappeared while
+// the tunnel was established
+func (l *vpnLink) restoreDefaultRoutes() {
+	if len(l.defaultRoutes) == 0 {
+		return
+	}
+	current, err := getDefaultRoutes()
+	if err != nil {
+		log.Printf("Warning: %s", err)
+		return
+	}
+
+	for _, r := range l.defaultRoutes {
+		var found bool
+		for _, v := range current {
+			if r.equal(v) {
+				found = true
+				break
+			}
+		}
+		if found {
+			continue
+		}
+		// the interface could be gone in the meantime
+		if _, err := net.InterfaceByIndex(r.index); err != nil {
+			continue
+		}
+		log.Printf("Restoring %s route", r)
+		if err := addDefaultRoute(r); err != nil {
+			log.Printf("Failed to restore %s route: %s", r, err)
+		}
+	}
+
+	if l.debug {
+		current, _ = getDefaultRoutes()
+		util.DebugLog.Printf("Default routes after teardown: %s", formatDefaultRoutes(current))
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !darwin
+// +build !darwin
+
+package link
+
+type defaultRoute struct{}
+
+func (l *vpnLink) saveDefaultRoutes() {
+}
+
+func (l *vpnLink) restoreDefaultRoutes() {
+}
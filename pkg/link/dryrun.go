@@ -0,0 +1,94 @@
+package link
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/kayrus/gof5/pkg/config"
+)
+
+// PrintProfile prints the VPN profile, pushed by F5, and the routes, which
+// would be installed, the system is not modified
+func (l *vpnLink) PrintProfile(w io.Writer, cfg *config.Config) error {
+	obj := cfg.F5Config.Object
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	row := func(name string, v interface{}) {
+		fmt.Fprintf(tw, "%s:\t%v\n", name, v)
+	}
+	list := func(name string, v []string) {
+		if len(v) == 0 {
+			row(name, "-")
+			return
+		}
+		row(name, strings.Join(v, ", "))
+	}
+
+	row("Client IPv4", orNone(l.localIPv4))
+	row("Server IPv4", orNone(l.serverIPv4))
+	row("Client IPv6", orNone(l.localIPv6))
+	row("Server IPv6", orNone(l.serverIPv6))
+	if cfg.MTU > 0 {
+		row("MTU", cfg.MTU)
+	} else {
+		row("MTU", "negotiated by PPP")
+	}
+	row("IPv4", obj.IPv4)
+	row("IPv6", obj.IPv6)
+	row("DTLS", obj.TunnelDTLS)
+	row("Split tunneling", obj.SplitTunneling != 0)
+	list("DNS servers", ipsToStrings(obj.DNS))
+	list("DNS6 servers", ipsToStrings(obj.DNS6))
+	list("DNS suffixes", obj.DNSSuffix)
+	list("Split DNS", strings.FieldsFunc(obj.DNSSPlit, func(r rune) bool { return r == ' ' || r == ',' }))
+	list("Config DNS zones", cfg.DNS)
+	if obj.Routes != nil {
+		list("Pushed routes", netsToStrings(obj.Routes.GetNetworks()))
+	} else {
+		row("Pushed routes", "-")
+	}
+	list("Pushed excludes", netsToStrings(obj.ExcludeSubnets))
+	if obj.Routes6 != nil {
+		list("Pushed routes6", netsToStrings(obj.Routes6.GetNetworks()))
+	}
+	list("Pushed excludes6", netsToStrings(obj.ExcludeSubnets6))
+	list("Effective routes", netsToStrings(l.buildRoutes(cfg)))
+	servers := make([]string, 0, len(l.serverRoutes))
+	for _, r := range l.serverRoutes {
+		servers = append(servers, r.String())
+	}
+	list("F5 server routes", servers)
+	bypass := make([]string, 0, len(l.bypassRoutes))
+	for _, r := range l.bypassRoutes {
+		bypass = append(bypass, r.String())
+	}
+	list("Excluded subnet routes", bypass)
+
+	return tw.Flush()
+}
+
+func orNone(ip net.IP) string {
+	if ip == nil {
+		return "-"
+	}
+	return ip.String()
+}
+
+func ipsToStrings(ips []net.IP) []string {
+	v := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		v = append(v, ip.String())
+	}
+	return v
+}
+
+func netsToStrings(nets []*net.IPNet) []string {
+	v := make([]string, 0, len(nets))
+	for _, n := range nets {
+		v = append(v, n.String())
+	}
+	return v
+}
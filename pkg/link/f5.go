@@ -8,6 +8,9 @@ import (
 	"io"
 	"log"
 	"net"
+	"time"
+
+	"github.com/kayrus/gof5/pkg/util"
 
 	"golang.org/x/net/ipv4"
 	"golang.org/x/net/ipv6"
@@ -73,17 +76,18 @@ func processPPP(l *vpnLink, buf []byte, dstBuf *bytes.Buffer) error {
 	// process ipv4 traffic
 	if v := readBuf(buf, ipv4header); v != nil {
 		if l.debug {
-			log.Printf("Read parsed ipv4 %d bytes from http:\n%s", len(v), hex.Dump(v))
+			util.DebugLog.Printf("Read parsed ipv4 %d bytes from http:\n%s", len(v), hex.Dump(v))
 			header, _ := ipv4.ParseHeader(v)
-			log.Printf("ipv4 from http: %s", header)
+			util.DebugLog.Printf("ipv4 from http: %s", header)
 		}
 
 		wn, err := l.iface.Write(v)
 		if err != nil {
 			return fmt.Errorf("fatal write to tun: %s", err)
 		}
+		l.countIn(wn, true)
 		if l.debug {
-			log.Printf("Sent %d bytes to tun", wn)
+			util.DebugLog.Printf("Sent %d bytes to tun", wn)
 		}
 		return nil
 	}
@@ -91,17 +95,18 @@ func processPPP(l *vpnLink, buf []byte, dstBuf *bytes.Buffer) error {
 	// process ipv6 traffic
 	if v := readBuf(buf, ipv6header); v != nil {
 		if l.debug {
-			log.Printf("Read parsed ipv6 %d bytes from http:\n%s", len(v), hex.Dump(v))
+			util.DebugLog.Printf("Read parsed ipv6 %d bytes from http:\n%s", len(v), hex.Dump(v))
 			header, _ := ipv6.ParseHeader(v)
-			log.Printf("ipv6 from http: %s", header)
+			util.DebugLog.Printf("ipv6 from http: %s", header)
 		}
 
 		wn, err := l.iface.Write(v)
 		if err != nil {
 			return fmt.Errorf("fatal write to tun: %s", err)
 		}
+		l.countIn(wn, true)
 		if l.debug {
-			log.Printf("Sent %d bytes to tun", wn)
+			util.DebugLog.Printf("Sent %d bytes to tun", wn)
 		}
 		return nil
 	}
@@ -141,8 +146,13 @@ func processPPP(l *vpnLink, buf []byte, dstBuf *bytes.Buffer) error {
 					doResp.Write(ipv4type)
 					doResp.WriteByte(id2)
 					doResp.Write(v4)
-					for i := 0; i < 4; i++ {
-						doResp.WriteByte(0)
+					if l.requestedIPv4 != nil {
+						log.Printf("Requesting %s local IPv4", l.requestedIPv4)
+						doResp.Write(l.requestedIPv4)
+					} else {
+						for i := 0; i < 4; i++ {
+							doResp.WriteByte(0)
+						}
 					}
 
 					return toF5(l, doResp.Bytes(), dstBuf)
@@ -156,6 +166,9 @@ func processPPP(l *vpnLink, buf []byte, dstBuf *bytes.Buffer) error {
 				if v := readBuf(v[1:], v4); v != nil {
 					l.localIPv4 = bytesToIPv4(v)
 					log.Printf("id: %d, id2: %d, Local IPv4 acknowledged: %s", id, id2, l.localIPv4)
+					if l.requestedIPv4 != nil && !l.requestedIPv4.Equal(l.localIPv4) {
+						log.Printf("Warning: requested %s local IPv4 is not accepted by F5, using the assigned %s", l.requestedIPv4, l.localIPv4)
+					}
 
 					// connection established
 					close(l.pppUp)
@@ -286,7 +299,7 @@ func processPPP(l *vpnLink, buf []byte, dstBuf *bytes.Buffer) error {
 			if v := readBuf(v, echoReq); v != nil {
 				id := v[0]
 				if l.debug {
-					log.Printf("id: %d, echo", id)
+					util.DebugLog.Printf("id: %d, echo", id)
 				}
 				// live pings
 				doResp := &bytes.Buffer{}
@@ -299,6 +312,14 @@ func processPPP(l *vpnLink, buf []byte, dstBuf *bytes.Buffer) error {
 
 				return toF5(l, doResp.Bytes(), dstBuf)
 			}
+			if v := readBuf(v, echoRep); v != nil {
+				// keepalive response
+				l.touch()
+				if l.debug {
+					util.DebugLog.Printf("id: %d, echo reply", v[0])
+				}
+				return nil
+			}
 			if v := readBuf(v, protoReject); v != nil {
 				id := v[0]
 				if v := readBuf(v[1:], protoRej); v != nil {
@@ -431,7 +452,7 @@ func processPPP(l *vpnLink, buf []byte, dstBuf *bytes.Buffer) error {
 func fromF5(l *vpnLink, dstBuf *bytes.Buffer) error {
 	// read the F5 packet header
 	buf := make([]byte, 2)
-	_, err := io.ReadFull(l.HTTPConn, buf)
+	_, err := io.ReadFull(l.httpReader, buf)
 	if err != nil {
 		return fmt.Errorf("failed to read F5 packet header: %s", err)
 	}
@@ -441,14 +462,14 @@ func fromF5(l *vpnLink, dstBuf *bytes.Buffer) error {
 
 	// read the F5 packet size
 	var pkglen uint16
-	err = binary.Read(l.HTTPConn, binary.BigEndian, &pkglen)
+	err = binary.Read(l.httpReader, binary.BigEndian, &pkglen)
 	if err != nil {
 		return fmt.Errorf("failed to read F5 packet size: %s", err)
 	}
 
 	// read the packet
 	buf = make([]byte, pkglen)
-	n, err := io.ReadFull(l.HTTPConn, buf)
+	n, err := io.ReadFull(l.httpReader, buf)
 	if err != nil {
 		return fmt.Errorf("failed to read F5 packet of the %d size: %s", pkglen, err)
 	}
@@ -478,16 +499,67 @@ func (l *vpnLink) HttpToTun() {
 	}
 }
 
+// Keepalive sends periodic LCP echo requests to keep the F5 session and NAT
+// mappings alive
+func (l *vpnLink) Keepalive(interval time.Duration) {
+	select {
+	case <-l.Established:
+	case <-l.TunDown:
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	dstBuf := &bytes.Buffer{}
+	var id byte
+	for {
+		select {
+		case <-l.TunDown:
+			return
+		case <-ticker.C:
+			id++
+			req := &bytes.Buffer{}
+			req.Write(ppp)
+			req.Write(pppLCP)
+			//
+			req.Write(echoReq)
+			req.WriteByte(id)
+			// length of the LCP packet with a zero magic number, since
+			// the magic number is not negotiated
+			binary.Write(req, binary.BigEndian, uint16(8))
+			req.Write(make([]byte, magicSize))
+
+			if l.debug {
+				util.DebugLog.Printf("id: %d, sending keepalive echo", id)
+			}
+			if err := toF5(l, req.Bytes(), dstBuf); err != nil {
+				l.ErrChan <- err
+				return
+			}
+			l.keepaliveSent(interval)
+		}
+	}
+}
+
 func toF5(l *vpnLink, buf []byte, dst *bytes.Buffer) error {
 	// TODO: move buffer initialization into tunToHTTP
 	// probably a buffered pipe would be nicer
+	defer dst.Reset()
+
+	if err := encodeF5(l, buf, dst); err != nil {
+		return err
+	}
+	return flushF5(l, dst)
+}
+
+// encodeF5 appends the F5 packet to the buffer
+func encodeF5(l *vpnLink, buf []byte, dst *bytes.Buffer) error {
 	length := len(buf)
 	if length == 0 {
 		return fmt.Errorf("cannot encapsulate zero packet")
 	}
 
-	defer dst.Reset()
-
 	// TODO: check packet header length (ipv4.HeaderLen, ipv6.HeaderLen)
 	switch buf[0] >> 4 {
 	case ipv4.Version:
@@ -516,28 +588,49 @@ func toF5(l *vpnLink, buf []byte, dst *bytes.Buffer) error {
 	}
 
 	if l.debug {
-		log.Printf("Sending from pppd:\n%s", hex.Dump(buf))
+		util.DebugLog.Printf("Sending from pppd:\n%s", hex.Dump(buf))
 	}
 
 	_, err = dst.Write(buf)
 	if err != nil {
 		return fmt.Errorf("fatal write to http: %s", err)
 	}
+
+	return nil
+}
+
+// flushF5 writes the encoded F5 packets to the tunnel
+func flushF5(l *vpnLink, dst *bytes.Buffer) error {
 	wn, err := io.Copy(l.HTTPConn, dst)
 	if err != nil {
 		return fmt.Errorf("fatal write to http: %s", err)
 	}
 	if l.debug {
-		log.Printf("Sent %d bytes to http", wn)
+		util.DebugLog.Printf("Sent %d bytes to http", wn)
 	}
 
 	return nil
 }
 
+// Forward starts both directions of the packet loop in background, the
+// failures are sent to the ErrChan
+func (l *vpnLink) Forward() {
+	// http->tun go routine
+	go l.HttpToTun()
+
+	// tun->http go routine
+	go l.TunToHTTP()
+}
+
 // Encode into F5 packet
 // tun->http
 func (l *vpnLink) TunToHTTP() {
-	buf := make([]byte, bufferSize)
+	if l.queueDepth > 0 {
+		l.tunToHTTPQueued()
+		return
+	}
+
+	buf := make([]byte, l.bufSize)
 	dstBuf := &bytes.Buffer{}
 	for {
 		select {
@@ -552,9 +645,9 @@ func (l *vpnLink) TunToHTTP() {
 				return
 			}
 			if l.debug {
-				log.Printf("Read %d bytes from tun:\n%s", rn, hex.Dump(buf[:rn]))
+				util.DebugLog.Printf("Read %d bytes from tun:\n%s", rn, hex.Dump(buf[:rn]))
 				header, _ := ipv4.ParseHeader(buf[:rn])
-				log.Printf("ipv4 from tun: %s", header)
+				util.DebugLog.Printf("ipv4 from tun: %s", header)
 			}
 
 			err = toF5(l, buf[:rn], dstBuf)
@@ -562,6 +655,88 @@ func (l *vpnLink) TunToHTTP() {
 				l.ErrChan <- err
 				return
 			}
+			l.countOut(rn, true)
+		}
+	}
+}
+
+// tunToHTTPQueued reads the tun packets into a queue, thus the tun reads don't
+// wait for the HTTPS writes
+func (l *vpnLink) tunToHTTPQueued() {
+	queue := make(chan []byte, l.queueDepth)
+	// the reader may hold one more buffer, while the queue is full
+	free := make(chan []byte, l.queueDepth+1)
+	for i := 0; i < cap(free); i++ {
+		free <- make([]byte, l.bufSize)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		dstBuf := &bytes.Buffer{}
+		for buf := range queue {
+			// encode up to batchSize already queued packets and write them at
+			// once
+			for n := 1; ; n++ {
+				if err := encodeF5(l, buf, dstBuf); err != nil {
+					l.ErrChan <- err
+					return
+				}
+				l.countOut(len(buf), true)
+				free <- buf[:cap(buf)]
+
+				if n >= l.batchSize {
+					break
+				}
+				var ok bool
+				select {
+				case buf, ok = <-queue:
+				default:
+				}
+				if !ok {
+					break
+				}
+			}
+			err := flushF5(l, dstBuf)
+			dstBuf.Reset()
+			if err != nil {
+				l.ErrChan <- err
+				return
+			}
+		}
+	}()
+	defer close(queue)
+
+	for {
+		var buf []byte
+		select {
+		case <-l.TunDown:
+			return
+		case <-done:
+			return
+		case buf = <-free:
+		}
+
+		select {
+		case <-l.TunDown:
+			return
+		case <-l.tunUp:
+			rn, err := l.iface.Read(buf)
+			if err != nil {
+				if err != io.EOF {
+					l.ErrChan <- fmt.Errorf("fatal read tun: %s", err)
+				}
+				return
+			}
+			if l.debug {
+				util.DebugLog.Printf("Read %d bytes from tun:\n%s", rn, hex.Dump(buf[:rn]))
+			}
+			// the writer exits on error, don't leak the reader
+			select {
+			case queue <- buf[:rn]:
+			case <-done:
+				return
+			}
 		}
 	}
 }
@@ -0,0 +1,105 @@
+package link
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+)
+
+// memTun returns the same IPv4 packet n times, the written packets are
+// counted and compared with the packet
+type memTun struct {
+	pkt []byte
+	n   int
+
+	received atomic.Int64
+	bad      atomic.Int64
+	// done is closed, when want packets are written
+	want int64
+	done chan struct{}
+}
+
+func (t *memTun) Read(buf []byte) (int, error) {
+	if t.n == 0 {
+		return 0, io.EOF
+	}
+	t.n--
+	return copy(buf, t.pkt), nil
+}
+
+func (t *memTun) Write(buf []byte) (int, error) {
+	if !bytes.Equal(buf, t.pkt) {
+		t.bad.Add(1)
+	}
+	if t.received.Add(1) == t.want && t.done != nil {
+		close(t.done)
+	}
+	return len(buf), nil
+}
+
+func (t *memTun) Close() error {
+	return nil
+}
+
+func benchmarkTunToHTTP(b *testing.B, queueDepth, batchSize int) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer ln.Close()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer conn.Close()
+
+	remote, err := ln.Accept()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer remote.Close()
+
+	pkt := make([]byte, 1400)
+	pkt[0] = 0x45
+
+	tunUp := make(chan struct{})
+	close(tunUp)
+	l := &vpnLink{
+		HTTPConn:   conn,
+		ErrChan:    make(chan error, 1),
+		TunDown:    make(chan struct{}),
+		tunUp:      tunUp,
+		iface:      &memTun{pkt: pkt, n: b.N},
+		bufSize:    bufferSize,
+		queueDepth: queueDepth,
+		batchSize:  batchSize,
+	}
+
+	// F5 header, packet size and IPv4 header
+	total := int64(b.N) * int64(4+len(ipv4header)+len(pkt))
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.CopyN(io.Discard, remote, total)
+		done <- err
+	}()
+
+	b.SetBytes(int64(len(pkt)))
+	b.ResetTimer()
+	l.TunToHTTP()
+	select {
+	case err = <-done:
+	case err = <-l.ErrChan:
+	}
+	if err != nil {
+		b.Fatal(err)
+	}
+}
+
+func BenchmarkTunToHTTP(b *testing.B) {
+	b.Run("single", func(b *testing.B) { benchmarkTunToHTTP(b, 0, 1) })
+	b.Run("queue", func(b *testing.B) { benchmarkTunToHTTP(b, 64, 1) })
+	b.Run("batch", func(b *testing.B) { benchmarkTunToHTTP(b, 64, 32) })
+}
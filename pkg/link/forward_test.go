@@ -0,0 +1,110 @@
+package link
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// echoF5 is the F5 server, which sends every received F5 packet back
+func echoF5(conn io.ReadWriter) {
+	r := bufio.NewReader(conn)
+	header := make([]byte, 4)
+	buf := make([]byte, 0xffff+len(header))
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return
+		}
+		n := copy(buf, header)
+		n += int(binary.BigEndian.Uint16(header[2:]))
+		if _, err := io.ReadFull(r, buf[len(header):n]); err != nil {
+			return
+		}
+		if _, err := conn.Write(buf[:n]); err != nil {
+			return
+		}
+	}
+}
+
+// runEcho sends n packets through the packet loop and the echo F5 server and
+// waits, until all of them come back into the tun
+func runEcho(tb testing.TB, pkt []byte, n, queueDepth, batchSize int) *memTun {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	go echoF5(server)
+
+	tun := &memTun{
+		pkt:  pkt,
+		n:    n,
+		want: int64(n),
+		done: make(chan struct{}),
+	}
+	tunUp := make(chan struct{})
+	close(tunUp)
+	l := &vpnLink{
+		HTTPConn:   client,
+		ErrChan:    make(chan error, 2),
+		TunDown:    make(chan struct{}),
+		tunUp:      tunUp,
+		iface:      tun,
+		bufSize:    bufferSize,
+		queueDepth: queueDepth,
+		batchSize:  batchSize,
+	}
+	l.setHTTPReader()
+	l.Forward()
+	defer close(l.TunDown)
+
+	select {
+	case <-tun.done:
+	case err := <-l.ErrChan:
+		tb.Fatalf("packet loop failed: %v", err)
+	case <-time.After(time.Minute):
+		tb.Fatalf("timed out, %d of %d packets received", tun.received.Load(), n)
+	}
+	return tun
+}
+
+func TestForward(t *testing.T) {
+	pkt := make([]byte, 100)
+	pkt[0] = 0x45
+	for i := 1; i < len(pkt); i++ {
+		pkt[i] = byte(i)
+	}
+
+	for name, v := range map[string][2]int{
+		"single": {0, 1},
+		"queue":  {64, 1},
+		"batch":  {64, 32},
+	} {
+		t.Run(name, func(t *testing.T) {
+			tun := runEcho(t, pkt, 1000, v[0], v[1])
+			if n := tun.bad.Load(); n > 0 {
+				t.Errorf("%d packets were corrupted", n)
+			}
+		})
+	}
+}
+
+func benchmarkForward(b *testing.B, queueDepth, batchSize int) {
+	pkt := make([]byte, 1400)
+	pkt[0] = 0x45
+
+	b.SetBytes(int64(len(pkt)))
+	b.ResetTimer()
+	start := time.Now()
+	runEcho(b, pkt, b.N, queueDepth, batchSize)
+	b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "pkts/s")
+}
+
+// BenchmarkForward measures the round trip of the packets through both
+// directions of the packet loop
+func BenchmarkForward(b *testing.B) {
+	b.Run("single", func(b *testing.B) { benchmarkForward(b, 0, 1) })
+	b.Run("queue", func(b *testing.B) { benchmarkForward(b, 64, 1) })
+	b.Run("batch", func(b *testing.B) { benchmarkForward(b, 64, 32) })
+}
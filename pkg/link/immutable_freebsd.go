@@ -0,0 +1,26 @@
+//go:build freebsd
+// +build freebsd
+
+package link
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+const (
+	clearImmutableCmd = "chflags noschg,nouchg"
+
+	// sys/stat.h file flags, not exported by x/sys
+	ufImmutable = 0x00000002
+	sfImmutable = 0x00020000
+)
+
+// isImmutable reports, whether the file has the immutable flag, which denies
+// any change even to root
+func isImmutable(path string) (bool, error) {
+	var st unix.Stat_t
+	if err := unix.Stat(path, &st); err != nil {
+		return false, err
+	}
+	return st.Flags&(ufImmutable|sfImmutable) != 0, nil
+}
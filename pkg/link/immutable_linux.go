@@ -0,0 +1,20 @@
+//go:build linux
+// +build linux
+
+package link
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+const clearImmutableCmd = "chattr -i"
+
+// isImmutable reports, whether the file has the immutable attribute, which
+// denies any change even to root
+func isImmutable(path string) (bool, error) {
+	var stx unix.Statx_t
+	if err := unix.Statx(unix.AT_FDCWD, path, 0, unix.STATX_BASIC_STATS, &stx); err != nil {
+		return false, err
+	}
+	return stx.Attributes_mask&stx.Attributes&unix.STATX_ATTR_IMMUTABLE != 0, nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !freebsd && !windows && !darwin
+// +build !linux,!freebsd,!windows,!darwin
+
+package link
+
+const clearImmutableCmd = ""
+
+func isImmutable(_ string) (bool, error) {
+	return false, nil
+}
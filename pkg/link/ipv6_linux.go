@@ -0,0 +1,123 @@
+//go:build linux
+// +build linux
+
+package link
+
+import (
+	"fmt"
+	"log"
+	"net"
+
+	"github.com/kayrus/gof5/pkg/config"
+
+	"github.com/IBM/netaddr"
+	"github.com/kayrus/tuncfg/route"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// global unicast IPv6 addresses
+var globalIPv6 = &net.IPNet{
+	IP:   net.ParseIP("2000::"),
+	Mask: net.CIDRMask(3, 128),
+}
+
+// excludeServerIPv6 removes F5 gateway IPv6 addresses from the set
+func (l *vpnLink) excludeServerIPv6(routes *netaddr.IPSet) {
+	for _, dst := range l.serverIPs {
+		if dst.To4() == nil {
+			routes.RemoveNet(&net.IPNet{
+				IP:   dst,
+				Mask: net.CIDRMask(128, 128),
+			})
+		}
+	}
+}
+
+// assign the IPv6 address, pushed from F5, the address is assigned even when
+// the routes are not managed
+func (l *vpnLink) assignIPv6() error {
+	if l.assignedIPv6 == nil {
+		return nil
+	}
+	iface, err := netlink.LinkByName(l.name)
+	if err != nil {
+		return fmt.Errorf("failed to detect %s interface: %s", l.name, err)
+	}
+	addr := &netlink.Addr{
+		IPNet: &net.IPNet{
+			IP:   l.assignedIPv6,
+			Mask: net.CIDRMask(128, 128),
+		},
+	}
+	if err = netlink.AddrReplace(iface, addr); err != nil {
+		return fmt.Errorf("failed to set %s IPv6 address on %s interface: %s", l.assignedIPv6, l.name, err)
+	}
+	log.Printf("Assigned %s IPv6 address to %s interface", l.assignedIPv6, l.name)
+	return nil
+}
+
+// assign the IPv6 address and set IPv6 routes, pushed from F5
+func (l *vpnLink) configureIPv6(cfg *config.Config) error {
+	if err := l.assignIPv6(); err != nil {
+		return err
+	}
+
+	routes := cfg.F5Config.Object.Routes6
+	if routes == nil {
+		return nil
+	}
+	l.excludeServerIPv6(routes)
+
+	log.Printf("Setting IPv6 routes on %s interface", l.name)
+	var err error
+	l.routeHandler6, err = route.New(l.name, routes.GetNetworks(), nil, routePriority(cfg))
+	if err != nil {
+		return err
+	}
+	l.routeHandler6.Add()
+
+	return nil
+}
+
+// drop all global IPv6 traffic, except the F5 gateway
+func (l *vpnLink) blackholeIPv6() error {
+	routes := &netaddr.IPSet{}
+	routes.InsertNet(globalIPv6)
+	l.excludeServerIPv6(routes)
+
+	log.Printf("Blocking IPv6 traffic")
+	for _, dst := range routes.GetNetworks() {
+		r := &netlink.Route{
+			Dst:  dst,
+			Type: unix.RTN_BLACKHOLE,
+		}
+		if err := netlink.RouteReplace(r); err != nil {
+			return fmt.Errorf("failed to add %s IPv6 blackhole route: %s", dst, err)
+		}
+		l.blackhole6 = append(l.blackhole6, dst)
+	}
+
+	return nil
+}
+
+func (l *vpnLink) restoreIPv6() {
+	if l.routeHandler6 != nil {
+		log.Printf("Removing IPv6 routes from %s interface", l.name)
+		l.routeHandler6.Del()
+	}
+
+	if len(l.blackhole6) > 0 {
+		log.Printf("Unblocking IPv6 traffic")
+	}
+	for _, dst := range l.blackhole6 {
+		r := &netlink.Route{
+			Dst:  dst,
+			Type: unix.RTN_BLACKHOLE,
+		}
+		if err := netlink.RouteDel(r); err != nil {
+			log.Printf("failed to delete %s IPv6 blackhole route: %s", dst, err)
+		}
+	}
+	l.blackhole6 = nil
+}
@@ -0,0 +1,29 @@
+//go:build !linux
+// +build !linux
+
+package link
+
+import (
+	"fmt"
+	"log"
+	"runtime"
+
+	"github.com/kayrus/gof5/pkg/config"
+)
+
+func (l *vpnLink) configureIPv6(_ *config.Config) error {
+	log.Printf("IPv6 address and routes configuration is not supported in %s", runtime.GOOS)
+	return nil
+}
+
+func (l *vpnLink) assignIPv6() error {
+	log.Printf("IPv6 address configuration is not supported in %s", runtime.GOOS)
+	return nil
+}
+
+func (l *vpnLink) blackholeIPv6() error {
+	return fmt.Errorf("disableIPv6 option is not supported in %s", runtime.GOOS)
+}
+
+func (l *vpnLink) restoreIPv6() {
+}
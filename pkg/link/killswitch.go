@@ -0,0 +1,52 @@
+package link
+
+import (
+	"log"
+	"net"
+
+	"github.com/kayrus/gof5/pkg/config"
+)
+
+// enableKillSwitch blocks all the traffic outside the tunnel except the F5
+// server and the local DNS servers, required to resolve the F5 server name on
+// reconnect
+func (l *vpnLink) enableKillSwitch(cfg *config.Config) error {
+	if !cfg.KillSwitch {
+		return nil
+	}
+
+	var dns []net.IP
+	if l.resolvHandler != nil {
+		dns = append(dns, l.resolvHandler.GetOriginalDNS()...)
+	}
+	for _, v := range cfg.DNSServers {
+		if !containsIP(dns, v) {
+			dns = append(dns, v)
+		}
+	}
+
+	log.Printf("Enabling kill switch, only %s interface and %q F5 server addresses are allowed", l.name, l.serverIPs)
+	return l.setKillSwitch(cfg, dns)
+}
+
+func containsIP(list []net.IP, ip net.IP) bool {
+	for _, v := range list {
+		if v.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitIPs splits the addresses by the family
+func splitIPs(ips []net.IP) ([]string, []string) {
+	var v4, v6 []string
+	for _, v := range ips {
+		if v.To4() != nil {
+			v4 = append(v4, v.String())
+		} else {
+			v6 = append(v6, v.String())
+		}
+	}
+	return v4, v6
+}
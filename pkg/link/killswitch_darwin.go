@@ -0,0 +1,85 @@
+package link
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/kayrus/gof5/pkg/config"
+)
+
+// the default macOS pf.conf evaluates the "com.apple/*" anchors
+const killSwitchAnchor = "com.apple/gof5"
+
+var (
+	pfTokenRegexp = regexp.MustCompile(`(?m)^Token\s*:\s*(\d+)`)
+	// pf reference token, pf stays enabled, when it is used by other
+	// applications
+	pfToken   string
+	pfTokenMu sync.Mutex
+)
+
+// setKillSwitch loads the kill switch rules into the pf anchor
+func (l *vpnLink) setKillSwitch(_ *config.Config, dns []net.IP) error {
+	var rules []string
+	rules = append(rules,
+		"pass out quick on lo0 all",
+		fmt.Sprintf("pass out quick on %s all", l.name),
+		// DHCP and IPv6 neighbor discovery keep the physical link alive
+		"pass out quick proto udp to any port { 67, 547 }",
+		"pass out quick inet6 proto ipv6-icmp all",
+	)
+	servers := make([]string, 0, len(l.serverIPs))
+	for _, v := range l.serverIPs {
+		servers = append(servers, v.String())
+	}
+	rules = append(rules, fmt.Sprintf("pass out quick to { %s }", strings.Join(servers, ", ")))
+	if len(dns) > 0 {
+		v := make([]string, 0, len(dns))
+		for _, s := range dns {
+			v = append(v, s.String())
+		}
+		rules = append(rules, fmt.Sprintf("pass out quick proto { tcp, udp } to { %s } port 53", strings.Join(v, ", ")))
+	}
+	rules = append(rules, "block drop out quick all")
+
+	if err := runCmdInput(strings.Join(rules, "\n")+"\n", "pfctl", "-a", killSwitchAnchor, "-f", "-"); err != nil {
+		return err
+	}
+
+	pfTokenMu.Lock()
+	defer pfTokenMu.Unlock()
+	if pfToken != "" {
+		return nil
+	}
+	out, err := exec.Command("pfctl", "-E").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to enable pf: %s: %s", err, strings.TrimSpace(string(out)))
+	}
+	if v := pfTokenRegexp.FindSubmatch(out); v != nil {
+		pfToken = string(v[1])
+	}
+	return nil
+}
+
+// DisableKillSwitch flushes the kill switch anchor and releases the pf
+// reference
+func DisableKillSwitch() {
+	if runCmd("pfctl", "-a", killSwitchAnchor, "-F", "all") != nil {
+		return
+	}
+
+	pfTokenMu.Lock()
+	defer pfTokenMu.Unlock()
+	if pfToken != "" {
+		if err := runCmd("pfctl", "-X", pfToken); err != nil {
+			log.Printf("Failed to release pf reference: %s", err)
+		}
+		pfToken = ""
+	}
+	log.Printf("Kill switch is disabled")
+}
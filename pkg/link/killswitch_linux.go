@@ -0,0 +1,134 @@
+package link
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os/exec"
+	"strings"
+
+	"github.com/kayrus/gof5/pkg/config"
+)
+
+const (
+	killSwitchTable = "gof5"
+	killSwitchChain = "GOF5"
+)
+
+// setKillSwitch installs the kill switch rules using nftables, or iptables,
+// when nft is not available
+func (l *vpnLink) setKillSwitch(_ *config.Config, dns []net.IP) error {
+	if _, err := exec.LookPath("nft"); err == nil {
+		return nftKillSwitch(l.name, l.serverIPs, dns)
+	}
+	return iptablesKillSwitch(l.name, l.serverIPs, dns)
+}
+
+func nftKillSwitch(name string, servers, dns []net.IP) error {
+	servers4, servers6 := splitIPs(servers)
+	dns4, dns6 := splitIPs(dns)
+
+	rules := []string{
+		`oifname "lo" accept`,
+		fmt.Sprintf("oifname %q accept", name),
+		// DHCP and IPv6 neighbor discovery keep the physical link alive
+		"udp dport { 67, 547 } accept",
+		"icmpv6 type { nd-router-solicit, nd-neighbor-solicit, nd-neighbor-advert } accept",
+	}
+	if len(servers4) > 0 {
+		rules = append(rules, fmt.Sprintf("ip daddr { %s } accept", strings.Join(servers4, ", ")))
+	}
+	if len(servers6) > 0 {
+		rules = append(rules, fmt.Sprintf("ip6 daddr { %s } accept", strings.Join(servers6, ", ")))
+	}
+	if len(dns4) > 0 {
+		rules = append(rules, fmt.Sprintf("ip daddr { %s } meta l4proto { tcp, udp } th dport 53 accept", strings.Join(dns4, ", ")))
+	}
+	if len(dns6) > 0 {
+		rules = append(rules, fmt.Sprintf("ip6 daddr { %s } meta l4proto { tcp, udp } th dport 53 accept", strings.Join(dns6, ", ")))
+	}
+
+	// re-create the table atomically
+	ruleset := fmt.Sprintf("table inet %[1]s\ndelete table inet %[1]s\ntable inet %[1]s {\n\tchain output {\n\t\ttype filter hook output priority 0; policy drop;\n\t\t%[2]s\n\t}\n}\n",
+		killSwitchTable, strings.Join(rules, "\n\t\t"))
+	return runCmdInput(ruleset, "nft", "-f", "-")
+}
+
+func iptablesKillSwitch(name string, servers, dns []net.IP) error {
+	servers4, servers6 := splitIPs(servers)
+	dns4, dns6 := splitIPs(dns)
+
+	for _, v := range []struct {
+		cmd     string
+		servers []string
+		dns     []string
+		extra   [][]string
+	}{
+		{"iptables", servers4, dns4, [][]string{{"-p", "udp", "--dport", "67"}}},
+		{"ip6tables", servers6, dns6, [][]string{{"-p", "udp", "--dport", "547"}, {"-p", "ipv6-icmp"}}},
+	} {
+		// the chain may exist after the previous connection
+		runCmd(v.cmd, "-N", killSwitchChain)
+		if err := runCmd(v.cmd, "-F", killSwitchChain); err != nil {
+			return err
+		}
+
+		rules := [][]string{
+			{"-o", "lo"},
+			{"-o", name},
+		}
+		rules = append(rules, v.extra...)
+		for _, s := range v.servers {
+			rules = append(rules, []string{"-d", s})
+		}
+		for _, s := range v.dns {
+			rules = append(rules,
+				[]string{"-d", s, "-p", "udp", "--dport", "53"},
+				[]string{"-d", s, "-p", "tcp", "--dport", "53"},
+			)
+		}
+		for _, r := range rules {
+			args := append([]string{"-A", killSwitchChain}, r...)
+			if err := runCmd(v.cmd, append(args, "-j", "ACCEPT")...); err != nil {
+				return err
+			}
+		}
+		if err := runCmd(v.cmd, "-A", killSwitchChain, "-j", "DROP"); err != nil {
+			return err
+		}
+		if runCmd(v.cmd, "-C", "OUTPUT", "-j", killSwitchChain) != nil {
+			if err := runCmd(v.cmd, "-I", "OUTPUT", "-j", killSwitchChain); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// DisableKillSwitch removes the kill switch rules
+func DisableKillSwitch() {
+	var removed bool
+	if _, err := exec.LookPath("nft"); err == nil {
+		if runCmd("nft", "list", "table", "inet", killSwitchTable) == nil {
+			if err := runCmd("nft", "delete", "table", "inet", killSwitchTable); err != nil {
+				log.Printf("Failed to remove kill switch rules: %s", err)
+			}
+			removed = true
+		}
+	}
+	for _, cmd := range []string{"iptables", "ip6tables"} {
+		if _, err := exec.LookPath(cmd); err != nil || runCmd(cmd, "-C", "OUTPUT", "-j", killSwitchChain) != nil {
+			continue
+		}
+		if err := runCmd(cmd, "-D", "OUTPUT", "-j", killSwitchChain); err != nil {
+			log.Printf("Failed to remove kill switch rules: %s", err)
+		}
+		runCmd(cmd, "-F", killSwitchChain)
+		runCmd(cmd, "-X", killSwitchChain)
+		removed = true
+	}
+	if removed {
+		log.Printf("Kill switch is disabled")
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !linux && !darwin && !windows
+// +build !linux,!darwin,!windows
+
+package link
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+
+	"github.com/kayrus/gof5/pkg/config"
+)
+
+func (l *vpnLink) setKillSwitch(_ *config.Config, _ []net.IP) error {
+	return fmt.Errorf("kill switch is not supported in %s", runtime.GOOS)
+}
+
+func DisableKillSwitch() {
+}
@@ -0,0 +1,62 @@
+package link
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sync"
+
+	"github.com/kayrus/gof5/pkg/config"
+
+	"golang.zx2c4.com/wireguard/windows/tunnel/firewall"
+	"golang.zx2c4.com/wireguard/windows/tunnel/winipcfg"
+)
+
+var (
+	killSwitchMu      sync.Mutex
+	killSwitchEnabled bool
+)
+
+// setKillSwitch installs the WFP filters, which permit gof5 itself, the tunnel
+// interface and the DNS servers; the filters are removed by Windows, when the
+// process exits
+func (l *vpnLink) setKillSwitch(cfg *config.Config, dns []net.IP) error {
+	iface, err := net.InterfaceByName(l.name)
+	if err != nil {
+		return err
+	}
+	luid, err := winipcfg.LUIDFromIndex(uint32(iface.Index))
+	if err != nil {
+		return fmt.Errorf("failed to detect %s interface LUID: %s", l.name, err)
+	}
+
+	// DNS queries are sent by the DNS client service, not by gof5
+	for _, v := range DNSServers(cfg) {
+		if !containsIP(dns, v) {
+			dns = append(dns, v)
+		}
+	}
+
+	killSwitchMu.Lock()
+	defer killSwitchMu.Unlock()
+	// the tunnel interface is re-created on reconnect
+	firewall.DisableFirewall()
+	if err := firewall.EnableFirewall(uint64(luid), false, dns); err != nil {
+		killSwitchEnabled = false
+		return fmt.Errorf("failed to enable kill switch: %s", err)
+	}
+	killSwitchEnabled = true
+	return nil
+}
+
+// DisableKillSwitch removes the WFP filters
+func DisableKillSwitch() {
+	killSwitchMu.Lock()
+	defer killSwitchMu.Unlock()
+	if !killSwitchEnabled {
+		return
+	}
+	firewall.DisableFirewall()
+	killSwitchEnabled = false
+	log.Printf("Kill switch is disabled")
+}
@@ -2,6 +2,7 @@ package link
 
 import (
 	"bufio"
+	"context"
 	"crypto/tls"
 	"encoding/base64"
 	"fmt"
@@ -11,12 +12,15 @@ import (
 	"net"
 	"net/http"
 	"runtime"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/kayrus/gof5/pkg/config"
 	"github.com/kayrus/gof5/pkg/dns"
+	"github.com/kayrus/gof5/pkg/util"
 
+	"github.com/IBM/netaddr"
 	"github.com/fatih/color"
 	"github.com/kayrus/tuncfg/resolv"
 	"github.com/kayrus/tuncfg/route"
@@ -30,14 +34,14 @@ const (
 	userAgentVPN = "Mozilla/5.0 (compatible; MSIE 10.0; Windows NT 6.1; Trident/6.0; F5 Networks Client)"
 )
 
-var colorlog = log.New(color.Error, "", log.LstdFlags)
-
 type vpnLink struct {
 	sync.Mutex
 	HTTPConn    io.ReadWriteCloser
 	ErrChan     chan error
 	TunDown     chan struct{}
 	PppdErrChan chan error
+	// Established is closed, when routes and DNS are configured
+	Established chan struct{}
 	iface       io.ReadWriteCloser
 	name        string
 	// pppUp is used to wait for the PPP handshake (wireguard only)
@@ -45,15 +49,35 @@ type vpnLink struct {
 	// tunUp is used to wait for the TUN interface (wireguard and pppd)
 	tunUp         chan struct{}
 	serverIPs     []net.IP
+	serverRoutes  []*serverRoute
+	bypassRoutes  []*serverRoute
 	localIPv4     net.IP
+	requestedIPv4 net.IP
 	serverIPv4    net.IP
 	localIPv6     net.IP
 	serverIPv6    net.IP
+	assignedIPv6  net.IP
 	mtu           []byte
 	mtuInt        uint16
+	bufSize       int
+	queueDepth    int
+	batchSize     int
+	httpReader    io.Reader
 	debug         bool
 	routeHandler  *route.Handler
+	routes        []*net.IPNet
+	routeHandler6 *route.Handler
+	netns         *netNS
+	blackhole6    []*net.IPNet
 	resolvHandler *resolv.Handler
+	resolvBackup  string
+	linkDNS       bool
+	// resolv.conf is written by gof5 bypassing the resolv handler
+	resolvDirect  bool
+	proxy         *proxyServer
+	restored      bool
+	stats         linkStats
+	defaultRoutes []defaultRoute
 }
 
 func randomHostname(n int) []byte {
@@ -68,21 +92,37 @@ func randomHostname(n int) []byte {
 	return b
 }
 
-// init a TLS connection
-func InitConnection(server string, cfg *config.Config, tlsConfig *tls.Config) (*vpnLink, error) {
+// setHTTPReader sets the reader of the F5 packets
+func (l *vpnLink) setHTTPReader() {
+	l.httpReader = l.HTTPConn
+	if l.batchSize > 1 {
+		// read multiple packets per syscall
+		l.httpReader = bufio.NewReaderSize(l.HTTPConn, l.batchSize*l.bufSize)
+	}
+}
+
+// init a TLS connection, the context aborts the server lookup, the dial and
+// the handshake
+func InitConnection(ctx context.Context, server string, cfg *config.Config, tlsConfig *tls.Config) (*vpnLink, error) {
+	detectAddressFamilies(cfg)
+
+	hostname := []byte(cfg.ReportedHostname)
+	if len(hostname) == 0 {
+		hostname = randomHostname(8)
+	}
 	getURL := fmt.Sprintf("https://%s/myvpn?sess=%s&hostname=%s&hdlc_framing=%s&ipv4=%s&ipv6=%s&Z=%s",
 		server,
 		cfg.F5Config.Object.SessionID,
-		base64.StdEncoding.EncodeToString(randomHostname(8)),
+		base64.StdEncoding.EncodeToString(hostname),
 		config.Bool(cfg.Driver == "pppd"),
 		cfg.F5Config.Object.IPv4,
-		config.Bool(cfg.IPv6 && bool(cfg.F5Config.Object.IPv6)),
+		config.Bool(cfg.TunnelIPv6()),
 		cfg.F5Config.Object.UrZ,
 	)
 
-	serverIPs, err := net.LookupIP(server)
+	serverIPs, err := net.DefaultResolver.LookupIP(ctx, "ip", server)
 	if err != nil || len(serverIPs) == 0 {
-		return nil, fmt.Errorf("failed to resolve %s: %s", server, err)
+		return nil, fmt.Errorf("failed to resolve %s: %w", server, err)
 	}
 
 	// define link channels
@@ -90,10 +130,31 @@ func InitConnection(server string, cfg *config.Config, tlsConfig *tls.Config) (*
 		ErrChan:     make(chan error, 1),
 		TunDown:     make(chan struct{}, 1),
 		PppdErrChan: make(chan error, 1),
+		Established: make(chan struct{}),
 		serverIPs:   serverIPs,
 		pppUp:       make(chan struct{}, 1),
 		tunUp:       make(chan struct{}, 1),
 		debug:       cfg.Debug,
+		bufSize:     bufferSize,
+		queueDepth:  cfg.TunnelQueueDepth,
+		batchSize:   1,
+	}
+	if cfg.RequestedAddress != "" {
+		l.requestedIPv4 = net.ParseIP(cfg.RequestedAddress).To4()
+	}
+	l.saveDefaultRoutes()
+	if cfg.Driver != "netstack" {
+		l.lookupServerRoutes(server)
+		l.bypassRoutes = lookupBypassRoutes(cfg.ExcludeRoutes)
+	}
+
+	if cfg.TunnelBufferSize > l.bufSize {
+		l.bufSize = cfg.TunnelBufferSize
+	}
+
+	// custom MTU may require a bigger buffer
+	if v := cfg.MTU + tun.Offset; v > l.bufSize {
+		l.bufSize = v
 	}
 
 	if cfg.DTLS && cfg.F5Config.Object.TunnelDTLS {
@@ -104,22 +165,66 @@ func InitConnection(server string, cfg *config.Config, tlsConfig *tls.Config) (*
 			return nil, fmt.Errorf("failed to resolve UDP address: %s", err)
 		}
 		conf := &dtls.Config{
-			RootCAs:            tlsConfig.RootCAs,
-			Certificates:       tlsConfig.Certificates,
-			InsecureSkipVerify: tlsConfig.InsecureSkipVerify,
-			ServerName:         server,
+			RootCAs:               tlsConfig.RootCAs,
+			Certificates:          tlsConfig.Certificates,
+			InsecureSkipVerify:    tlsConfig.InsecureSkipVerify,
+			VerifyPeerCertificate: tlsConfig.VerifyPeerCertificate,
+			ServerName:            server,
+		}
+		dialer, err := SourceDialer(cfg, "udp")
+		if err != nil {
+			return nil, err
 		}
-		l.HTTPConn, err = dtls.Dial("udp", addr, conf)
+		ctx, cancel := context.WithTimeout(ctx, cfg.DialTimeout)
+		conn, err := dialer.DialContext(ctx, "udp", addr.String())
+		if err == nil {
+			l.HTTPConn, err = dtls.ClientWithContext(ctx, conn, conf)
+			if err != nil {
+				conn.Close()
+			}
+		}
+		cancel()
 		if err != nil {
-			return nil, fmt.Errorf("failed to dial %s:%s: %s", server, cfg.F5Config.Object.TunnelPortDTLS, err)
+			return nil, fmt.Errorf("failed to dial %s:%s: %w", server, cfg.F5Config.Object.TunnelPortDTLS, err)
+		}
+		if cfg.TunnelBatchSize > 1 {
+			log.Printf("Warning: tunnelBatchSize is ignored with DTLS, every packet is sent in its own datagram")
 		}
 	} else {
-		l.HTTPConn, err = tls.Dial("tcp", fmt.Sprintf("%s:443", server), tlsConfig)
+		addr := fmt.Sprintf("%s:443", server)
+		dialer, err := SourceDialer(cfg, "tcp")
+		if err != nil {
+			return nil, err
+		}
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			if e, ok := err.(net.Error); ok && e.Timeout() {
+				return nil, fmt.Errorf("connection to %s timed out: %w", addr, err)
+			}
+			return nil, fmt.Errorf("failed to dial %s: %w", addr, err)
+		}
+		c := tls.Client(conn, tlsConfig)
+		ctx, cancel := context.WithTimeout(ctx, cfg.DialTimeout)
+		err = c.HandshakeContext(ctx)
+		cancel()
 		if err != nil {
-			return nil, fmt.Errorf("failed to dial %s:443: %s", server, err)
+			conn.Close()
+			return nil, fmt.Errorf("TLS handshake with %s failed: %s", addr, err)
+		}
+		l.HTTPConn = c
+
+		// pppd driver reads the raw HTTPS stream
+		if cfg.TunnelBatchSize > 1 && cfg.Driver != "pppd" {
+			l.batchSize = cfg.TunnelBatchSize
+			// the queued packets are batched
+			if l.queueDepth < l.batchSize {
+				l.queueDepth = l.batchSize
+			}
 		}
 	}
 
+	l.setHTTPReader()
+
 	req, err := http.NewRequest("GET", getURL, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create VPN session request: %s", err)
@@ -131,10 +236,18 @@ func InitConnection(server string, cfg *config.Config, tlsConfig *tls.Config) (*
 	}
 
 	if l.debug {
-		log.Printf("URL: %s", getURL)
+		if cfg.DebugUnsafe {
+			util.DebugLog.Printf("URL: %s", getURL)
+		} else {
+			util.DebugLog.Printf("URL: %s", util.RedactURL(req.URL))
+		}
 	}
 
-	resp, err := http.ReadResponse(bufio.NewReader(l.HTTPConn), nil)
+	br, ok := l.httpReader.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(l.HTTPConn)
+	}
+	resp, err := http.ReadResponse(br, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get initial VPN connection response: %s", err)
 	}
@@ -144,24 +257,32 @@ func InitConnection(server string, cfg *config.Config, tlsConfig *tls.Config) (*
 	l.serverIPv4 = net.ParseIP(resp.Header.Get("X-VPN-server-IP"))
 	l.localIPv6 = net.ParseIP(resp.Header.Get("X-VPN-client-IPv6"))
 	l.serverIPv6 = net.ParseIP(resp.Header.Get("X-VPN-server-IPv6"))
+	// PPP negotiates a link-local IPv6 address only, keep the assigned one
+	l.assignedIPv6 = l.localIPv6
 
 	if l.debug {
-		log.Printf("Client IP: %s", l.localIPv4)
-		log.Printf("Server IP: %s", l.serverIPv4)
+		util.DebugLog.Printf("Client IP: %s", l.localIPv4)
+		util.DebugLog.Printf("Server IP: %s", l.serverIPv4)
 		if l.localIPv6 != nil {
-			log.Printf("Client IPv6: %s", l.localIPv6)
+			util.DebugLog.Printf("Client IPv6: %s", l.localIPv6)
 		}
 		if l.localIPv6 != nil {
-			log.Printf("Server IPv6: %s", l.serverIPv6)
+			util.DebugLog.Printf("Server IPv6: %s", l.serverIPv6)
 		}
 	}
 
 	return l, nil
 }
 
-func (l *vpnLink) createTunDevice() error {
-	if l.mtuInt+tun.Offset > bufferSize {
-		return fmt.Errorf("MTU exceeds the %d buffer limit", bufferSize)
+func (l *vpnLink) createTunDevice(cfg *config.Config) error {
+	mtu := int(l.mtuInt)
+	if cfg.MTU > 0 {
+		log.Printf("Overriding %d MTU with %d", mtu, cfg.MTU)
+		mtu = cfg.MTU
+	}
+
+	if mtu+tun.Offset > l.bufSize {
+		return fmt.Errorf("MTU exceeds the %d buffer limit", l.bufSize)
 	}
 
 	log.Printf("Using wireguard module to create tunnel")
@@ -172,6 +293,9 @@ func (l *vpnLink) createTunDevice() error {
 	case "windows":
 		ifname = "gof5"
 	}
+	if cfg.InterfaceName != "" {
+		ifname = cfg.InterfaceName
+	}
 
 	local := &net.IPNet{
 		IP:   l.localIPv4,
@@ -181,9 +305,26 @@ func (l *vpnLink) createTunDevice() error {
 		IP:   l.serverIPv4,
 		Mask: net.CIDRMask(32, 32),
 	}
-	tunDev, err := tun.OpenTunDevice(local, gw, ifname, int(l.mtuInt))
+	if cfg.Offload {
+		t, name, flags, err := openOffloadTun(ifname, mtu, l.bufSize, local, gw)
+		if err == nil {
+			l.name = name
+			log.Printf("Created %s interface", l.name)
+			if cfg.Debug {
+				util.DebugLog.Printf("Enabled %s interface offload: %s", l.name, flags)
+			}
+			l.iface = t
+			close(l.tunUp)
+			return nil
+		}
+		if cfg.Debug {
+			util.DebugLog.Printf("Interface offload is not available, falling back to the plain interface: %s", err)
+		}
+	}
+
+	tunDev, err := tun.OpenTunDevice(local, gw, ifname, mtu)
 	if err != nil {
-		return fmt.Errorf("failed to create an interface: %s", err)
+		return &config.DriverError{Err: fmt.Errorf("failed to create an interface: %s", err)}
 	}
 	l.name, err = tunDev.Name()
 	if err != nil {
@@ -202,21 +343,35 @@ func (l *vpnLink) createTunDevice() error {
 	return nil
 }
 
+// DNSServers returns the DNS servers, provided by F5
+func DNSServers(cfg *config.Config) []net.IP {
+	vpnDNS := cfg.F5Config.Object.DNS
+	if cfg.TunnelIPv6() {
+		vpnDNS = append(append([]net.IP{}, vpnDNS...), cfg.F5Config.Object.DNS6...)
+	}
+	return cfg.TunneledIPs(vpnDNS)
+}
+
 func (l *vpnLink) configureDNS(cfg *config.Config) error {
 	var err error
 	// this is used only in linux/freebsd to store /etc/resolv.conf backup
 	resolv.AppName = "gof5"
 
+	vpnDNS := DNSServers(cfg)
+
 	dnsSuffixes := cfg.F5Config.Object.DNSSuffix
 	var dnsServers []net.IP
 	if len(cfg.DNS) == 0 {
 		// route everything through VPN gatewy
-		dnsServers = cfg.F5Config.Object.DNS
+		dnsServers = vpnDNS
 	} else {
 		// route only configured suffixes via local DNS proxy
 		dnsServers = []net.IP{cfg.ListenDNS}
 	}
 
+	// restore the DNS settings left by a killed gof5 process
+	checkResolvBackup(cfg)
+
 	// define DNS servers, provided by F5
 	l.resolvHandler, err = resolv.New(l.name, dnsServers, dnsSuffixes, cfg.RewriteResolv)
 	if err != nil {
@@ -231,7 +386,9 @@ func (l *vpnLink) configureDNS(cfg *config.Config) error {
 		return nil
 	}
 
-	if len(cfg.DNS) > 0 && !l.resolvHandler.IsResolve() {
+	l.linkDNS = l.useLinkDNS(cfg)
+
+	if len(cfg.DNS) > 0 && !l.linkDNS {
 		// combine local network search with VPN gateway search
 		dnsSuffixes = l.resolvHandler.GetOriginalSuffixes()
 		existingSuffixes := make(map[string]bool)
@@ -247,55 +404,176 @@ func (l *vpnLink) configureDNS(cfg *config.Config) error {
 		l.resolvHandler.SetSuffixes(dnsSuffixes)
 	}
 
-	if l.resolvHandler.IsResolve() {
+	// serve own DNS proxy, when systemd-resolved is not available
+	if len(cfg.DNS) > 0 && !l.linkDNS {
+		if cfg.ListenDNSPort != 53 {
+			// resolv.conf doesn't support custom nameserver ports
+			log.Printf("Warning: system resolver cannot be pointed to a custom %d DNS port, forward DNS queries to %s manually",
+				cfg.ListenDNSPort, net.JoinHostPort(cfg.ListenDNS.String(), strconv.Itoa(cfg.ListenDNSPort)))
+			dnsServers = l.resolvHandler.GetOriginalDNS()
+			l.resolvHandler.SetDNSServers(dnsServers)
+		}
+		// bind the DNS proxy before altering the system DNS settings
+		if err = dns.Start(cfg, l.ErrChan, l.TunDown); err != nil {
+			return err
+		}
+		if cfg.ListenDNSPort == 53 && !cfg.ListenDNS.Equal(dnsServers[0]) {
+			// the instance address was shifted
+			l.resolvHandler.SetDNSServers([]net.IP{cfg.ListenDNS})
+		}
+	}
+
+	if l.linkDNS {
 		// resolve daemon will route necessary domains through VPN gatewy
-		log.Printf("Detected systemd-resolved")
-		l.resolvHandler.SetDNSServers(cfg.F5Config.Object.DNS)
+		if cfg.DNSMethod == "nmcli" {
+			log.Printf("Using NetworkManager command line tools")
+		} else {
+			log.Printf("Using systemd-resolved")
+		}
 		if len(cfg.DNS) > 0 {
-			log.Printf("Forwarding %q DNS requests to %q", cfg.DNS, cfg.F5Config.Object.DNS)
-			l.resolvHandler.SetDNSDomains(cfg.DNS)
+			log.Printf("Forwarding %q DNS requests to %q", cfg.DNS, vpnDNS)
 			log.Printf("Default DNS servers: %q", l.resolvHandler.GetOriginalDNS())
 		} else {
 			// route all DNS queries via VPN
-			log.Printf("Forwarding all DNS requests to %q", cfg.F5Config.Object.DNS)
-			l.resolvHandler.SetDNSDomains([]string{"."})
+			log.Printf("Forwarding all DNS requests to %q", vpnDNS)
 		}
+		return l.setLinkDNS(cfg, cfg.DNS)
 	}
 
-	// set DNS and additionally detect original DNS servers, e.g. when NetworkManager is used
-	err = l.resolvHandler.Set()
+	if err = l.checkResolvConf(cfg); err != nil {
+		return err
+	}
+	if err = l.backupResolvConf(cfg); err != nil {
+		return err
+	}
+
+	if cfg.DNSMethod == "resolvconf" || l.resolvDirect {
+		err = l.writeResolvConf(dnsServers, dnsSuffixes)
+	} else {
+		// set DNS and additionally detect original DNS servers, e.g. when NetworkManager is used
+		err = l.resolvHandler.Set()
+	}
 	if err != nil {
 		return err
 	}
 
-	if !l.resolvHandler.IsResolve() {
-		if len(cfg.DNS) == 0 {
-			log.Printf("Forwarding all DNS requests to %q", cfg.F5Config.Object.DNS)
-			return nil
+	if len(cfg.DNS) == 0 {
+		log.Printf("Forwarding all DNS requests to %q", vpnDNS)
+		return nil
+	}
+	cfg.DNSServers = l.resolvHandler.GetOriginalDNS()
+	log.Printf("Serving DNS proxy on %s", net.JoinHostPort(cfg.ListenDNS.String(), strconv.Itoa(cfg.ListenDNSPort)))
+	log.Printf("Forwarding %q DNS requests to %q", cfg.DNS, vpnDNS)
+	log.Printf("Default DNS servers: %q", cfg.DNSServers)
+
+	return nil
+}
+
+// buildRoutes returns the list of routes to be installed on the VPN interface
+func (l *vpnLink) buildRoutes(cfg *config.Config) []*net.IPNet {
+	if !cfg.TunnelIPv4() {
+		log.Printf("IPv4 is not tunneled, skipping IPv4 routes")
+		return nil
+	}
+
+	// set custom routes
+	src := cfg.Routes
+	if src == nil {
+		log.Printf("Applying routes, pushed from F5 VPN server")
+		src = cfg.F5Config.Object.Routes
+	}
+	// copy the set, the source must stay intact for config reloads
+	routes := new(netaddr.IPSet)
+	if src != nil {
+		routes = routes.Union(src)
+	}
+
+	// include custom subnets
+	for _, dst := range cfg.IncludeRoutes {
+		routes.InsertNet(dst)
+	}
+
+	// exclude F5 gateway IPs
+	for _, dst := range l.serverIPs {
+		// exclude only ipv4
+		if v := dst.To4(); v != nil {
+			local := &net.IPNet{
+				IP:   v,
+				Mask: net.CIDRMask(32, 32),
+			}
+			routes.RemoveNet(local)
+		}
+	}
+
+	// exclude custom subnets, the routes via the original gateway are added
+	// separately, and the more specific F5 routes must not capture them
+	for _, dst := range cfg.ExcludeRoutes {
+		routes.RemoveNet(dst)
+	}
+
+	// exclude local DNS servers, when they are not located inside the LAN
+	if l.resolvHandler != nil {
+		for _, v := range l.resolvHandler.GetOriginalDNS() {
+			localDNS := &net.IPNet{
+				IP:   v,
+				Mask: net.CIDRMask(32, 32),
+			}
+			routes.RemoveNet(localDNS)
 		}
-		cfg.DNSServers = l.resolvHandler.GetOriginalDNS()
-		log.Printf("Serving DNS proxy on %s:53", cfg.ListenDNS)
-		log.Printf("Forwarding %q DNS requests to %q", cfg.DNS, cfg.F5Config.Object.DNS)
-		log.Printf("Default DNS servers: %q", cfg.DNSServers)
-		dns.Start(cfg, l.ErrChan, l.TunDown)
 	}
 
+	return routes.GetNetworks()
+}
+
+func (l *vpnLink) routeGateway() net.IP {
+	if runtime.GOOS == "windows" {
+		// windows requires both gateway and interface name
+		return l.serverIPv4
+	}
 	return nil
 }
 
 // wait for pppd and config DNS and routes
 func (l *vpnLink) WaitAndConfig(cfg *config.Config) {
 	// wait for ppp handshake completed
-	<-l.pppUp
+	traceDone := util.TracePhase("ppp handshake")
+	select {
+	case <-l.pppUp:
+	case <-l.TunDown:
+		return
+	}
+	traceDone()
 
 	l.Lock()
 	defer l.Unlock()
 
+	// the link is already torn down
+	if l.restored {
+		return
+	}
+
 	var err error
 
+	if cfg.Driver == "netstack" {
+		// neither routes nor DNS of the host are altered
+		traceDone = util.TracePhase("netstack creation")
+		err = l.createNetstack(cfg)
+		traceDone()
+		if err != nil {
+			l.ErrChan <- err
+			return
+		}
+		util.ColorLog.Print(color.HiGreenString("Connection established"))
+		l.touch()
+		close(l.Established)
+		return
+	}
+
 	if cfg.Driver != "pppd" {
 		// create TUN
-		err = l.createTunDevice()
+		traceDone = util.TracePhase("tun creation")
+		err = l.createTunDevice(cfg)
+		traceDone()
 		if err != nil {
 			l.ErrChan <- err
 			return
@@ -310,7 +588,52 @@ func (l *vpnLink) WaitAndConfig(cfg *config.Config) {
 		}()
 	}
 
-	err = l.configureDNS(cfg)
+	if cfg.NetNS != "" {
+		if err = l.moveToNetNS(cfg); err != nil {
+			l.ErrChan <- err
+			return
+		}
+		// routes and DNS are configured inside the namespace
+		var leave func()
+		leave, err = l.enterNetNS()
+		if err != nil {
+			l.ErrChan <- err
+			return
+		}
+		defer leave()
+	}
+
+	if !manageRoutes(cfg) {
+		// the routes and DNS are applied externally, the addresses are still
+		// assigned
+		if cfg.TunnelIPv6() && !cfg.DisableIPv6 {
+			if err = l.assignIPv6(); err != nil {
+				l.ErrChan <- err
+				return
+			}
+		}
+		l.routes = l.buildRoutes(cfg)
+		l.logManualRoutes(cfg)
+		if cfg.ProxyListen != "" {
+			if err = l.startProxy(cfg); err != nil {
+				l.ErrChan <- err
+				return
+			}
+		}
+		l.startMTUProbe(cfg)
+		util.ColorLog.Print(color.HiGreenString("Connection established"))
+		l.touch()
+		close(l.Established)
+		return
+	}
+
+	traceDone = util.TracePhase("dns configuration")
+	if l.netns != nil {
+		err = l.setNetNSDNS(cfg)
+	} else {
+		err = l.configureDNS(cfg)
+	}
+	traceDone()
 	if err != nil {
 		l.ErrChan <- err
 		return
@@ -318,65 +641,217 @@ func (l *vpnLink) WaitAndConfig(cfg *config.Config) {
 
 	// set routes
 	log.Printf("Setting routes on %s interface", l.name)
+	traceDone = util.TracePhase("route installation")
 
-	// set custom routes
-	routes := cfg.Routes
-	if routes == nil {
-		log.Printf("Applying routes, pushed from F5 VPN server")
-		routes = cfg.F5Config.Object.Routes
+	if err = setInterfaceMetric(l.name, cfg); err != nil {
+		l.ErrChan <- err
+		return
+	}
+	if l.netns == nil {
+		// the F5 connection is not affected by the namespace routes
+		l.pinServerRoutes(cfg)
 	}
+	l.routes = l.buildRoutes(cfg)
+	l.routeHandler, err = route.New(l.name, l.routes, l.routeGateway(), routePriority(cfg))
+	if err != nil {
+		l.ErrChan <- err
+		return
+	}
+	l.routeHandler.Add()
+	traceDone()
 
-	// exclude F5 gateway IPs
-	for _, dst := range l.serverIPs {
-		// exclude only ipv4
-		if v := dst.To4(); v != nil {
-			local := &net.IPNet{
-				IP:   v,
-				Mask: net.CIDRMask(32, 32),
-			}
-			routes.RemoveNet(local)
+	if err = l.enableKillSwitch(cfg); err != nil {
+		l.ErrChan <- err
+		return
+	}
+
+	if cfg.DisableIPv6 {
+		if err = l.blackholeIPv6(); err != nil {
+			l.ErrChan <- err
+			return
+		}
+	} else if cfg.TunnelIPv6() {
+		if err = l.configureIPv6(cfg); err != nil {
+			l.ErrChan <- err
+			return
 		}
 	}
 
-	// exclude local DNS servers, when they are not located inside the LAN
-	for _, v := range l.resolvHandler.GetOriginalDNS() {
-		localDNS := &net.IPNet{
-			IP:   v,
-			Mask: net.CIDRMask(32, 32),
+	if cfg.ProxyListen != "" {
+		if err = l.startProxy(cfg); err != nil {
+			l.ErrChan <- err
+			return
 		}
-		routes.RemoveNet(localDNS)
 	}
 
-	var gw net.IP
-	if runtime.GOOS == "windows" {
-		// windows requires both gateway and interface name
-		gw = l.serverIPv4
+	l.startMTUProbe(cfg)
+	util.ColorLog.Print(color.HiGreenString("Connection established"))
+	l.touch()
+	close(l.Established)
+}
+
+// detectAddressFamilies detects the address families, the host has no address
+// of, e.g. when IPv6 is disabled in the kernel, the routes of these families
+// would fail to install; the families are skipped with "addressFamilies: both"
+func detectAddressFamilies(cfg *config.Config) {
+	cfg.NoLocalIPv4, cfg.NoLocalIPv6 = false, false
+	if cfg.AddressFamilies != "both" {
+		return
 	}
 
-	l.routeHandler, err = route.New(l.name, routes.GetNetworks(), gw, 0)
+	addrs, err := net.InterfaceAddrs()
 	if err != nil {
-		l.ErrChan <- err
+		log.Printf("Failed to detect local addresses: %s", err)
 		return
 	}
-	l.routeHandler.Add()
+	v4, v6 := globalAddressFamilies(addrs)
+	if !v4 && !v6 {
+		// don't skip everything on a detection failure
+		return
+	}
+
+	cfg.NoLocalIPv4, cfg.NoLocalIPv6 = !v4, !v6
+	if cfg.NoLocalIPv4 {
+		log.Printf("The host has no IPv4 address, skipping IPv4 routes and DNS records")
+	}
+	if cfg.NoLocalIPv6 && cfg.IPv6 {
+		log.Printf("The host has no IPv6 address, skipping IPv6 routes and DNS records")
+	}
+}
+
+// globalAddressFamilies reports, whether the addresses contain a global
+// unicast IPv4 and IPv6 address; the loopback and link-local addresses, e.g.
+// the IPv6 fe80::/10 address of every interface, don't route to the F5
+// networks
+func globalAddressFamilies(addrs []net.Addr) (v4, v6 bool) {
+	for _, v := range addrs {
+		ipNet, ok := v.(*net.IPNet)
+		if !ok || !ipNet.IP.IsGlobalUnicast() {
+			continue
+		}
+		if ipNet.IP.To4() != nil {
+			v4 = true
+		} else {
+			v6 = true
+		}
+	}
+	return
+}
+
+// manageRoutes returns false, when the routes and DNS must not be configured
+func manageRoutes(cfg *config.Config) bool {
+	return cfg.ManageRoutes == nil || *cfg.ManageRoutes
+}
+
+// logManualRoutes logs the routes and DNS servers, which would have been
+// installed, when the routes are managed
+func (l *vpnLink) logManualRoutes(cfg *config.Config) {
+	log.Printf("Routes are not managed, apply the following settings on %s interface externally", l.name)
+	for _, v := range l.routes {
+		log.Printf("Route: %s via %s", v, l.name)
+	}
+	for _, v := range l.bypassRoutes {
+		log.Printf("Route: %s", v)
+	}
+	if cfg.TunnelIPv6() && cfg.F5Config.Object.Routes6 != nil {
+		for _, v := range cfg.F5Config.Object.Routes6.GetNetworks() {
+			log.Printf("Route: %s via %s", v, l.name)
+		}
+	}
+	if cfg.DisableDNS {
+		return
+	}
+	for _, v := range DNSServers(cfg) {
+		log.Printf("DNS server: %s", v)
+	}
+	for _, v := range cfg.F5Config.Object.DNSSuffix {
+		log.Printf("DNS search domain: %s", v)
+	}
+}
+
+// Name returns the tunnel interface name
+func (l *vpnLink) Name() string {
+	return l.name
+}
+
+// LocalIPv4 returns the VPN IPv4 address, assigned to the client
+func (l *vpnLink) LocalIPv4() net.IP {
+	return l.localIPv4
+}
+
+// Routes returns the routes, installed on the VPN interface
+func (l *vpnLink) Routes() []*net.IPNet {
+	l.Lock()
+	defer l.Unlock()
+	return append([]*net.IPNet{}, l.routes...)
+}
 
-	colorlog.Print(color.HiGreenString("Connection established"))
+// DropPrivileges runs the drop func, unless the teardown has started; the
+// drop is serialized with RestoreConfig, thus setuid doesn't hit the threads
+// in the middle of the routes and DNS restore
+func (l *vpnLink) DropPrivileges(drop func()) bool {
+	select {
+	case <-l.TunDown:
+		return false
+	default:
+	}
+	l.Lock()
+	defer l.Unlock()
+	if l.restored {
+		return false
+	}
+	drop()
+	return true
 }
 
-// restore config
+// restore config, the privileged thread is used, when the privileges are
+// dropped
 func (l *vpnLink) RestoreConfig(cfg *config.Config) {
+	util.Privileged(func() { l.restoreConfig(cfg) })
+}
+
+func (l *vpnLink) restoreConfig(cfg *config.Config) {
 	l.Lock()
 	defer l.Unlock()
 
+	// the config can be restored only once
+	if l.restored {
+		return
+	}
+	l.restored = true
+
+	if l.proxy != nil {
+		l.proxy.Close()
+	}
+
+	leave, err := l.enterNetNS()
+	if err != nil {
+		log.Printf("Failed to remove the tunnel routes: %s", err)
+	} else {
+		defer leave()
+	}
+	defer l.restoreNetNS()
+
 	if l.routeHandler != nil {
 		log.Printf("Removing routes from %s interface", l.name)
 		l.routeHandler.Del()
 	}
+	l.unpinServerRoutes()
+
+	l.restoreIPv6()
 
 	if !cfg.DisableDNS {
 		if l.resolvHandler != nil {
 			log.Printf("Restoring DNS settings")
-			l.resolvHandler.Restore()
+			switch {
+			case l.linkDNS:
+				l.revertLinkDNS(cfg)
+			case cfg.DNSMethod == "resolvconf" || l.resolvDirect:
+				l.restoreResolvBackup()
+			default:
+				l.resolvHandler.Restore()
+				l.restoreResolvBackup()
+			}
 		}
 	}
 
@@ -388,4 +863,7 @@ func (l *vpnLink) RestoreConfig(cfg *config.Config) {
 			}
 		}
 	}
+
+	// macOS may drop the default route together with the tunnel routes
+	l.restoreDefaultRoutes()
 }
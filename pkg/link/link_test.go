@@ -0,0 +1,63 @@
+package link
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/kayrus/gof5/pkg/config"
+
+	"github.com/IBM/netaddr"
+)
+
+func TestGlobalAddressFamilies(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		addrs []string
+		v4    bool
+		v6    bool
+	}{
+		{"loopback only", []string{"127.0.0.1/8", "::1/128"}, false, false},
+		{"link-local only", []string{"169.254.1.2/16", "fe80::1/64"}, false, false},
+		{"IPv4 with link-local IPv6", []string{"127.0.0.1/8", "192.0.2.1/24", "fe80::1/64"}, true, false},
+		{"IPv6 only", []string{"::1/128", "2001:db8::1/64"}, false, true},
+		{"both", []string{"10.0.0.1/8", "2001:db8::1/64"}, true, true},
+	} {
+		var addrs []net.Addr
+		for _, v := range tc.addrs {
+			ip, ipNet, err := net.ParseCIDR(v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ipNet.IP = ip
+			addrs = append(addrs, ipNet)
+		}
+		if v4, v6 := globalAddressFamilies(addrs); v4 != tc.v4 || v6 != tc.v6 {
+			t.Errorf("%s: unexpected v4=%t, v6=%t", tc.name, v4, v6)
+		}
+	}
+}
+
+func TestBuildRoutesExclude(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		routes  []string
+		exclude []string
+		want    []string
+	}{
+		{"more specific F5 route", []string{"10.1.2.0/24", "10.2.0.0/16"}, []string{"10.1.0.0/16"}, []string{"10.2.0.0/16"}},
+		{"less specific F5 route", []string{"10.0.0.0/8"}, []string{"10.128.0.0/9"}, []string{"10.0.0.0/9"}},
+	} {
+		routes := new(netaddr.IPSet)
+		for _, v := range parseNets(t, tc.routes...) {
+			routes.InsertNet(v)
+		}
+		cfg := &config.Config{
+			Routes:        routes,
+			ExcludeRoutes: parseNets(t, tc.exclude...),
+		}
+		if got := netStrings((&vpnLink{}).buildRoutes(cfg)); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+//go:build !windows
+// +build !windows
+
+package link
+
+import (
+	"log"
+	"runtime"
+
+	"github.com/kayrus/gof5/pkg/config"
+)
+
+// routePriority returns the metric of the installed routes
+func routePriority(cfg *config.Config) int {
+	return cfg.RouteMetric
+}
+
+func setInterfaceMetric(_ string, cfg *config.Config) error {
+	if cfg.RouteMetric > 0 && runtime.GOOS != "linux" {
+		log.Printf("Warning: routeMetric is not supported in %s, ignoring", runtime.GOOS)
+	}
+	return nil
+}
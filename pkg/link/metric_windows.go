@@ -0,0 +1,54 @@
+package link
+
+import (
+	"fmt"
+	"log"
+	"net"
+
+	"github.com/kayrus/gof5/pkg/config"
+
+	"golang.org/x/sys/windows"
+	"golang.zx2c4.com/wireguard/windows/tunnel/winipcfg"
+)
+
+// routePriority returns the metric of the installed routes, Windows adds the
+// interface metric to the route metric, therefore the metric is set on the
+// interface instead
+func routePriority(_ *config.Config) int {
+	return 0
+}
+
+// setInterfaceMetric sets the metric of the tunnel interface
+func setInterfaceMetric(name string, cfg *config.Config) error {
+	if cfg.RouteMetric == 0 {
+		return nil
+	}
+
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return err
+	}
+	luid, err := winipcfg.LUIDFromIndex(uint32(iface.Index))
+	if err != nil {
+		return fmt.Errorf("failed to detect %s interface LUID: %s", name, err)
+	}
+
+	families := []winipcfg.AddressFamily{windows.AF_INET}
+	if cfg.IPv6 {
+		families = append(families, windows.AF_INET6)
+	}
+	for _, family := range families {
+		ipif, err := luid.IPInterface(family)
+		if err != nil {
+			return fmt.Errorf("failed to get %s interface settings: %s", name, err)
+		}
+		ipif.UseAutomaticMetric = false
+		ipif.Metric = uint32(cfg.RouteMetric)
+		if err := ipif.Set(); err != nil {
+			return fmt.Errorf("failed to set %s interface metric: %s", name, err)
+		}
+	}
+	log.Printf("Set %d metric on %s interface", cfg.RouteMetric, name)
+
+	return nil
+}
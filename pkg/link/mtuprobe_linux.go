@@ -0,0 +1,364 @@
+//go:build linux
+// +build linux
+
+package link
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"time"
+
+	"github.com/kayrus/gof5/pkg/config"
+	"github.com/kayrus/gof5/pkg/util"
+
+	"github.com/pion/dtls/v2"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// the smallest probed packet, every IPv4 host must accept it
+	mtuProbeMin      = 576
+	mtuProbeTimeout  = time.Second
+	mtuProbeAttempts = 2
+
+	icmpEchoReply   = 0
+	icmpUnreachable = 3
+	icmpFragNeeded  = 4
+	icmpEcho        = 8
+
+	// the F5 tunnel overhead of every packet: the IPv4 header, the TCP header
+	// with timestamps, the TLS 1.2 AES-GCM record and the F5 frame header
+	tlsOverhead = 20 + 32 + 29 + 5
+	// the IPv4 header, the UDP header, the DTLS 1.2 AES-GCM record and the F5
+	// frame header
+	dtlsOverhead = 20 + 8 + 29 + 5
+)
+
+// mtuProber sends the ICMP echo requests with the DF bit to the F5 server
+// over the physical interface, the socket is opened in the host network
+// namespace and the netlink handle in the tunnel one while the privileges are
+// not dropped yet
+type mtuProber struct {
+	fd    int
+	nl    *netlink.Handle
+	dst   *unix.SockaddrInet4
+	id    uint16
+	seq   uint16
+	buf   []byte
+	rbuf  []byte
+	debug bool
+}
+
+// startMTUProbe estimates the path MTU to the F5 server in background, logs
+// a recommendation or lowers the interface MTU with the autoMTU option
+func (l *vpnLink) startMTUProbe(cfg *config.Config) {
+	if !cfg.MTUProbe && !cfg.AutoMTU {
+		return
+	}
+	server := l.serverIPv4Addr()
+	if server == nil {
+		log.Printf("Failed to start the MTU probe: the F5 server has no IPv4 address")
+		return
+	}
+	p, err := l.newMTUProber(cfg, server)
+	if err != nil {
+		log.Printf("Failed to start the MTU probe: %s", err)
+		return
+	}
+	p.debug = cfg.Debug
+	go l.probeMTU(cfg, p, server)
+}
+
+// serverIPv4Addr returns the IPv4 address of the connected F5 server, the
+// first resolved one otherwise
+func (l *vpnLink) serverIPv4Addr() net.IP {
+	if c, ok := l.HTTPConn.(interface{ RemoteAddr() net.Addr }); ok {
+		var ip net.IP
+		switch v := c.RemoteAddr().(type) {
+		case *net.TCPAddr:
+			ip = v.IP
+		case *net.UDPAddr:
+			ip = v.IP
+		}
+		if ip.To4() != nil {
+			return ip.To4()
+		}
+	}
+	for _, ip := range l.serverIPs {
+		if ip.To4() != nil {
+			return ip.To4()
+		}
+	}
+	return nil
+}
+
+// tunnelOverhead returns the bytes, which the F5 tunnel adds to every packet
+func (l *vpnLink) tunnelOverhead() int {
+	if _, ok := l.HTTPConn.(*dtls.Conn); ok {
+		return dtlsOverhead
+	}
+	return tlsOverhead
+}
+
+func (l *vpnLink) newMTUProber(cfg *config.Config, server net.IP) (*mtuProber, error) {
+	// the current thread may be in the tunnel network namespace, a new
+	// goroutine never runs on the locked thread and stays in the host one
+	type result struct {
+		fd  int
+		err error
+	}
+	ch := make(chan result)
+	go func() {
+		fd, err := openICMPSocket(cfg.SourceInterface)
+		ch <- result{fd, err}
+	}()
+	res := <-ch
+	if res.err != nil {
+		return nil, res.err
+	}
+
+	var nl *netlink.Handle
+	var err error
+	if l.netns != nil {
+		nl, err = netlink.NewHandleAt(l.netns.handle)
+	} else {
+		nl, err = netlink.NewHandle()
+	}
+	if err != nil {
+		unix.Close(res.fd)
+		return nil, fmt.Errorf("failed to open netlink handle: %s", err)
+	}
+
+	p := &mtuProber{
+		fd:  res.fd,
+		nl:  nl,
+		dst: &unix.SockaddrInet4{},
+		id:  uint16(os.Getpid()),
+	}
+	copy(p.dst.Addr[:], server)
+	return p, nil
+}
+
+// openICMPSocket opens the raw ICMP socket with the DF bit, bound to the
+// source interface, when it is configured
+func openICMPSocket(iface string) (int, error) {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.IPPROTO_ICMP)
+	if err != nil {
+		return -1, fmt.Errorf("failed to open ICMP socket: %s", err)
+	}
+	// don't fragment and ignore the cached path MTU
+	if err = unix.SetsockoptInt(fd, unix.IPPROTO_IP, unix.IP_MTU_DISCOVER, unix.IP_PMTUDISC_PROBE); err != nil {
+		unix.Close(fd)
+		return -1, fmt.Errorf("failed to set the DF bit: %s", err)
+	}
+	if iface != "" {
+		if err = unix.BindToDevice(fd, iface); err != nil {
+			unix.Close(fd)
+			return -1, fmt.Errorf("failed to bind ICMP socket to %s interface: %s", iface, err)
+		}
+	}
+	return fd, nil
+}
+
+func (p *mtuProber) close() {
+	unix.Close(p.fd)
+	p.nl.Close()
+}
+
+func (l *vpnLink) probeMTU(cfg *config.Config, p *mtuProber, server net.IP) {
+	defer p.close()
+
+	iface, err := p.nl.LinkByName(l.name)
+	if err != nil {
+		log.Printf("Failed to detect %s interface: %s", l.name, err)
+		return
+	}
+	mtu := iface.Attrs().MTU
+	if mtu < mtuProbeMin {
+		return
+	}
+	// the interface MTU plus the tunnel overhead is the outer packet size,
+	// which must pass to the F5 server
+	overhead := l.tunnelOverhead()
+	outer := mtu + overhead
+	p.buf = make([]byte, outer)
+	// the echo reply has the request size
+	p.rbuf = make([]byte, outer+60)
+	log.Printf("Probing the path MTU to %s F5 server", server)
+
+	ok, hint, err := p.probe(outer)
+	if err != nil {
+		log.Printf("Failed to probe the path MTU: %s", err)
+		return
+	}
+	if ok {
+		log.Printf("Path MTU to %s fits the %d interface MTU", server, mtu)
+		return
+	}
+
+	// lo is the largest size, which passed, hi is the smallest one, which
+	// failed
+	lo, hi := mtuProbeMin, outer
+	if hint > lo && hint < hi {
+		// the next hop reported its MTU
+		hi = hint + 1
+	}
+	if ok, _, err = p.probe(lo); err != nil || !ok {
+		log.Printf("No ICMP echo replies from %s, the path MTU cannot be detected", server)
+		return
+	}
+	for hi-lo > 1 {
+		select {
+		case <-l.TunDown:
+			return
+		default:
+		}
+		mid := (lo + hi) / 2
+		ok, hint, err = p.probe(mid)
+		if err != nil {
+			log.Printf("Failed to probe the path MTU: %s", err)
+			return
+		}
+		switch {
+		case ok:
+			lo = mid
+		case hint > lo && hint < mid:
+			hi = hint + 1
+		default:
+			hi = mid
+		}
+	}
+
+	// the largest tunnel MTU, which fits the path MTU
+	tunMTU := lo - overhead
+	if tunMTU < mtuProbeMin {
+		tunMTU = mtuProbeMin
+	}
+	if !cfg.AutoMTU {
+		log.Printf("Path MTU to %s is %d, the tunnel overhead of %d bytes exceeds the %d interface MTU, large packets may hang; set the \"mtu: %d\" or \"autoMTU: true\" option", server, lo, overhead, mtu, tunMTU)
+		return
+	}
+
+	l.Lock()
+	defer l.Unlock()
+	if l.restored {
+		return
+	}
+	// the privileges may be dropped already
+	util.Privileged(func() {
+		err = p.nl.LinkSetMTU(iface, tunMTU)
+	})
+	if err != nil {
+		log.Printf("Failed to set %d MTU on %s interface: %s", tunMTU, l.name, err)
+		return
+	}
+	log.Printf("Path MTU to %s is %d, lowered %s interface MTU from %d to %d", server, lo, l.name, mtu, tunMTU)
+}
+
+// probe sends the ICMP echo requests of the IP packet size and reports,
+// whether the reply was received; the MTU is returned, when the ICMP
+// "fragmentation needed" error reports it
+func (p *mtuProber) probe(size int) (bool, int, error) {
+	for i := 0; i < mtuProbeAttempts; i++ {
+		p.seq++
+		// the kernel prepends the 20 bytes IP header
+		req := p.buf[:size-20]
+		for j := range req {
+			req[j] = 0
+		}
+		req[0] = icmpEcho
+		binary.BigEndian.PutUint16(req[4:], p.id)
+		binary.BigEndian.PutUint16(req[6:], p.seq)
+		binary.BigEndian.PutUint16(req[2:], ^checksum(req, 0))
+
+		if err := unix.Sendto(p.fd, req, 0, p.dst); err != nil {
+			if err == unix.EMSGSIZE {
+				return false, 0, nil
+			}
+			return false, 0, fmt.Errorf("failed to send ICMP echo: %s", err)
+		}
+
+		ok, hint, err := p.wait()
+		if err != nil {
+			return false, 0, err
+		}
+		if ok || hint > 0 {
+			if p.debug {
+				util.DebugLog.Printf("MTU probe of %d bytes: reply %t, next hop MTU %d", size, ok, hint)
+			}
+			return ok, hint, nil
+		}
+	}
+	if p.debug {
+		util.DebugLog.Printf("MTU probe of %d bytes: no reply", size)
+	}
+	return false, 0, nil
+}
+
+// wait reads the ICMP packets until the reply to the last request is received
+// or the timeout expires
+func (p *mtuProber) wait() (bool, int, error) {
+	deadline := time.Now().Add(mtuProbeTimeout)
+	for {
+		left := time.Until(deadline)
+		if left <= 0 {
+			return false, 0, nil
+		}
+		tv := unix.NsecToTimeval(left.Nanoseconds())
+		if err := unix.SetsockoptTimeval(p.fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+			return false, 0, fmt.Errorf("failed to set ICMP socket timeout: %s", err)
+		}
+		n, _, err := unix.Recvfrom(p.fd, p.rbuf, 0)
+		switch err {
+		case nil:
+		case unix.EAGAIN, unix.EINTR:
+			continue
+		default:
+			return false, 0, fmt.Errorf("failed to read ICMP reply: %s", err)
+		}
+		if ok, hint, match := parseICMPReply(p.rbuf[:n], p.id, p.seq); match {
+			return ok, hint, nil
+		}
+	}
+}
+
+// parseICMPReply parses the IPv4 packet, received by the raw ICMP socket;
+// match is true for the echo reply or the "fragmentation needed" error of the
+// request with the id and seq, hint is the reported next hop MTU
+func parseICMPReply(pkt []byte, id, seq uint16) (ok bool, hint int, match bool) {
+	if len(pkt) < 20 {
+		return
+	}
+	ihl := int(pkt[0]&0x0f) * 4
+	if len(pkt) < ihl+8 {
+		return
+	}
+	icmp := pkt[ihl:]
+	switch icmp[0] {
+	case icmpEchoReply:
+		if binary.BigEndian.Uint16(icmp[4:]) == id && binary.BigEndian.Uint16(icmp[6:]) == seq {
+			return true, 0, true
+		}
+	case icmpUnreachable:
+		if icmp[1] != icmpFragNeeded {
+			return
+		}
+		// the original IP header and 8 bytes of its payload
+		orig := icmp[8:]
+		if len(orig) < 20 {
+			return
+		}
+		oihl := int(orig[0]&0x0f) * 4
+		if len(orig) < oihl+8 || orig[oihl] != icmpEcho {
+			return
+		}
+		if binary.BigEndian.Uint16(orig[oihl+4:]) == id && binary.BigEndian.Uint16(orig[oihl+6:]) == seq {
+			return false, int(binary.BigEndian.Uint16(icmp[6:])), true
+		}
+	}
+	return
+}
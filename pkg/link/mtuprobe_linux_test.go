@@ -0,0 +1,38 @@
+//go:build linux
+// +build linux
+
+package link
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestParseICMPReply(t *testing.T) {
+	reply := make([]byte, 28)
+	reply[0] = 0x45
+	reply[20] = icmpEchoReply
+	binary.BigEndian.PutUint16(reply[24:], 7)
+	binary.BigEndian.PutUint16(reply[26:], 3)
+	if ok, _, match := parseICMPReply(reply, 7, 3); !ok || !match {
+		t.Errorf("echo reply is not matched")
+	}
+	if _, _, match := parseICMPReply(reply, 7, 4); match {
+		t.Errorf("echo reply of another request is matched")
+	}
+
+	// fragmentation needed with the original IP header and ICMP echo
+	unreach := make([]byte, 56)
+	unreach[0] = 0x45
+	unreach[20] = icmpUnreachable
+	unreach[21] = icmpFragNeeded
+	binary.BigEndian.PutUint16(unreach[26:], 1400)
+	unreach[28] = 0x45
+	unreach[48] = icmpEcho
+	binary.BigEndian.PutUint16(unreach[52:], 7)
+	binary.BigEndian.PutUint16(unreach[54:], 3)
+	ok, hint, match := parseICMPReply(unreach, 7, 3)
+	if ok || !match || hint != 1400 {
+		t.Errorf("unexpected fragmentation needed result: ok=%t, hint=%d, match=%t", ok, hint, match)
+	}
+}
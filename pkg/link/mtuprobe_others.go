@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package link
+
+import (
+	"github.com/kayrus/gof5/pkg/config"
+)
+
+// startMTUProbe is a no-op, the mtuProbe and autoMTU options are validated
+func (l *vpnLink) startMTUProbe(_ *config.Config) {}
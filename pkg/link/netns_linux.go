@@ -0,0 +1,176 @@
+//go:build linux
+// +build linux
+
+package link
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/kayrus/gof5/pkg/config"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+// netNS is the named network namespace, the tunnel interface is moved into
+type netNS struct {
+	name   string
+	handle netns.NsHandle
+	// resolv.conf, used by "ip netns exec" in the namespace
+	resolvPath string
+	// resolvBackup is set, when resolvPath existed before gof5 started
+	resolvBackup string
+	resolvSet    bool
+}
+
+// moveToNetNS moves the tunnel interface into the configured network
+// namespace and assigns the VPN IP address there, the F5 connection stays in
+// the current namespace
+func (l *vpnLink) moveToNetNS(cfg *config.Config) error {
+	h, err := netns.GetFromName(cfg.NetNS)
+	if err != nil {
+		return fmt.Errorf("failed to open %s network namespace: %s", cfg.NetNS, err)
+	}
+
+	iface, err := netlink.LinkByName(l.name)
+	if err != nil {
+		h.Close()
+		return fmt.Errorf("failed to detect %s interface: %s", l.name, err)
+	}
+	if err = netlink.LinkSetNsFd(iface, int(h)); err != nil {
+		h.Close()
+		return fmt.Errorf("failed to move %s interface into %s network namespace: %s", l.name, cfg.NetNS, err)
+	}
+	l.netns = &netNS{
+		name:       cfg.NetNS,
+		handle:     h,
+		resolvPath: filepath.Join("/etc/netns", cfg.NetNS, "resolv.conf"),
+	}
+	log.Printf("Moved %s interface into %s network namespace", l.name, cfg.NetNS)
+
+	leave, err := l.enterNetNS()
+	if err != nil {
+		return err
+	}
+	defer leave()
+
+	// the addresses are flushed, when the interface changes the namespace
+	iface, err = netlink.LinkByName(l.name)
+	if err != nil {
+		return fmt.Errorf("failed to detect %s interface in %s network namespace: %s", l.name, cfg.NetNS, err)
+	}
+	addr := &netlink.Addr{
+		IPNet: &net.IPNet{
+			IP:   l.localIPv4,
+			Mask: net.CIDRMask(32, 32),
+		},
+		Peer: &net.IPNet{
+			IP:   l.serverIPv4,
+			Mask: net.CIDRMask(32, 32),
+		},
+	}
+	if err = netlink.AddrReplace(iface, addr); err != nil {
+		return fmt.Errorf("failed to set %s address on %s interface: %s", l.localIPv4, l.name, err)
+	}
+	if err = netlink.LinkSetUp(iface); err != nil {
+		return fmt.Errorf("failed to set %s interface up: %s", l.name, err)
+	}
+
+	return nil
+}
+
+// enterNetNS switches the current OS thread into the tunnel network
+// namespace, the returned func switches it back; it is a no-op, when the
+// namespace is not configured
+func (l *vpnLink) enterNetNS() (func(), error) {
+	if l.netns == nil {
+		return func() {}, nil
+	}
+
+	runtime.LockOSThread()
+	orig, err := netns.Get()
+	if err != nil {
+		runtime.UnlockOSThread()
+		return nil, fmt.Errorf("failed to get the current network namespace: %s", err)
+	}
+	if err = netns.Set(l.netns.handle); err != nil {
+		orig.Close()
+		runtime.UnlockOSThread()
+		return nil, fmt.Errorf("failed to enter %s network namespace: %s", l.netns.name, err)
+	}
+
+	return func() {
+		if err := netns.Set(orig); err != nil {
+			// don't return the thread into the pool with a wrong namespace,
+			// the locked thread is terminated with the goroutine
+			log.Printf("Failed to restore the network namespace: %s", err)
+			orig.Close()
+			return
+		}
+		orig.Close()
+		runtime.UnlockOSThread()
+	}, nil
+}
+
+// setNetNSDNS writes the VPN DNS servers into the namespace resolv.conf, which
+// "ip netns exec" bind mounts over /etc/resolv.conf
+func (l *vpnLink) setNetNSDNS(cfg *config.Config) error {
+	if cfg.DisableDNS {
+		return nil
+	}
+
+	dir := filepath.Dir(l.netns.resolvPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s directory: %s", dir, err)
+	}
+	if _, err := os.Stat(l.netns.resolvPath); err == nil {
+		backup := l.netns.resolvPath + ".gof5"
+		if err := os.Rename(l.netns.resolvPath, backup); err != nil {
+			return fmt.Errorf("failed to backup %s: %s", l.netns.resolvPath, err)
+		}
+		l.netns.resolvBackup = backup
+	}
+	l.netns.resolvSet = true
+
+	var b strings.Builder
+	b.WriteString("# created by gof5\n")
+	for _, v := range DNSServers(cfg) {
+		fmt.Fprintf(&b, "nameserver %s\n", v)
+	}
+	if v := cfg.F5Config.Object.DNSSuffix; len(v) > 0 {
+		fmt.Fprintf(&b, "search %s\n", strings.Join(v, " "))
+	}
+	if err := os.WriteFile(l.netns.resolvPath, []byte(b.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %s", l.netns.resolvPath, err)
+	}
+	log.Printf("Set DNS servers in %s", l.netns.resolvPath)
+
+	return nil
+}
+
+// restoreNetNS restores the namespace resolv.conf and closes the namespace
+func (l *vpnLink) restoreNetNS() {
+	if l.netns == nil {
+		return
+	}
+	defer l.netns.handle.Close()
+
+	if !l.netns.resolvSet {
+		return
+	}
+	if l.netns.resolvBackup != "" {
+		if err := os.Rename(l.netns.resolvBackup, l.netns.resolvPath); err != nil {
+			log.Printf("Failed to restore %s: %s", l.netns.resolvPath, err)
+		}
+		return
+	}
+	if err := os.Remove(l.netns.resolvPath); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to remove %s: %s", l.netns.resolvPath, err)
+	}
+}
@@ -0,0 +1,28 @@
+//go:build !linux
+// +build !linux
+
+package link
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/kayrus/gof5/pkg/config"
+)
+
+type netNS struct{}
+
+func (l *vpnLink) moveToNetNS(_ *config.Config) error {
+	return fmt.Errorf("netns option is not supported in %s", runtime.GOOS)
+}
+
+func (l *vpnLink) enterNetNS() (func(), error) {
+	return func() {}, nil
+}
+
+func (l *vpnLink) setNetNSDNS(_ *config.Config) error {
+	return nil
+}
+
+func (l *vpnLink) restoreNetNS() {
+}
@@ -0,0 +1,207 @@
+package link
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+
+	"github.com/kayrus/gof5/pkg/config"
+
+	"gvisor.dev/gvisor/pkg/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv6"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+)
+
+const netstackNIC = 1
+
+// netstack terminates the tunnel in a userspace TCP/IP stack and exposes it
+// as a local proxy, routes and DNS of the host are not altered
+type netstack struct {
+	stack    *stack.Stack
+	ep       *channel.Endpoint
+	ctx      context.Context
+	cancel   context.CancelFunc
+	proxy    *proxyServer
+	resolver *net.Resolver
+}
+
+func (l *vpnLink) createNetstack(cfg *config.Config) error {
+	mtu := int(l.mtuInt)
+	if cfg.MTU > 0 {
+		log.Printf("Overriding %d MTU with %d", mtu, cfg.MTU)
+		mtu = cfg.MTU
+	}
+
+	if mtu > l.bufSize {
+		return fmt.Errorf("MTU exceeds the %d buffer limit", l.bufSize)
+	}
+
+	log.Printf("Using netstack to terminate the tunnel")
+	n := &netstack{
+		stack: stack.New(stack.Options{
+			NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol, ipv6.NewProtocol},
+			TransportProtocols: []stack.TransportProtocolFactory{tcp.NewProtocol, udp.NewProtocol},
+			HandleLocal:        true,
+		}),
+		ep: channel.New(1024, uint32(mtu), ""),
+	}
+	n.ctx, n.cancel = context.WithCancel(context.Background())
+
+	if err := n.stack.CreateNIC(netstackNIC, n.ep); err != nil {
+		n.Close()
+		return fmt.Errorf("failed to create a netstack NIC: %s", err)
+	}
+
+	if err := n.addAddress(l.localIPv4, ipv4.ProtocolNumber, header.IPv4EmptySubnet); err != nil {
+		n.Close()
+		return err
+	}
+
+	if cfg.TunnelIPv6() && l.localIPv6 != nil {
+		if err := n.addAddress(l.localIPv6, ipv6.ProtocolNumber, header.IPv6EmptySubnet); err != nil {
+			n.Close()
+			return err
+		}
+	}
+
+	if dnsServer := proxyDNS(cfg); dnsServer != "" {
+		log.Printf("Resolving proxy hostnames using %s DNS server", dnsServer)
+		n.resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return n.dial(ctx, network, dnsServer)
+			},
+		}
+	} else {
+		n.resolver = net.DefaultResolver
+	}
+
+	var err error
+	n.proxy, err = newProxyServer(cfg.SOCKSListen, n.dial, cfg.DialTimeout, l.debug)
+	if err != nil {
+		n.Close()
+		return err
+	}
+
+	l.name = "netstack"
+	l.iface = n
+
+	// can now process the traffic
+	close(l.tunUp)
+
+	return nil
+}
+
+func (n *netstack) addAddress(ip net.IP, proto tcpip.NetworkProtocolNumber, subnet tcpip.Subnet) error {
+	if v := ip.To4(); v != nil {
+		ip = v
+	}
+	addr := tcpip.ProtocolAddress{
+		Protocol:          proto,
+		AddressWithPrefix: tcpip.AddrFromSlice(ip).WithPrefix(),
+	}
+	if err := n.stack.AddProtocolAddress(netstackNIC, addr, stack.AddressProperties{}); err != nil {
+		return fmt.Errorf("failed to add %s address to netstack: %s", ip, err)
+	}
+	n.stack.AddRoute(tcpip.Route{Destination: subnet, NIC: netstackNIC})
+	return nil
+}
+
+// Read returns an outgoing IP packet
+func (n *netstack) Read(buf []byte) (int, error) {
+	pkt := n.ep.ReadContext(n.ctx)
+	if pkt == nil {
+		return 0, io.EOF
+	}
+	defer pkt.DecRef()
+
+	view := pkt.ToView()
+	defer view.Release()
+
+	return view.Read(buf)
+}
+
+// Write injects an incoming IP packet
+func (n *netstack) Write(buf []byte) (int, error) {
+	if len(buf) == 0 {
+		return 0, nil
+	}
+
+	var proto tcpip.NetworkProtocolNumber
+	switch buf[0] >> 4 {
+	case 4:
+		proto = ipv4.ProtocolNumber
+	case 6:
+		proto = ipv6.ProtocolNumber
+	default:
+		return 0, fmt.Errorf("unknown IP version %d", buf[0]>>4)
+	}
+
+	pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+		Payload: buffer.MakeWithData(buf),
+	})
+	n.ep.InjectInbound(proto, pkt)
+	pkt.DecRef()
+
+	return len(buf), nil
+}
+
+func (n *netstack) Close() error {
+	n.cancel()
+	if n.proxy != nil {
+		n.proxy.Close()
+	}
+	n.ep.Close()
+	n.stack.Close()
+	return nil
+}
+
+// dial connects to the address through the tunnel
+func (n *netstack) dial(ctx context.Context, network, address string) (net.Conn, error) {
+	host, p, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(p, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %q port: %s", p, err)
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		ips, err := n.resolver.LookupIP(ctx, "ip", host)
+		if err != nil {
+			return nil, err
+		}
+		ip = ips[0]
+	}
+
+	proto := ipv6.ProtocolNumber
+	if v := ip.To4(); v != nil {
+		ip = v
+		proto = ipv4.ProtocolNumber
+	}
+	addr := tcpip.FullAddress{
+		NIC:  netstackNIC,
+		Addr: tcpip.AddrFromSlice(ip),
+		Port: uint16(port),
+	}
+
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		return gonet.DialContextTCP(ctx, n.stack, addr, proto)
+	case "udp", "udp4", "udp6":
+		return gonet.DialUDP(n.stack, nil, &addr, proto)
+	}
+	return nil, fmt.Errorf("%q network is not supported", network)
+}
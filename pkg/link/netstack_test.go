@@ -0,0 +1,130 @@
+package link
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/kayrus/gof5/pkg/config"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+
+	"golang.org/x/net/proxy"
+)
+
+func TestNetstackProxy(t *testing.T) {
+	l := &vpnLink{
+		mtuInt:    1400,
+		bufSize:   bufferSize,
+		localIPv4: net.IPv4(10, 0, 0, 2),
+		tunUp:     make(chan struct{}),
+	}
+	cfg := &config.Config{
+		SOCKSListen: "127.0.0.1:0",
+		DialTimeout: 5 * time.Second,
+		F5Config:    &config.Favorite{},
+	}
+	if err := l.createNetstack(cfg); err != nil {
+		t.Fatal(err)
+	}
+	local := l.iface.(*netstack)
+	defer local.Close()
+
+	// the remote side of the tunnel
+	l2 := &vpnLink{
+		mtuInt:    1400,
+		bufSize:   bufferSize,
+		localIPv4: net.IPv4(10, 0, 0, 1),
+		tunUp:     make(chan struct{}),
+	}
+	if err := l2.createNetstack(cfg); err != nil {
+		t.Fatal(err)
+	}
+	remote := l2.iface.(*netstack)
+	defer remote.Close()
+
+	// forward packets between both stacks
+	pipe := func(src, dst io.ReadWriter) {
+		buf := make([]byte, bufferSize)
+		for {
+			n, err := src.Read(buf)
+			if err != nil {
+				return
+			}
+			dst.Write(buf[:n])
+		}
+	}
+	go pipe(local, remote)
+	go pipe(remote, local)
+
+	ln, err := gonet.ListenTCP(remote.stack, tcpip.FullAddress{
+		NIC:  netstackNIC,
+		Addr: tcpip.AddrFromSlice(net.IPv4(10, 0, 0, 1).To4()),
+		Port: 80,
+	}, ipv4.ProtocolNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				io.Copy(c, c)
+			}()
+		}
+	}()
+
+	addr := local.proxy.listener.Addr().String()
+	dialer, err := proxy.SOCKS5("tcp", addr, nil, proxy.Direct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := dialer.Dial("tcp", "10.0.0.1:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	testEcho(t, c)
+
+	c, err = net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	fmt.Fprintf(c, "CONNECT 10.0.0.1:80 HTTP/1.1\r\nHost: 10.0.0.1:80\r\n\r\n")
+	r := bufio.NewReader(c)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected HTTP CONNECT status: %s", resp.Status)
+	}
+	testEcho(t, c)
+}
+
+func testEcho(t *testing.T, c net.Conn) {
+	msg := []byte("hello")
+	if _, err := c.Write(msg); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, len(msg))
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadFull(c, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != string(msg) {
+		t.Errorf("expected %q, got %q", msg, buf)
+	}
+}
@@ -0,0 +1,78 @@
+package link
+
+import (
+	"log"
+	"os/exec"
+	"strings"
+
+	"github.com/kayrus/gof5/pkg/config"
+)
+
+// setNMCLI sets the VPN DNS servers and the routing domains on the tunnel
+// link using resolvectl, when NetworkManager works on top of systemd-resolved,
+// and nmcli otherwise
+func (l *vpnLink) setNMCLI(cfg *config.Config, domains []string) error {
+	servers := DNSServers(cfg)
+
+	if _, err := exec.LookPath("resolvectl"); err == nil {
+		args := []string{"dns", l.name}
+		for _, v := range servers {
+			args = append(args, v.String())
+		}
+		if err := runCmd("resolvectl", args...); err != nil {
+			return err
+		}
+
+		if len(domains) == 0 {
+			domains = []string{"."}
+		}
+		args = []string{"domain", l.name}
+		for _, d := range domains {
+			// don't trim the global domain
+			if d != "." {
+				d = strings.TrimLeft(d, ".")
+			}
+			args = append(args, "~"+d)
+		}
+		args = append(args, cfg.F5Config.Object.DNSSuffix...)
+		return runCmd("resolvectl", args...)
+	}
+
+	// nmcli doesn't support routing domains, the VPN DNS servers get a higher
+	// priority instead
+	var dns4, dns6 []string
+	for _, v := range servers {
+		if v.To4() != nil {
+			dns4 = append(dns4, v.String())
+		} else {
+			dns6 = append(dns6, v.String())
+		}
+	}
+	search := append(append([]string{}, cfg.F5Config.Object.DNSSuffix...), domains...)
+	args := []string{"device", "modify", l.name,
+		"ipv4.dns", strings.Join(dns4, ","),
+		"ipv4.dns-search", strings.Join(search, ","),
+		"ipv4.dns-priority", "-50",
+	}
+	if len(dns6) > 0 {
+		args = append(args,
+			"ipv6.dns", strings.Join(dns6, ","),
+			"ipv6.dns-priority", "-50",
+		)
+	}
+	return runCmd("nmcli", args...)
+}
+
+// revertNMCLI drops the tunnel link DNS settings, thus NetworkManager regains
+// control
+func (l *vpnLink) revertNMCLI() {
+	var err error
+	if _, e := exec.LookPath("resolvectl"); e == nil {
+		err = runCmd("resolvectl", "revert", l.name)
+	} else {
+		err = runCmd("nmcli", "device", "reapply", l.name)
+	}
+	if err != nil {
+		log.Printf("Failed to revert the tunnel link DNS settings: %s", err)
+	}
+}
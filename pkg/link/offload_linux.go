@@ -0,0 +1,323 @@
+//go:build linux
+// +build linux
+
+package link
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// linux/if_tun.h offload flags, not exported by x/sys
+	tunFCsum = 0x01
+	tunFTSO4 = 0x02
+	tunFTSO6 = 0x04
+
+	// struct virtio_net_hdr, prepended to every packet with IFF_VNET_HDR
+	virtioNetHdrLen      = 10
+	virtioNetHdrNeedCsum = 0x01
+	virtioGSONone        = 0
+	virtioGSOTCPv4       = 1
+	virtioGSOTCPv6       = 4
+	virtioGSOECN         = 0x80
+
+	// max size of a GSO packet, passed by the kernel
+	offloadReadSize = virtioNetHdrLen + 65535
+)
+
+// offload flags, requested from the kernel in the preference order
+var offloadFlags = []int{
+	tunFCsum | tunFTSO4 | tunFTSO6,
+	tunFCsum | tunFTSO4,
+	tunFCsum,
+}
+
+// virtioNetHdr is the packet metadata, the fields are in the host byte order
+type virtioNetHdr struct {
+	flags      uint8
+	gsoType    uint8
+	hdrLen     uint16
+	gsoSize    uint16
+	csumStart  uint16
+	csumOffset uint16
+}
+
+func (h *virtioNetHdr) decode(b []byte) {
+	h.flags = b[0]
+	h.gsoType = b[1]
+	h.hdrLen = binary.NativeEndian.Uint16(b[2:])
+	h.gsoSize = binary.NativeEndian.Uint16(b[4:])
+	h.csumStart = binary.NativeEndian.Uint16(b[6:])
+	h.csumOffset = binary.NativeEndian.Uint16(b[8:])
+}
+
+// offloadTun is the tun interface with the IFF_VNET_HDR flag, the kernel
+// passes TCP packets up to 64 KiB with a partial checksum, which are split
+// into the MTU sized packets on the following reads. The written packets are
+// not coalesced. Every Read and Write transfers a single IP packet, like
+// tun.Tunnel.
+type offloadTun struct {
+	file io.ReadWriteCloser
+
+	rbuf []byte
+	// the pending GSO packet, returned by segments
+	pkt    []byte
+	hdr    virtioNetHdr
+	hdrLen int
+	off    int
+	seg    int
+
+	wmu  sync.Mutex
+	wbuf []byte
+}
+
+func newOffloadTun(file io.ReadWriteCloser, bufSize int) *offloadTun {
+	return &offloadTun{
+		file: file,
+		rbuf: make([]byte, offloadReadSize),
+		// zero header: no offload for the written packets
+		wbuf: make([]byte, virtioNetHdrLen, virtioNetHdrLen+bufSize),
+	}
+}
+
+// openOffloadTun creates the tun interface with the packet offload and
+// configures its address, MTU and link state, like tun.OpenTunDevice does.
+// It returns the interface name and the negotiated offload flags.
+func openOffloadTun(name string, mtu, bufSize int, local, gw *net.IPNet) (*offloadTun, string, string, error) {
+	fd, err := unix.Open("/dev/net/tun", unix.O_RDWR|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("failed to open /dev/net/tun: %s", err)
+	}
+
+	ifr, err := unix.NewIfreq(name)
+	if err != nil {
+		unix.Close(fd)
+		return nil, "", "", fmt.Errorf("invalid %q interface name: %s", name, err)
+	}
+	ifr.SetUint16(unix.IFF_TUN | unix.IFF_NO_PI | unix.IFF_VNET_HDR)
+	if err = unix.IoctlIfreq(fd, unix.TUNSETIFF, ifr); err != nil {
+		unix.Close(fd)
+		return nil, "", "", fmt.Errorf("failed to create an interface: %s", err)
+	}
+	name = ifr.Name()
+
+	var flags int
+	for _, v := range offloadFlags {
+		if err = unix.IoctlSetInt(fd, unix.TUNSETOFFLOAD, v); err == nil {
+			flags = v
+			break
+		}
+	}
+	if err != nil {
+		unix.Close(fd)
+		return nil, "", "", fmt.Errorf("failed to enable the offload on %s interface: %s", name, err)
+	}
+
+	// the non-blocking descriptor is handled by the runtime poller, thus Close
+	// interrupts the pending Read
+	if err = unix.SetNonblock(fd, true); err != nil {
+		unix.Close(fd)
+		return nil, "", "", fmt.Errorf("failed to set %s interface non-blocking: %s", name, err)
+	}
+	file := os.NewFile(uintptr(fd), "/dev/net/tun")
+
+	if err = setOffloadInterface(name, mtu, local, gw); err != nil {
+		file.Close()
+		return nil, "", "", err
+	}
+
+	return newOffloadTun(file, bufSize), name, offloadString(flags), nil
+}
+
+func setOffloadInterface(name string, mtu int, local, gw *net.IPNet) error {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return fmt.Errorf("failed to detect %s interface: %s", name, err)
+	}
+	if err = netlink.LinkSetMTU(link, mtu); err != nil {
+		return fmt.Errorf("failed to set %d MTU on %s interface: %s", mtu, name, err)
+	}
+	addr := &netlink.Addr{
+		IPNet: local,
+		Peer:  gw,
+	}
+	if err = netlink.AddrAdd(link, addr); err != nil {
+		return fmt.Errorf("failed to set peer address on %s interface: %s", name, err)
+	}
+	if err = netlink.LinkSetUp(link); err != nil {
+		return fmt.Errorf("failed to set %s interface up: %s", name, err)
+	}
+	return nil
+}
+
+func offloadString(flags int) string {
+	var v []string
+	if flags&tunFCsum != 0 {
+		v = append(v, "csum")
+	}
+	if flags&tunFTSO4 != 0 {
+		v = append(v, "tso4")
+	}
+	if flags&tunFTSO6 != 0 {
+		v = append(v, "tso6")
+	}
+	return strings.Join(v, ", ")
+}
+
+func (t *offloadTun) Read(b []byte) (int, error) {
+	for {
+		if t.pkt != nil {
+			return t.nextSegment(b)
+		}
+
+		n, err := t.file.Read(t.rbuf)
+		if err != nil {
+			return 0, err
+		}
+		if n < virtioNetHdrLen {
+			return 0, fmt.Errorf("short offload packet: %d bytes", n)
+		}
+		t.hdr.decode(t.rbuf)
+		pkt := t.rbuf[virtioNetHdrLen:n]
+
+		switch t.hdr.gsoType &^ virtioGSOECN {
+		case virtioGSONone:
+			if t.hdr.flags&virtioNetHdrNeedCsum != 0 {
+				if !completeChecksum(pkt, int(t.hdr.csumStart), int(t.hdr.csumOffset)) {
+					continue
+				}
+			}
+			if len(b) < len(pkt) {
+				return 0, io.ErrShortBuffer
+			}
+			return copy(b, pkt), nil
+		case virtioGSOTCPv4, virtioGSOTCPv6:
+			start := int(t.hdr.csumStart)
+			if t.hdr.gsoSize == 0 || start+20 > len(pkt) {
+				continue
+			}
+			t.hdrLen = start + int(pkt[start+12]>>4)*4
+			if t.hdrLen > len(pkt) {
+				continue
+			}
+			t.pkt = pkt
+			t.off = t.hdrLen
+			t.seg = 0
+		default:
+			// UDP offload is not requested
+			continue
+		}
+	}
+}
+
+// nextSegment writes the next MTU sized segment of the pending GSO packet
+// into b and fixes the IP and TCP headers
+func (t *offloadTun) nextSegment(b []byte) (int, error) {
+	end := t.off + int(t.hdr.gsoSize)
+	if end > len(t.pkt) {
+		end = len(t.pkt)
+	}
+	n := t.hdrLen + end - t.off
+	if len(b) < n {
+		t.pkt = nil
+		return 0, io.ErrShortBuffer
+	}
+	copy(b, t.pkt[:t.hdrLen])
+	copy(b[t.hdrLen:], t.pkt[t.off:end])
+	seg := b[:n]
+	first, last := t.seg == 0, end == len(t.pkt)
+
+	start := int(t.hdr.csumStart)
+	ip, tcp := seg[:start], seg[start:]
+	var pseudo uint64
+	if ip[0]>>4 == 4 {
+		binary.BigEndian.PutUint16(ip[2:], uint16(n))
+		binary.BigEndian.PutUint16(ip[4:], binary.BigEndian.Uint16(t.pkt[4:])+uint16(t.seg))
+		ip[10], ip[11] = 0, 0
+		binary.BigEndian.PutUint16(ip[10:], ^checksum(ip, 0))
+		pseudo = checksumAdd(ip[12:20], uint64(unix.IPPROTO_TCP)+uint64(len(tcp)))
+	} else {
+		binary.BigEndian.PutUint16(ip[4:], uint16(n-40))
+		pseudo = checksumAdd(ip[8:40], uint64(unix.IPPROTO_TCP)+uint64(len(tcp)))
+	}
+
+	seq := binary.BigEndian.Uint32(t.pkt[start+4:]) + uint32(t.off-t.hdrLen)
+	binary.BigEndian.PutUint32(tcp[4:], seq)
+	if !last {
+		// FIN and PSH
+		tcp[13] &^= 0x09
+	}
+	if !first {
+		// CWR
+		tcp[13] &^= 0x80
+	}
+	tcp[16], tcp[17] = 0, 0
+	binary.BigEndian.PutUint16(tcp[16:], ^checksum(tcp, pseudo))
+
+	t.off = end
+	t.seg++
+	if last {
+		t.pkt = nil
+	}
+	return n, nil
+}
+
+func (t *offloadTun) Write(b []byte) (int, error) {
+	t.wmu.Lock()
+	defer t.wmu.Unlock()
+
+	t.wbuf = append(t.wbuf[:virtioNetHdrLen], b...)
+	n, err := t.file.Write(t.wbuf)
+	if n -= virtioNetHdrLen; n < 0 {
+		n = 0
+	}
+	return n, err
+}
+
+func (t *offloadTun) Close() error {
+	return t.file.Close()
+}
+
+// completeChecksum computes the checksum of a packet with the partial
+// checksum, the checksum field contains the pseudo header sum; false is
+// returned for the malformed packet
+func completeChecksum(pkt []byte, start, offset int) bool {
+	at := start + offset
+	if at+2 > len(pkt) {
+		return false
+	}
+	initial := uint64(binary.BigEndian.Uint16(pkt[at:]))
+	pkt[at], pkt[at+1] = 0, 0
+	binary.BigEndian.PutUint16(pkt[at:], ^checksum(pkt[start:], initial))
+	return true
+}
+
+// checksumAdd adds the 16-bit words of b to the sum
+func checksumAdd(b []byte, sum uint64) uint64 {
+	for len(b) >= 2 {
+		sum += uint64(binary.BigEndian.Uint16(b))
+		b = b[2:]
+	}
+	if len(b) == 1 {
+		sum += uint64(b[0]) << 8
+	}
+	return sum
+}
+
+// checksum returns the folded one's complement sum of b
+func checksum(b []byte, initial uint64) uint16 {
+	sum := checksumAdd(b, initial)
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+	return uint16(sum)
+}
@@ -0,0 +1,199 @@
+//go:build linux
+// +build linux
+
+package link
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	testMSS  = 1400
+	testSegs = 45
+)
+
+// memFile returns the same packet on every read
+type memFile struct {
+	pkt []byte
+}
+
+func (f *memFile) Read(b []byte) (int, error) {
+	return copy(b, f.pkt), nil
+}
+
+func (f *memFile) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (f *memFile) Close() error {
+	return nil
+}
+
+// tsoPacket returns the GSO TCP packet with the virtio header, the payload is
+// split into segs segments
+func tsoPacket(v6 bool, segs int) []byte {
+	ipLen := 20
+	if v6 {
+		ipLen = 40
+	}
+	payload := bytes.Repeat([]byte("gof5"), testMSS*segs/4)
+	pkt := make([]byte, virtioNetHdrLen+ipLen+20+len(payload))
+
+	h := pkt[:virtioNetHdrLen]
+	h[0] = virtioNetHdrNeedCsum
+	h[1] = virtioGSOTCPv4
+	if v6 {
+		h[1] = virtioGSOTCPv6
+	}
+	binary.NativeEndian.PutUint16(h[2:], uint16(ipLen+20))
+	binary.NativeEndian.PutUint16(h[4:], testMSS)
+	binary.NativeEndian.PutUint16(h[6:], uint16(ipLen))
+	binary.NativeEndian.PutUint16(h[8:], 16)
+
+	ip := pkt[virtioNetHdrLen:]
+	if v6 {
+		ip[0] = 0x60
+		ip[6] = unix.IPPROTO_TCP
+		ip[7] = 64
+		ip[8], ip[23] = 0xfd, 1
+		ip[24], ip[39] = 0xfd, 2
+	} else {
+		ip[0] = 0x45
+		binary.BigEndian.PutUint16(ip[4:], 100)
+		ip[8] = 64
+		ip[9] = unix.IPPROTO_TCP
+		copy(ip[12:], []byte{10, 0, 0, 1, 10, 0, 0, 2})
+	}
+
+	tcp := ip[ipLen:]
+	binary.BigEndian.PutUint16(tcp[0:], 40000)
+	binary.BigEndian.PutUint16(tcp[2:], 443)
+	binary.BigEndian.PutUint32(tcp[4:], 1000)
+	tcp[12] = 5 << 4
+	// PSH, ACK, FIN
+	tcp[13] = 0x08 | 0x10 | 0x01
+	copy(tcp[20:], payload)
+
+	return pkt
+}
+
+func TestOffloadSegment(t *testing.T) {
+	for _, v6 := range []bool{false, true} {
+		pkt := tsoPacket(v6, 3)
+		tun := newOffloadTun(&memFile{pkt: pkt}, bufferSize)
+		ipLen := 20
+		if v6 {
+			ipLen = 40
+		}
+
+		buf := make([]byte, bufferSize)
+		for i := 0; i < 3; i++ {
+			n, err := tun.Read(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != ipLen+20+testMSS {
+				t.Fatalf("v6=%t segment %d: unexpected %d size", v6, i, n)
+			}
+			ip, tcp := buf[:ipLen], buf[ipLen:n]
+
+			var pseudo uint64
+			if v6 {
+				if v := binary.BigEndian.Uint16(ip[4:]); int(v) != n-40 {
+					t.Errorf("segment %d: unexpected %d payload length", i, v)
+				}
+				pseudo = checksumAdd(ip[8:40], uint64(unix.IPPROTO_TCP)+uint64(len(tcp)))
+			} else {
+				if v := binary.BigEndian.Uint16(ip[2:]); int(v) != n {
+					t.Errorf("segment %d: unexpected %d total length", i, v)
+				}
+				if v := binary.BigEndian.Uint16(ip[4:]); v != 100+uint16(i) {
+					t.Errorf("segment %d: unexpected %d IP ID", i, v)
+				}
+				if v := checksum(ip, 0); v != 0xffff {
+					t.Errorf("segment %d: invalid IP checksum", i)
+				}
+				pseudo = checksumAdd(ip[12:20], uint64(unix.IPPROTO_TCP)+uint64(len(tcp)))
+			}
+			if v := checksum(tcp, pseudo); v != 0xffff {
+				t.Errorf("v6=%t segment %d: invalid TCP checksum", v6, i)
+			}
+			if v := binary.BigEndian.Uint32(tcp[4:]); v != 1000+uint32(i*testMSS) {
+				t.Errorf("v6=%t segment %d: unexpected %d sequence", v6, i, v)
+			}
+			if fin := tcp[13]&0x09 != 0; fin != (i == 2) {
+				t.Errorf("v6=%t segment %d: unexpected 0x%x flags", v6, i, tcp[13])
+			}
+		}
+		if tun.pkt != nil {
+			t.Errorf("v6=%t: unexpected pending segments", v6)
+		}
+	}
+}
+
+// benchmarkTunRead reads testSegs TCP packets per op from a SOCK_SEQPACKET
+// socket pair, which keeps the packet boundaries like a tun device; with the
+// offload a single GSO packet is read and segmented
+func benchmarkTunRead(b *testing.B, offload bool) {
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_SEQPACKET|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	r := os.NewFile(uintptr(fds[0]), "reader")
+	w := os.NewFile(uintptr(fds[1]), "writer")
+	defer r.Close()
+
+	gso := tsoPacket(false, testSegs)
+	var msgs [][]byte
+	if offload {
+		msgs = [][]byte{gso}
+	} else {
+		src := newOffloadTun(&memFile{pkt: gso}, bufferSize)
+		for i := 0; i < testSegs; i++ {
+			buf := make([]byte, bufferSize)
+			n, err := src.Read(buf)
+			if err != nil {
+				b.Fatal(err)
+			}
+			msgs = append(msgs, buf[:n])
+		}
+	}
+
+	go func() {
+		defer w.Close()
+		for i := 0; i < b.N; i++ {
+			for _, v := range msgs {
+				if _, err := w.Write(v); err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	var iface io.Reader = r
+	if offload {
+		iface = newOffloadTun(r, bufferSize)
+	}
+	buf := make([]byte, bufferSize)
+
+	b.SetBytes(testSegs * testMSS)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < testSegs; j++ {
+			if _, err := iface.Read(buf); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkTunRead(b *testing.B) {
+	b.Run("plain", func(b *testing.B) { benchmarkTunRead(b, false) })
+	b.Run("offload", func(b *testing.B) { benchmarkTunRead(b, true) })
+}
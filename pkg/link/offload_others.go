@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package link
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"runtime"
+)
+
+func openOffloadTun(_ string, _, _ int, _, _ *net.IPNet) (io.ReadWriteCloser, string, string, error) {
+	return nil, "", "", fmt.Errorf("offload option is not supported in %s", runtime.GOOS)
+}
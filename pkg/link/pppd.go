@@ -48,7 +48,7 @@ func (l *vpnLink) decodeHDLC(buf []byte, src string) {
 
 // http->tun
 func (l *vpnLink) PppdHTTPToTun(pppd io.WriteCloser) {
-	buf := make([]byte, bufferSize)
+	buf := make([]byte, l.bufSize)
 	for {
 		select {
 		case <-l.TunDown:
@@ -63,15 +63,16 @@ func (l *vpnLink) PppdHTTPToTun(pppd io.WriteCloser) {
 			}
 			if l.debug {
 				l.decodeHDLC(buf[:rn], "http")
-				log.Printf("Read %d bytes from http:\n%s", rn, hex.Dump(buf[:rn]))
+				util.DebugLog.Printf("Read %d bytes from http:\n%s", rn, hex.Dump(buf[:rn]))
 			}
 			wn, err := pppd.Write(buf[:rn])
 			if err != nil {
 				l.ErrChan <- fmt.Errorf("fatal write to pppd: %s", err)
 				return
 			}
+			l.countIn(wn, false)
 			if l.debug {
-				log.Printf("Sent %d bytes to pppd", wn)
+				util.DebugLog.Printf("Sent %d bytes to pppd", wn)
 			}
 		}
 	}
@@ -79,7 +80,7 @@ func (l *vpnLink) PppdHTTPToTun(pppd io.WriteCloser) {
 
 // tun->http
 func (l *vpnLink) PppdTunToHTTP(pppd io.ReadCloser) {
-	buf := make([]byte, bufferSize)
+	buf := make([]byte, l.bufSize)
 	for {
 		select {
 		case <-l.TunDown:
@@ -93,7 +94,7 @@ func (l *vpnLink) PppdTunToHTTP(pppd io.ReadCloser) {
 				return
 			}
 			if l.debug {
-				log.Printf("Read %d bytes from pppd:\n%s", rn, hex.Dump(buf[:rn]))
+				util.DebugLog.Printf("Read %d bytes from pppd:\n%s", rn, hex.Dump(buf[:rn]))
 				l.decodeHDLC(buf[:rn], "pppd")
 			}
 			wn, err := l.HTTPConn.Write(buf[:rn])
@@ -101,8 +102,9 @@ func (l *vpnLink) PppdTunToHTTP(pppd io.ReadCloser) {
 				l.ErrChan <- fmt.Errorf("fatal write to http: %s", err)
 				return
 			}
+			l.countOut(wn, false)
 			if l.debug {
-				log.Printf("Sent %d bytes to http", wn)
+				util.DebugLog.Printf("Sent %d bytes to http", wn)
 			}
 		}
 	}
@@ -130,7 +132,8 @@ func (l *vpnLink) PppdLogParser(stderr io.Reader) {
 	scanner := bufio.NewScanner(stderr)
 	for scanner.Scan() {
 		str := scanner.Text()
-		if strings.Contains(str, "Using interface") {
+		// "Renamed interface ppp0 to gof5-0" is logged, when ifname is set
+		if strings.Contains(str, "Using interface") || strings.Contains(str, "Renamed interface") {
 			if v := strings.FieldsFunc(str, util.SplitFunc); len(v) > 0 {
 				l.name = v[len(v)-1]
 			}
@@ -138,7 +141,7 @@ func (l *vpnLink) PppdLogParser(stderr io.Reader) {
 		if strings.Contains(str, "remote IP address") {
 			close(l.pppUp)
 		}
-		colorlog.Print(color.HiGreenString(str))
+		util.ColorLog.Print(color.HiGreenString(str))
 	}
 }
 
@@ -169,6 +172,6 @@ func (l *vpnLink) PppLogParser() {
 		if strings.Contains(str, "IPCP: myaddr") {
 			close(l.pppUp)
 		}
-		colorlog.Print(color.HiGreenString(str))
+		util.ColorLog.Print(color.HiGreenString(str))
 	}
 }
@@ -0,0 +1,270 @@
+package link
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kayrus/gof5/pkg/config"
+	"github.com/kayrus/gof5/pkg/util"
+)
+
+const (
+	socksVersion  = 5
+	socksConnect  = 1
+	socksNoAuth   = 0
+	socksNoMethod = 0xff
+	socksIPv4     = 1
+	socksDomain   = 3
+	socksIPv6     = 4
+	// SOCKS5 reply codes
+	socksSucceeded          = 0
+	socksHostUnreachable    = 4
+	socksCmdUnsupported     = 7
+	socksAddrTypeNotAllowed = 8
+)
+
+type dialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// proxyServer serves SOCKS5 and HTTP CONNECT requests, the outbound
+// connections are established using the dial func
+type proxyServer struct {
+	listener net.Listener
+	dial     dialFunc
+	ctx      context.Context
+	cancel   context.CancelFunc
+	timeout  time.Duration
+	debug    bool
+}
+
+func newProxyServer(addr string, dial dialFunc, timeout time.Duration, debug bool) (*proxyServer, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen proxy: %s", err)
+	}
+
+	p := &proxyServer{
+		listener: listener,
+		dial:     dial,
+		timeout:  timeout,
+		debug:    debug,
+	}
+	p.ctx, p.cancel = context.WithCancel(context.Background())
+
+	log.Printf("Serving SOCKS5 and HTTP CONNECT proxy on %s", listener.Addr())
+	go p.serve()
+
+	return p, nil
+}
+
+func (p *proxyServer) Close() error {
+	p.cancel()
+	return p.listener.Close()
+}
+
+func (p *proxyServer) serve() {
+	for {
+		c, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+		go p.handle(c)
+	}
+}
+
+func (p *proxyServer) handle(c net.Conn) {
+	defer c.Close()
+
+	r := bufio.NewReader(c)
+	v, err := r.Peek(1)
+	if err != nil {
+		return
+	}
+
+	var address string
+	if v[0] == socksVersion {
+		address = p.socksRequest(c, r)
+	} else {
+		address = p.httpRequest(c, r)
+	}
+	if address == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(p.ctx, p.timeout)
+	dst, err := p.dial(ctx, "tcp", address)
+	cancel()
+	if err != nil {
+		if p.debug {
+			util.DebugLog.Printf("Proxy failed to connect to %s: %s", address, err)
+		}
+		if v[0] == socksVersion {
+			socksReply(c, socksHostUnreachable)
+		} else {
+			fmt.Fprintf(c, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+		}
+		return
+	}
+	defer dst.Close()
+
+	if p.debug {
+		util.DebugLog.Printf("Proxy connected %s to %s", c.RemoteAddr(), address)
+	}
+	if v[0] == socksVersion {
+		err = socksReply(c, socksSucceeded)
+	} else {
+		_, err = fmt.Fprintf(c, "HTTP/1.1 200 Connection established\r\n\r\n")
+	}
+	if err != nil {
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		// the reader may contain already buffered data
+		io.Copy(dst, r)
+		dst.Close()
+		close(done)
+	}()
+	io.Copy(c, dst)
+	c.Close()
+	<-done
+}
+
+// socksRequest negotiates a SOCKS5 CONNECT request without authentication,
+// see RFC 1928, and returns the requested address
+func (p *proxyServer) socksRequest(c net.Conn, r io.Reader) string {
+	buf := make([]byte, 256)
+	// version and methods
+	if _, err := io.ReadFull(r, buf[:2]); err != nil {
+		return ""
+	}
+	methods := buf[:buf[1]]
+	if _, err := io.ReadFull(r, methods); err != nil {
+		return ""
+	}
+	if !contains(methods, socksNoAuth) {
+		c.Write([]byte{socksVersion, socksNoMethod})
+		return ""
+	}
+	if _, err := c.Write([]byte{socksVersion, socksNoAuth}); err != nil {
+		return ""
+	}
+
+	// version, command, reserved and address type
+	if _, err := io.ReadFull(r, buf[:4]); err != nil || buf[0] != socksVersion {
+		return ""
+	}
+	if buf[1] != socksConnect {
+		socksReply(c, socksCmdUnsupported)
+		return ""
+	}
+
+	var host string
+	switch buf[3] {
+	case socksIPv4:
+		if _, err := io.ReadFull(r, buf[:net.IPv4len]); err != nil {
+			return ""
+		}
+		host = net.IP(buf[:net.IPv4len]).String()
+	case socksIPv6:
+		if _, err := io.ReadFull(r, buf[:net.IPv6len]); err != nil {
+			return ""
+		}
+		host = net.IP(buf[:net.IPv6len]).String()
+	case socksDomain:
+		if _, err := io.ReadFull(r, buf[:1]); err != nil {
+			return ""
+		}
+		name := buf[:buf[0]]
+		if _, err := io.ReadFull(r, name); err != nil {
+			return ""
+		}
+		host = string(name)
+	default:
+		socksReply(c, socksAddrTypeNotAllowed)
+		return ""
+	}
+	if _, err := io.ReadFull(r, buf[:2]); err != nil {
+		return ""
+	}
+
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(buf[:2]))))
+}
+
+// httpRequest reads an HTTP CONNECT request and returns the requested address
+func (p *proxyServer) httpRequest(c net.Conn, r *bufio.Reader) string {
+	req, err := http.ReadRequest(r)
+	if err != nil {
+		return ""
+	}
+	if req.Method != http.MethodConnect {
+		fmt.Fprintf(c, "HTTP/1.1 405 Method Not Allowed\r\nAllow: CONNECT\r\n\r\n")
+		return ""
+	}
+	return req.Host
+}
+
+func socksReply(c net.Conn, code byte) error {
+	// the bound address is not exposed
+	_, err := c.Write([]byte{socksVersion, code, 0, socksIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+func contains(v []byte, b byte) bool {
+	for _, c := range v {
+		if c == b {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyDNS returns a DNS server, used to resolve the proxy hostnames through
+// the tunnel
+func proxyDNS(cfg *config.Config) string {
+	vpnDNS := cfg.F5Config.Object.DNS
+	if len(cfg.OverrideDNS) > 0 {
+		vpnDNS = cfg.OverrideDNS
+	}
+	if len(vpnDNS) == 0 {
+		return ""
+	}
+	return net.JoinHostPort(vpnDNS[0].String(), "53")
+}
+
+// startProxy serves the proxy, which connects through the tunnel interface
+func (l *vpnLink) startProxy(cfg *config.Config) error {
+	control, err := bindToInterface(l.name)
+	if err != nil {
+		return err
+	}
+
+	dialer := &net.Dialer{
+		Timeout: cfg.DialTimeout,
+		Control: control,
+	}
+	if dnsServer := proxyDNS(cfg); dnsServer != "" {
+		log.Printf("Resolving proxy hostnames using %s DNS server", dnsServer)
+		dnsDialer := &net.Dialer{
+			Timeout: cfg.DialTimeout,
+			Control: control,
+		}
+		dialer.Resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return dnsDialer.DialContext(ctx, network, dnsServer)
+			},
+		}
+	}
+
+	l.proxy, err = newProxyServer(cfg.ProxyListen, dialer.DialContext, cfg.DialTimeout, l.debug)
+	return err
+}
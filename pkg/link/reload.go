@@ -0,0 +1,238 @@
+package link
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"reflect"
+
+	"github.com/kayrus/gof5/pkg/config"
+	"github.com/kayrus/gof5/pkg/dns"
+	"github.com/kayrus/gof5/pkg/util"
+
+	"github.com/kayrus/tuncfg/route"
+)
+
+// Reload applies routes and DNS zones changes from the newCfg to the
+// established link, other changed options require a reconnect
+func (l *vpnLink) Reload(cfg, newCfg *config.Config) (err error) {
+	util.Privileged(func() { err = l.reload(cfg, newCfg) })
+	return err
+}
+
+func (l *vpnLink) reload(cfg, newCfg *config.Config) error {
+	l.Lock()
+	defer l.Unlock()
+
+	if !manageRoutes(cfg) {
+		return fmt.Errorf("the routes are not managed")
+	}
+	if l.restored || l.routeHandler == nil {
+		return fmt.Errorf("the link is not established")
+	}
+
+	if cfg.InstanceListenDNS && newCfg.InstanceListenDNS {
+		// the address may be shifted on a collision with another instance
+		newCfg.ListenDNS = cfg.ListenDNS
+	}
+
+	for _, v := range []struct {
+		name     string
+		old, new interface{}
+	}{
+		{"driver", cfg.Driver, newCfg.Driver},
+		{"listenDNS", cfg.ListenDNS, newCfg.ListenDNS},
+		{"listenDNSPort", cfg.ListenDNSPort, newCfg.ListenDNSPort},
+		{"overrideDNS", cfg.OverrideDNS, newCfg.OverrideDNS},
+		{"overrideDNSSuffix", cfg.OverrideDNSSuffix, newCfg.OverrideDNSSuffix},
+		{"searchDomains", cfg.SearchDomains, newCfg.SearchDomains},
+		{"pppdArgs", cfg.PPPdArgs, newCfg.PPPdArgs},
+		{"pppdPath", cfg.PPPdPath, newCfg.PPPdPath},
+		{"insecureTLS", cfg.InsecureTLS, newCfg.InsecureTLS},
+		{"serverCertPins", cfg.ServerCertPins, newCfg.ServerCertPins},
+		{"pinOnly", cfg.PinOnly, newCfg.PinOnly},
+		{"caCerts", cfg.CACerts, newCfg.CACerts},
+		{"tlsMinVersion", cfg.TLSMinVersion, newCfg.TLSMinVersion},
+		{"dtls", cfg.DTLS, newCfg.DTLS},
+		{"ipv6", cfg.IPv6, newCfg.IPv6},
+		{"mtu", cfg.MTU, newCfg.MTU},
+		{"mtuProbe", cfg.MTUProbe, newCfg.MTUProbe},
+		{"autoMTU", cfg.AutoMTU, newCfg.AutoMTU},
+		{"interfaceName", cfg.InterfaceName, newCfg.InterfaceName},
+		{"socksListen", cfg.SOCKSListen, newCfg.SOCKSListen},
+		{"proxyListen", cfg.ProxyListen, newCfg.ProxyListen},
+		{"disableIPv6", cfg.DisableIPv6, newCfg.DisableIPv6},
+		{"addressFamilies", cfg.AddressFamilies, newCfg.AddressFamilies},
+		{"dnsFallback", cfg.DNSFallback, newCfg.DNSFallback},
+		{"disableDNS", cfg.DisableDNS, newCfg.DisableDNS},
+		{"dnsMethod", cfg.DNSMethod, newCfg.DNSMethod},
+		{"dnsTimeout", cfg.DNSTimeout, newCfg.DNSTimeout},
+		{"dnsAttempts", cfg.DNSAttempts, newCfg.DNSAttempts},
+		{"dnsCacheSize", cfg.DNSCacheSize, newCfg.DNSCacheSize},
+		{"dnsNegativeCacheTTL", cfg.DNSNegativeCacheTTL, newCfg.DNSNegativeCacheTTL},
+		{"tunnelBufferSize", cfg.TunnelBufferSize, newCfg.TunnelBufferSize},
+		{"tunnelQueueDepth", cfg.TunnelQueueDepth, newCfg.TunnelQueueDepth},
+		{"tunnelBatchSize", cfg.TunnelBatchSize, newCfg.TunnelBatchSize},
+		{"offload", cfg.Offload, newCfg.Offload},
+		{"routeMetric", cfg.RouteMetric, newCfg.RouteMetric},
+		{"netns", cfg.NetNS, newCfg.NetNS},
+		{"dropPrivileges", cfg.DropPrivileges, newCfg.DropPrivileges},
+		{"requestedAddress", cfg.RequestedAddress, newCfg.RequestedAddress},
+		{"reportedHostname", cfg.ReportedHostname, newCfg.ReportedHostname},
+		{"reportedOS", cfg.ReportedOS, newCfg.ReportedOS},
+		{"userAgent", cfg.UserAgent, newCfg.UserAgent},
+		{"manageRoutes", cfg.ManageRoutes, newCfg.ManageRoutes},
+		{"killSwitch", cfg.KillSwitch, newCfg.KillSwitch},
+		{"idleTimeout", cfg.IdleTimeout, newCfg.IdleTimeout},
+		{"sourceAddress", cfg.SourceAddress, newCfg.SourceAddress},
+		{"sourceInterface", cfg.SourceInterface, newCfg.SourceInterface},
+		{"rewriteResolv", cfg.RewriteResolv, newCfg.RewriteResolv},
+		{"renegotiation", cfg.Renegotiation, newCfg.Renegotiation},
+	} {
+		if !reflect.DeepEqual(v.old, v.new) {
+			log.Printf("Warning: %q option cannot be changed without a reconnect, ignoring", v.name)
+		}
+	}
+
+	l.reloadDNS(cfg, newCfg)
+
+	cfg.Routes = newCfg.Routes
+	cfg.IncludeRoutes = newCfg.IncludeRoutes
+
+	add, del := diffNets(cfg.ExcludeRoutes, newCfg.ExcludeRoutes)
+	l.unpinBypassRoutes(del)
+	cfg.ExcludeRoutes = newCfg.ExcludeRoutes
+	if err := l.reloadRoutes(cfg); err != nil {
+		return err
+	}
+	// the original routes to the added subnets are looked up, when the
+	// tunnel routes don't capture them anymore
+	l.pinBypassRoutes(cfg, add)
+
+	return nil
+}
+
+// pinBypassRoutes adds the routes to the newly excluded subnets via the
+// original gateway
+func (l *vpnLink) pinBypassRoutes(cfg *config.Config, dsts []*net.IPNet) {
+	if len(dsts) == 0 || cfg.Driver == "netstack" {
+		return
+	}
+	routes := lookupBypassRoutes(dsts)
+	pinRoutes(routes, "excluded subnet", instanceMetric(cfg))
+	l.bypassRoutes = append(l.bypassRoutes, routes...)
+}
+
+// unpinBypassRoutes removes the routes to the subnets, which are not excluded
+// anymore
+func (l *vpnLink) unpinBypassRoutes(dsts []*net.IPNet) {
+	if len(dsts) == 0 {
+		return
+	}
+	del := make(map[string]bool, len(dsts))
+	for _, v := range dsts {
+		del[v.String()] = true
+	}
+	var keep, routes []*serverRoute
+	for _, r := range l.bypassRoutes {
+		if del[r.dst.String()] {
+			routes = append(routes, r)
+		} else {
+			keep = append(keep, r)
+		}
+	}
+	unpinRoutes(routes, "excluded subnet")
+	l.bypassRoutes = keep
+}
+
+// diffNets returns the subnets, which are added to and removed from the old
+// list
+func diffNets(old, new []*net.IPNet) (add, del []*net.IPNet) {
+	current := make(map[string]bool, len(old))
+	for _, v := range old {
+		current[v.String()] = true
+	}
+	updated := make(map[string]bool, len(new))
+	for _, v := range new {
+		updated[v.String()] = true
+	}
+
+	for _, v := range new {
+		if !current[v.String()] {
+			add = append(add, v)
+		}
+	}
+	for _, v := range old {
+		if !updated[v.String()] {
+			del = append(del, v)
+		}
+	}
+	return add, del
+}
+
+func (l *vpnLink) reloadDNS(cfg, newCfg *config.Config) {
+	if reflect.DeepEqual(cfg.DNS, newCfg.DNS) || cfg.DisableDNS {
+		return
+	}
+
+	if len(cfg.DNS) == 0 || len(newCfg.DNS) == 0 {
+		// switching between the split and the full DNS modes starts or
+		// stops the DNS proxy
+		log.Printf("Warning: \"dns\" option cannot be enabled or disabled without a reconnect, ignoring")
+		return
+	}
+
+	if l.linkDNS {
+		if err := l.setLinkDNS(cfg, newCfg.DNS); err != nil {
+			log.Printf("Failed to update the tunnel link DNS domains: %s", err)
+			return
+		}
+		cfg.DNS = newCfg.DNS
+	} else {
+		dns.SetZones(cfg, newCfg.DNS)
+	}
+	log.Printf("Forwarding %q DNS requests to VPN DNS servers", cfg.DNS)
+}
+
+func (l *vpnLink) reloadRoutes(cfg *config.Config) error {
+	leave, err := l.enterNetNS()
+	if err != nil {
+		return err
+	}
+	defer leave()
+
+	routes := l.buildRoutes(cfg)
+	add, del := diffNets(l.routes, routes)
+	if len(add) == 0 && len(del) == 0 {
+		log.Printf("Routes are not changed")
+		return nil
+	}
+
+	gw := l.routeGateway()
+	if len(del) > 0 {
+		h, err := route.New(l.name, del, gw, routePriority(cfg))
+		if err != nil {
+			return err
+		}
+		log.Printf("Removing %q routes from %s interface", del, l.name)
+		h.Del()
+	}
+	if len(add) > 0 {
+		h, err := route.New(l.name, add, gw, routePriority(cfg))
+		if err != nil {
+			return err
+		}
+		log.Printf("Adding %q routes to %s interface", add, l.name)
+		h.Add()
+	}
+
+	// the handler is used to remove all routes on exit
+	h, err := route.New(l.name, routes, gw, routePriority(cfg))
+	if err != nil {
+		return err
+	}
+	l.routeHandler = h
+	l.routes = routes
+
+	return nil
+}
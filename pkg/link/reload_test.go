@@ -0,0 +1,66 @@
+package link
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func parseNets(t *testing.T, s ...string) []*net.IPNet {
+	t.Helper()
+	var nets []*net.IPNet
+	for _, v := range s {
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+func netStrings(nets []*net.IPNet) []string {
+	var s []string
+	for _, v := range nets {
+		s = append(s, v.String())
+	}
+	return s
+}
+
+func TestDiffNets(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		old, new []string
+		add, del []string
+	}{
+		{"unchanged", []string{"10.1.0.0/16"}, []string{"10.1.0.0/16"}, nil, nil},
+		{"added", nil, []string{"10.1.0.0/16"}, []string{"10.1.0.0/16"}, nil},
+		{"removed", []string{"10.1.0.0/16", "10.2.0.0/16"}, []string{"10.2.0.0/16"}, nil, []string{"10.1.0.0/16"}},
+		{"replaced", []string{"10.1.0.0/16"}, []string{"10.1.0.0/24"}, []string{"10.1.0.0/24"}, []string{"10.1.0.0/16"}},
+	} {
+		add, del := diffNets(parseNets(t, tc.old...), parseNets(t, tc.new...))
+		if got := netStrings(add); !reflect.DeepEqual(got, tc.add) {
+			t.Errorf("%s: added %q, want %q", tc.name, got, tc.add)
+		}
+		if got := netStrings(del); !reflect.DeepEqual(got, tc.del) {
+			t.Errorf("%s: removed %q, want %q", tc.name, got, tc.del)
+		}
+	}
+}
+
+func TestUnpinBypassRoutes(t *testing.T) {
+	l := &vpnLink{}
+	for _, v := range parseNets(t, "10.1.0.0/16", "10.2.0.0/16", "10.3.0.0/16") {
+		l.bypassRoutes = append(l.bypassRoutes, &serverRoute{dst: v})
+	}
+
+	l.unpinBypassRoutes(parseNets(t, "10.2.0.0/16"))
+
+	var got []string
+	for _, r := range l.bypassRoutes {
+		got = append(got, r.dst.String())
+	}
+	if want := []string{"10.1.0.0/16", "10.3.0.0/16"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q bypass routes, want %q", got, want)
+	}
+}
@@ -0,0 +1,306 @@
+//go:build !windows && !darwin
+// +build !windows,!darwin
+
+package link
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/kayrus/gof5/pkg/config"
+
+	"github.com/kayrus/tuncfg/resolv"
+)
+
+const (
+	resolvBackupName = "resolv.conf.bak"
+	// resolv.conf files, generated by systemd-resolved
+	resolvedRunDir = "/run/systemd/resolve/"
+	// resolv.conf files in the runtime directory are generated by the DNS
+	// managers, e.g. resolvconf or NetworkManager, which overwrite the changes
+	generatedResolvDir = "/run/"
+)
+
+// resolvBackupPath returns the resolv.conf backup path, the instances keep
+// separate backups
+func resolvBackupPath(cfg *config.Config) string {
+	if cfg.Instance != "" {
+		return filepath.Join(cfg.CookiePath, "resolv.conf."+cfg.Instance+".bak")
+	}
+	return filepath.Join(cfg.CookiePath, resolvBackupName)
+}
+
+// backupResolvConf copies the original resolv.conf into the config directory,
+// the backup is used to restore DNS after an unclean exit, e.g. SIGKILL
+func (l *vpnLink) backupResolvConf(cfg *config.Config) error {
+	if !l.rewritesResolvConf(cfg) {
+		return nil
+	}
+
+	raw, err := os.ReadFile(resolv.ResolvPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %s", resolv.ResolvPath, err)
+	}
+
+	backup := resolvBackupPath(cfg)
+	if err := writeFileAtomic(backup, raw, 0600); err != nil {
+		return fmt.Errorf("failed to backup %s: %s", resolv.ResolvPath, err)
+	}
+	l.resolvBackup = backup
+
+	return nil
+}
+
+// rewritesResolvConf reports, whether the DNS settings are written into
+// resolv.conf instead of a DNS manager
+func (l *vpnLink) rewritesResolvConf(cfg *config.Config) bool {
+	return cfg.DNSMethod == "resolvconf" || !(l.resolvHandler.IsResolve() || l.resolvHandler.IsNetworkManager() || l.resolvHandler.IsShill())
+}
+
+// checkResolvConf verifies, that resolv.conf can be rewritten. The symlink is
+// kept and the DNS settings are written into its target, unless the target is
+// generated by a DNS manager. The immutable file is reported with a hint
+// instead of an opaque permission error.
+func (l *vpnLink) checkResolvConf(cfg *config.Config) error {
+	if !l.rewritesResolvConf(cfg) {
+		return nil
+	}
+
+	path, err := resolvWritePath(resolv.ResolvPath)
+	if err != nil {
+		return err
+	}
+	if path != resolv.ResolvPath {
+		if err = checkResolvTarget(resolv.ResolvPath, path); err != nil {
+			return err
+		}
+		log.Printf("%s is a symlink, writing DNS settings to %s", resolv.ResolvPath, path)
+		// the resolv handler replaces the symlink with a regular file
+		l.resolvDirect = true
+	}
+
+	immutable, err := isImmutable(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		log.Printf("Failed to check %s attributes: %s", path, err)
+		return nil
+	}
+	if immutable {
+		return fmt.Errorf("%s is immutable, DNS settings cannot be changed: run \"%s %s\" or set the \"disableDNS: true\" option", path, clearImmutableCmd, path)
+	}
+	return nil
+}
+
+// checkResolvTarget refuses to write into the generated symlink target, the
+// DNS manager would silently overwrite the DNS settings, and the target is
+// left untouched
+func checkResolvTarget(link, target string) error {
+	switch {
+	case strings.HasPrefix(target, resolvedRunDir):
+		return fmt.Errorf("%s is a symlink to %s, which is managed by systemd-resolved, use the \"dnsMethod: resolved\" option", link, target)
+	case strings.HasPrefix(target, generatedResolvDir):
+		return fmt.Errorf("%s is a symlink to %s, which is generated by a DNS manager, e.g. resolvconf, and would overwrite the DNS settings; use the \"dnsMethod: resolved\" or \"dnsMethod: nmcli\" option, or set the \"disableDNS: true\" option and configure DNS in the upScript", link, target)
+	}
+	return nil
+}
+
+// resolvWritePath returns the path or the target path of the symlink, thus
+// the resolv.conf symlink is kept intact, when the file is replaced
+func resolvWritePath(path string) (string, error) {
+	info, err := os.Lstat(path)
+	if err != nil || info.Mode()&os.ModeSymlink == 0 {
+		return path, nil
+	}
+	v, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		target, _ := os.Readlink(path)
+		return "", fmt.Errorf("%s is a broken symlink to %s: %s", path, target, err)
+	}
+	return v, nil
+}
+
+// restoreResolvBackup ensures, that resolv.conf matches the backup and removes
+// the backup
+func (l *vpnLink) restoreResolvBackup() {
+	if l.resolvBackup == "" {
+		// there was no resolv.conf before
+		if pid, ok := resolvOwner(); ok && pid == os.Getpid() {
+			if err := os.Remove(resolv.ResolvPath); err != nil {
+				log.Printf("Failed to remove %s: %s", resolv.ResolvPath, err)
+			}
+		}
+		return
+	}
+	if err := restoreResolvConf(l.resolvBackup); err != nil {
+		log.Printf("Failed to restore %s: %s", resolv.ResolvPath, err)
+	}
+}
+
+// writeResolvConf writes resolv.conf atomically, bypassing the DNS managers,
+// the original options are preserved
+func (l *vpnLink) writeResolvConf(servers []net.IP, suffixes []string) error {
+	buf := bytes.NewBufferString(fmt.Sprintf("# created by gof5 (PID %d)\n", os.Getpid()))
+	for _, v := range servers {
+		fmt.Fprintf(buf, "nameserver %s\n", v)
+	}
+	if len(suffixes) > 0 {
+		fmt.Fprintf(buf, "search %s\n", strings.Join(suffixes, " "))
+	}
+	if opts := l.resolvHandler.GetOriginalOptions(); len(opts) > 0 {
+		fmt.Fprintf(buf, "options %s\n", strings.Join(opts, " "))
+	}
+
+	path, err := resolvWritePath(resolv.ResolvPath)
+	if err != nil {
+		return err
+	}
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if err := writeFileAtomic(path, buf.Bytes(), mode); err != nil {
+		return fmt.Errorf("failed to write %s: %s", path, err)
+	}
+	return nil
+}
+
+// checkResolvBackup restores the leftover resolv.conf backup of a previous
+// unclean exit, the original settings must be restored before they are parsed
+func checkResolvBackup(cfg *config.Config) {
+	backup := resolvBackupPath(cfg)
+	if _, err := os.Stat(backup); err != nil {
+		return
+	}
+
+	pid, ok := resolvOwner()
+	if !ok {
+		// resolv.conf was changed after the unclean exit, e.g. by DHCP
+		log.Printf("Removing outdated %s backup of a previous unclean exit", backup)
+		if err := os.Remove(backup); err != nil {
+			log.Printf("Failed to remove %s: %s", backup, err)
+		}
+		return
+	}
+
+	if pid != os.Getpid() && processExists(pid) {
+		log.Printf("Warning: %s is managed by a running gof5 process (PID %d)", resolv.ResolvPath, pid)
+		return
+	}
+
+	log.Printf("Restoring %s from %s backup of a previous unclean exit", resolv.ResolvPath, backup)
+	if err := restoreResolvConf(backup); err != nil {
+		log.Printf("Failed to restore %s: %s", resolv.ResolvPath, err)
+	}
+}
+
+// RestoreResolvConf restores resolv.conf from the backup, left by a gof5
+// process, which was killed
+func RestoreResolvConf(cfg *config.Config) error {
+	backup := resolvBackupPath(cfg)
+	if _, err := os.Stat(backup); err != nil {
+		return fmt.Errorf("no %s backup found: %s", resolv.ResolvPath, err)
+	}
+
+	if pid, ok := resolvOwner(); ok && processExists(pid) {
+		return fmt.Errorf("%s is managed by a running gof5 process (PID %d), stop it first", resolv.ResolvPath, pid)
+	}
+
+	return restoreResolvConf(backup)
+}
+
+func restoreResolvConf(backup string) error {
+	raw, err := os.ReadFile(backup)
+	if err != nil {
+		return err
+	}
+
+	// the resolv handler may have already restored the file
+	if v, err := os.ReadFile(resolv.ResolvPath); err != nil || !bytes.Equal(v, raw) {
+		path, err := resolvWritePath(resolv.ResolvPath)
+		if err != nil {
+			return err
+		}
+		mode := os.FileMode(0644)
+		if info, err := os.Stat(path); err == nil {
+			mode = info.Mode().Perm()
+		}
+		if err := writeFileAtomic(path, raw, mode); err != nil {
+			return err
+		}
+	}
+
+	return os.Remove(backup)
+}
+
+// resolvOwner returns the PID of the gof5 process, which created resolv.conf
+func resolvOwner() (int, bool) {
+	f, err := os.Open(resolv.ResolvPath)
+	if err != nil {
+		return 0, false
+	}
+	defer f.Close()
+
+	line, err := bufio.NewReader(f).ReadString('\n')
+	if err != nil {
+		return 0, false
+	}
+
+	var pid int
+	if _, err := fmt.Sscanf(line, "# created by gof5 (PID %d)", &pid); err != nil {
+		return 0, false
+	}
+	return pid, true
+}
+
+func processExists(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// writeFileAtomic writes the data into a temporary file in the same directory
+// and renames it, thus readers never see a partially written file
+func writeFileAtomic(path string, data []byte, mode os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		err = os.Chmod(tmp, mode)
+	}
+	if info, e := os.Stat(path); err == nil && e == nil {
+		// keep the owner, the file is created by the invoking user, when the
+		// privileges are dropped
+		if st, ok := info.Sys().(*syscall.Stat_t); ok && int(st.Uid) != os.Getuid() {
+			err = os.Chown(tmp, int(st.Uid), int(st.Gid))
+		}
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
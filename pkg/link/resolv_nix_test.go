@@ -0,0 +1,57 @@
+//go:build !windows && !darwin
+// +build !windows,!darwin
+
+package link
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolvWritePath(t *testing.T) {
+	dir := t.TempDir()
+	resolvPath := filepath.Join(dir, "resolv.conf")
+
+	if path, err := resolvWritePath(resolvPath); err != nil || path != resolvPath {
+		t.Fatalf("missing file: unexpected %q path: %v", path, err)
+	}
+
+	target := filepath.Join(dir, "target.conf")
+	if err := os.Symlink(target, resolvPath); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := resolvWritePath(resolvPath); err == nil {
+		t.Fatal("broken symlink: expected an error")
+	}
+
+	if err := os.WriteFile(target, []byte("nameserver 192.0.2.1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	path, err := resolvWritePath(resolvPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = writeFileAtomic(path, []byte("nameserver 192.0.2.2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Lstat(resolvPath); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Fatalf("symlink was replaced: %v", err)
+	}
+	if v, _ := os.ReadFile(resolvPath); string(v) != "nameserver 192.0.2.2\n" {
+		t.Errorf("unexpected %q content", v)
+	}
+}
+
+func TestCheckResolvTarget(t *testing.T) {
+	for target, refused := range map[string]bool{
+		"/etc/resolv.conf.static":               false,
+		"/run/systemd/resolve/stub-resolv.conf": true,
+		"/run/resolvconf/resolv.conf":           true,
+		"/run/NetworkManager/resolv.conf":       true,
+	} {
+		if err := checkResolvTarget("/etc/resolv.conf", target); (err != nil) != refused {
+			t.Errorf("%s: unexpected %v error", target, err)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+//go:build windows || darwin
+// +build windows darwin
+
+package link
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+
+	"github.com/kayrus/gof5/pkg/config"
+)
+
+func (l *vpnLink) checkResolvConf(_ *config.Config) error {
+	return nil
+}
+
+func (l *vpnLink) backupResolvConf(_ *config.Config) error {
+	return nil
+}
+
+func (l *vpnLink) writeResolvConf(_ []net.IP, _ []string) error {
+	return fmt.Errorf("resolv.conf is not used in %s", runtime.GOOS)
+}
+
+func (l *vpnLink) restoreResolvBackup() {
+}
+
+func checkResolvBackup(_ *config.Config) {
+}
+
+// RestoreResolvConf restores resolv.conf from the backup, left by a gof5
+// process, which was killed
+func RestoreResolvConf(_ *config.Config) error {
+	return fmt.Errorf("resolv.conf is not used in %s", runtime.GOOS)
+}
@@ -0,0 +1,166 @@
+package link
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+
+	"github.com/kayrus/gof5/pkg/config"
+
+	"github.com/godbus/dbus/v5"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	resolvedName    = "org.freedesktop.resolve1"
+	resolvedPath    = "/org/freedesktop/resolve1"
+	resolvedManager = resolvedName + ".Manager"
+)
+
+type resolvedDNS struct {
+	Family  int32
+	Address []byte
+}
+
+type resolvedDomain struct {
+	Domain      string
+	RoutingOnly bool
+}
+
+func systemBus() (*dbus.Conn, error) {
+	conn, err := dbus.SystemBusPrivate()
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to dbus: %s", err)
+	}
+	if err = conn.Auth(nil); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("cannot auth against dbus: %s", err)
+	}
+	if err = conn.Hello(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("cannot establish a connection with dbus: %s", err)
+	}
+	return conn, nil
+}
+
+// resolvedRunning returns true, when systemd-resolved is present on the
+// system bus
+func resolvedRunning() bool {
+	conn, err := systemBus()
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+
+	var owned bool
+	if err := conn.BusObject().Call("org.freedesktop.DBus.NameHasOwner", 0, resolvedName).Store(&owned); err != nil {
+		return false
+	}
+	return owned
+}
+
+// useLinkDNS returns true, when the tunnel DNS must be configured using the
+// link settings of systemd-resolved or NetworkManager
+func (l *vpnLink) useLinkDNS(cfg *config.Config) bool {
+	switch cfg.DNSMethod {
+	case "resolved", "nmcli":
+		return true
+	case "resolvconf":
+		return false
+	}
+	if l.resolvHandler.IsResolve() {
+		return true
+	}
+	// NetworkManager may work on top of systemd-resolved and has a higher
+	// priority
+	return !l.resolvHandler.IsNetworkManager() && !l.resolvHandler.IsShill() && resolvedRunning()
+}
+
+// setLinkDNS sets the VPN DNS servers and the routing domains on the tunnel
+// link
+func (l *vpnLink) setLinkDNS(cfg *config.Config, domains []string) error {
+	if cfg.DNSMethod == "nmcli" {
+		return l.setNMCLI(cfg, domains)
+	}
+	return l.setResolved(cfg, domains)
+}
+
+// revertLinkDNS drops the tunnel link DNS settings
+func (l *vpnLink) revertLinkDNS(cfg *config.Config) {
+	if cfg.DNSMethod == "nmcli" {
+		l.revertNMCLI()
+		return
+	}
+	l.revertResolved()
+}
+
+// setResolved sets the VPN DNS servers and the routing domains on the tunnel
+// link, empty domains route all DNS queries through the tunnel
+func (l *vpnLink) setResolved(cfg *config.Config, domains []string) error {
+	iface, err := net.InterfaceByName(l.name)
+	if err != nil {
+		return err
+	}
+
+	conn, err := systemBus()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	servers := DNSServers(cfg)
+	linkDNS := make([]resolvedDNS, 0, len(servers))
+	for _, s := range servers {
+		if v := s.To4(); v != nil {
+			linkDNS = append(linkDNS, resolvedDNS{Family: unix.AF_INET, Address: v})
+		} else {
+			linkDNS = append(linkDNS, resolvedDNS{Family: unix.AF_INET6, Address: s})
+		}
+	}
+
+	if len(domains) == 0 {
+		domains = []string{"."}
+	}
+	var linkDomains []resolvedDomain
+	for _, d := range domains {
+		// don't trim the global domain
+		if d != "." {
+			d = strings.TrimLeft(d, ".")
+		}
+		linkDomains = append(linkDomains, resolvedDomain{Domain: d, RoutingOnly: true})
+	}
+	for _, d := range cfg.F5Config.Object.DNSSuffix {
+		linkDomains = append(linkDomains, resolvedDomain{Domain: d})
+	}
+
+	obj := conn.Object(resolvedName, resolvedPath)
+	if err := obj.Call(resolvedManager+".SetLinkDNS", 0, int32(iface.Index), linkDNS).Store(); err != nil {
+		return fmt.Errorf("failed to set %q DNS servers: %s", servers, err)
+	}
+	if err := obj.Call(resolvedManager+".SetLinkDomains", 0, int32(iface.Index), linkDomains).Store(); err != nil {
+		return fmt.Errorf("failed to set search and routing domains: %+v: %s", linkDomains, err)
+	}
+
+	return nil
+}
+
+// revertResolved drops the tunnel link DNS settings
+func (l *vpnLink) revertResolved() {
+	iface, err := net.InterfaceByName(l.name)
+	if err != nil {
+		log.Printf("Failed to revert systemd-resolved link settings: %s", err)
+		return
+	}
+
+	conn, err := systemBus()
+	if err != nil {
+		log.Printf("Failed to revert systemd-resolved link settings: %s", err)
+		return
+	}
+	defer conn.Close()
+
+	if err := conn.Object(resolvedName, resolvedPath).Call(resolvedManager+".RevertLink", 0, int32(iface.Index)).Store(); err != nil {
+		log.Printf("Failed to revert systemd-resolved link settings: %s", err)
+	}
+}
@@ -0,0 +1,22 @@
+//go:build !linux
+// +build !linux
+
+package link
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/kayrus/gof5/pkg/config"
+)
+
+func (l *vpnLink) useLinkDNS(cfg *config.Config) bool {
+	return cfg.DNSMethod == "resolved" || cfg.DNSMethod == "nmcli"
+}
+
+func (l *vpnLink) setLinkDNS(cfg *config.Config, _ []string) error {
+	return fmt.Errorf("%q DNS method is not supported in %s", cfg.DNSMethod, runtime.GOOS)
+}
+
+func (l *vpnLink) revertLinkDNS(_ *config.Config) {
+}
@@ -0,0 +1,167 @@
+package link
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"runtime"
+	"strings"
+
+	"github.com/kayrus/gof5/pkg/config"
+	"github.com/kayrus/gof5/pkg/util"
+)
+
+// errServerRouteExists is returned, when the host route already exists, it
+// is neither added nor removed
+var errServerRouteExists = errors.New("route already exists")
+
+// errServerRouteUnsupported is returned, when the platform routes cannot be
+// looked up and added
+var errServerRouteUnsupported = errors.New("routes via the original gateway are not supported on this platform")
+
+// maxInstanceMetric limits the metrics of the same server routes of the
+// simultaneous instances
+const maxInstanceMetric = 16
+
+// serverRoute is a host route to the F5 server via the original gateway
+type serverRoute struct {
+	dst   *net.IPNet
+	gw    net.IP
+	index int
+	// metric separates the same routes of the simultaneous instances
+	metric int
+	// installed is true, when the route was added by gof5
+	installed bool
+}
+
+func (r *serverRoute) String() string {
+	name := fmt.Sprintf("%d", r.index)
+	if iface, err := net.InterfaceByIndex(r.index); err == nil {
+		name = iface.Name
+	}
+	s := fmt.Sprintf("%s dev %s", r.dst, name)
+	if r.gw != nil && !r.gw.IsUnspecified() {
+		s = fmt.Sprintf("%s via %s dev %s", r.dst, r.gw, name)
+	}
+	if r.metric > 0 {
+		s += fmt.Sprintf(" metric %d", r.metric)
+	}
+	return s
+}
+
+func hostNet(ip net.IP) *net.IPNet {
+	if v := ip.To4(); v != nil {
+		return &net.IPNet{IP: v, Mask: net.CIDRMask(32, 32)}
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+}
+
+// lookupServerRoutes captures the routes to every F5 server address, before
+// the tunnel routes are installed
+func (l *vpnLink) lookupServerRoutes(server string) {
+	if cname, err := net.LookupCNAME(server); err == nil {
+		if cname = strings.TrimSuffix(cname, "."); !strings.EqualFold(cname, server) && l.debug {
+			util.DebugLog.Printf("%s F5 server is an alias of %s", server, cname)
+		}
+	}
+
+	for _, ip := range l.serverIPs {
+		if ip.IsLoopback() {
+			continue
+		}
+		r, err := lookupServerRoute(ip)
+		if err == errServerRouteUnsupported {
+			// the addresses are excluded from the tunnel routes only
+			return
+		}
+		if err != nil {
+			log.Printf("Warning: failed to detect the route to %s F5 server address: %s", ip, err)
+			continue
+		}
+		if r == nil {
+			// a host route already exists or the lookup is not supported
+			continue
+		}
+		l.serverRoutes = append(l.serverRoutes, r)
+	}
+	if l.debug {
+		util.DebugLog.Printf("Resolved %s F5 server addresses: %q, server routes: %q", server, l.serverIPs, l.serverRoutes)
+	}
+}
+
+// lookupBypassRoutes captures the original routes to the excluded subnets,
+// before the tunnel routes are installed, the subnets without a route are
+// excluded from the tunnel routes only
+func lookupBypassRoutes(dsts []*net.IPNet) []*serverRoute {
+	var routes []*serverRoute
+	for _, dst := range dsts {
+		r, err := lookupServerRoute(dst.IP)
+		if err != nil {
+			log.Printf("Warning: failed to detect the route to %s excluded subnet, it is only excluded from the tunnel routes: %s", dst, err)
+			continue
+		}
+		if r == nil {
+			// the host route already exists
+			continue
+		}
+		r.dst = dst
+		routes = append(routes, r)
+	}
+	return routes
+}
+
+// pinServerRoutes adds the host routes to the F5 server and the routes to the
+// excluded subnets via the original gateway, thus the tunnel routes, e.g. a
+// full tunnel, cannot capture the tunnel connection itself and the excluded
+// traffic
+func (l *vpnLink) pinServerRoutes(cfg *config.Config) {
+	instance := instanceMetric(cfg)
+	pinRoutes(l.serverRoutes, "F5 server", instance)
+	pinRoutes(l.bypassRoutes, "excluded subnet", instance)
+}
+
+// instanceMetric reports, whether the routes are added with the next metric:
+// in Linux another instance may have added the same route, each instance adds
+// its own one and removes only it on exit
+func instanceMetric(cfg *config.Config) bool {
+	return cfg.Instance != "" && runtime.GOOS == "linux"
+}
+
+func pinRoutes(routes []*serverRoute, kind string, instance bool) {
+	for _, r := range routes {
+		err := addServerRoute(r)
+		for instance && err == errServerRouteExists && r.metric < maxInstanceMetric {
+			r.metric++
+			err = addServerRoute(r)
+		}
+		if err == errServerRouteExists {
+			continue
+		}
+		if err != nil {
+			log.Printf("Warning: failed to add %s %s route: %s", r, kind, err)
+			continue
+		}
+		r.installed = true
+		log.Printf("Added %s %s route", r, kind)
+	}
+}
+
+// unpinServerRoutes removes the F5 server and the excluded subnet routes,
+// added by gof5
+func (l *vpnLink) unpinServerRoutes() {
+	unpinRoutes(l.serverRoutes, "F5 server")
+	unpinRoutes(l.bypassRoutes, "excluded subnet")
+}
+
+func unpinRoutes(routes []*serverRoute, kind string) {
+	for _, r := range routes {
+		if !r.installed {
+			continue
+		}
+		if err := delServerRoute(r); err != nil {
+			log.Printf("Warning: failed to remove %s %s route: %s", r, kind, err)
+		}
+		r.installed = false
+	}
+}
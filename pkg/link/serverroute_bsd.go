@@ -0,0 +1,103 @@
+//go:build darwin || freebsd
+// +build darwin freebsd
+
+package link
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os/exec"
+	"strings"
+)
+
+func routeFamily(ip net.IP) string {
+	if ip.To4() != nil {
+		return "-inet"
+	}
+	return "-inet6"
+}
+
+// lookupServerRoute parses the "route get" output, nil is returned, when a
+// host route to the address already exists
+func lookupServerRoute(ip net.IP) (*serverRoute, error) {
+	out, err := exec.Command("route", "-n", "get", routeFamily(ip), ip.String()).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	var dst, gw, name string
+	s := bufio.NewScanner(strings.NewReader(string(out)))
+	for s.Scan() {
+		k, v, ok := strings.Cut(strings.TrimSpace(s.Text()), ":")
+		if !ok {
+			continue
+		}
+		switch v = strings.TrimSpace(v); k {
+		case "destination":
+			dst = v
+		case "gateway":
+			gw = v
+		case "interface":
+			name = v
+		}
+	}
+	if name == "" {
+		return nil, fmt.Errorf("failed to detect the route interface")
+	}
+	if net.ParseIP(dst).Equal(ip) {
+		return nil, nil
+	}
+
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to detect %s interface: %s", name, err)
+	}
+	// the on-link destination has no gateway IP
+	return &serverRoute{
+		dst:   hostNet(ip),
+		gw:    net.ParseIP(gw),
+		index: iface.Index,
+	}, nil
+}
+
+// routeDstArgs returns a host destination for the F5 server address and a
+// network destination for an excluded subnet
+func routeDstArgs(dst *net.IPNet) []string {
+	if ones, bits := dst.Mask.Size(); ones < bits {
+		return []string{"-net", dst.String()}
+	}
+	return []string{"-host", dst.IP.String()}
+}
+
+func serverRouteArgs(cmd string, r *serverRoute) ([]string, error) {
+	args := append([]string{"-n", cmd, routeFamily(r.dst.IP)}, routeDstArgs(r.dst)...)
+	if r.gw != nil {
+		return append(args, r.gw.String()), nil
+	}
+	iface, err := net.InterfaceByIndex(r.index)
+	if err != nil {
+		return nil, err
+	}
+	return append(args, "-interface", iface.Name), nil
+}
+
+func addServerRoute(r *serverRoute) error {
+	args, err := serverRouteArgs("add", r)
+	if err != nil {
+		return err
+	}
+	err = runCmd("route", args...)
+	if err != nil && strings.Contains(err.Error(), "File exists") {
+		return errServerRouteExists
+	}
+	return err
+}
+
+func delServerRoute(r *serverRoute) error {
+	args, err := serverRouteArgs("delete", r)
+	if err != nil {
+		return err
+	}
+	return runCmd("route", args...)
+}
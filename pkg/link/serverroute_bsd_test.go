@@ -0,0 +1,39 @@
+//go:build darwin || freebsd
+// +build darwin freebsd
+
+package link
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestServerRouteArgs(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		dst  string
+		gw   string
+		want []string
+	}{
+		{"F5 server", "192.0.2.1/32", "10.0.0.1", []string{"-n", "add", "-inet", "-host", "192.0.2.1", "10.0.0.1"}},
+		{"F5 server IPv6", "2001:db8::1/128", "fe80::1", []string{"-n", "add", "-inet6", "-host", "2001:db8::1", "fe80::1"}},
+		{"excluded subnet", "10.1.0.0/16", "10.0.0.1", []string{"-n", "add", "-inet", "-net", "10.1.0.0/16", "10.0.0.1"}},
+		{"excluded IPv6 subnet", "2001:db8::/48", "fe80::1", []string{"-n", "add", "-inet6", "-net", "2001:db8::/48", "fe80::1"}},
+	} {
+		_, dst, err := net.ParseCIDR(tc.dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v := dst.IP.To4(); v != nil {
+			dst.IP = v
+		}
+		args, err := serverRouteArgs("add", &serverRoute{dst: dst, gw: net.ParseIP(tc.gw)})
+		if err != nil {
+			t.Fatalf("%s: %s", tc.name, err)
+		}
+		if !reflect.DeepEqual(args, tc.want) {
+			t.Errorf("%s: got %q, want %q", tc.name, args, tc.want)
+		}
+	}
+}
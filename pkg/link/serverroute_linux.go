@@ -0,0 +1,51 @@
+package link
+
+import (
+	"errors"
+	"net"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// lookupServerRoute returns the route, which the kernel uses for the address
+func lookupServerRoute(ip net.IP) (*serverRoute, error) {
+	routes, err := netlink.RouteGet(ip)
+	if err != nil {
+		return nil, err
+	}
+	if len(routes) == 0 {
+		return nil, nil
+	}
+	return &serverRoute{
+		dst:   hostNet(ip),
+		gw:    routes[0].Gw,
+		index: routes[0].LinkIndex,
+	}, nil
+}
+
+func addServerRoute(r *serverRoute) error {
+	err := netlink.RouteAdd(&netlink.Route{
+		Dst:       r.dst,
+		Gw:        r.gw,
+		LinkIndex: r.index,
+		Priority:  r.metric,
+		Protocol:  unix.RTPROT_STATIC,
+	})
+	if errors.Is(err, unix.EEXIST) {
+		return errServerRouteExists
+	}
+	return err
+}
+
+// delServerRoute removes exactly the added route, the route of another
+// instance has another metric
+func delServerRoute(r *serverRoute) error {
+	return netlink.RouteDel(&netlink.Route{
+		Dst:       r.dst,
+		Gw:        r.gw,
+		LinkIndex: r.index,
+		Priority:  r.metric,
+		Protocol:  unix.RTPROT_STATIC,
+	})
+}
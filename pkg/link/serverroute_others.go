@@ -0,0 +1,22 @@
+//go:build !linux && !darwin && !freebsd && !windows
+// +build !linux,!darwin,!freebsd,!windows
+
+package link
+
+import (
+	"net"
+)
+
+// lookupServerRoute is not supported, the F5 server addresses and the excluded
+// subnets are excluded from the tunnel routes only
+func lookupServerRoute(_ net.IP) (*serverRoute, error) {
+	return nil, errServerRouteUnsupported
+}
+
+func addServerRoute(_ *serverRoute) error {
+	return nil
+}
+
+func delServerRoute(_ *serverRoute) error {
+	return nil
+}
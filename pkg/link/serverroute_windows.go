@@ -0,0 +1,65 @@
+package link
+
+import (
+	"net"
+
+	"golang.org/x/sys/windows"
+	"golang.zx2c4.com/wireguard/windows/tunnel/winipcfg"
+)
+
+// lookupServerRoute returns the best matching route of the forwarding table,
+// nil is returned, when a host route to the address already exists
+func lookupServerRoute(ip net.IP) (*serverRoute, error) {
+	family := winipcfg.AddressFamily(windows.AF_INET)
+	if ip.To4() == nil {
+		family = windows.AF_INET6
+	}
+	rows, err := winipcfg.GetIPForwardTable2(family)
+	if err != nil {
+		return nil, err
+	}
+
+	var best *winipcfg.MibIPforwardRow2
+	bestLen := -1
+	for i := range rows {
+		dst := rows[i].DestinationPrefix.IPNet()
+		if !dst.Contains(ip) {
+			continue
+		}
+		ones, _ := dst.Mask.Size()
+		if ones > bestLen || (ones == bestLen && rows[i].Metric < best.Metric) {
+			best, bestLen = &rows[i], ones
+		}
+	}
+	if best == nil {
+		return nil, nil
+	}
+	if _, bits := hostNet(ip).Mask.Size(); bestLen == bits {
+		return nil, nil
+	}
+	return &serverRoute{
+		dst:   hostNet(ip),
+		gw:    best.NextHop.IP(),
+		index: int(best.InterfaceIndex),
+	}, nil
+}
+
+func addServerRoute(r *serverRoute) error {
+	luid, err := winipcfg.LUIDFromIndex(uint32(r.index))
+	if err != nil {
+		return err
+	}
+	err = luid.AddRoute(*r.dst, r.gw, 0)
+	if err == windows.ERROR_OBJECT_ALREADY_EXISTS {
+		return errServerRouteExists
+	}
+	return err
+}
+
+func delServerRoute(r *serverRoute) error {
+	luid, err := winipcfg.LUIDFromIndex(uint32(r.index))
+	if err != nil {
+		return err
+	}
+	return luid.DeleteRoute(*r.dst, r.gw)
+}
@@ -0,0 +1,35 @@
+package link
+
+import (
+	"net"
+
+	"github.com/kayrus/gof5/pkg/config"
+)
+
+// SourceDialer returns the dialer of the F5 server connections, bound to the
+// configured source address and interface, network is "tcp" or "udp"
+func SourceDialer(cfg *config.Config, network string) (*net.Dialer, error) {
+	dialer := &net.Dialer{
+		Timeout: cfg.DialTimeout,
+	}
+
+	if cfg.SourceAddress != "" {
+		// the address is validated by the config
+		ip := net.ParseIP(cfg.SourceAddress)
+		if network == "udp" {
+			dialer.LocalAddr = &net.UDPAddr{IP: ip}
+		} else {
+			dialer.LocalAddr = &net.TCPAddr{IP: ip}
+		}
+	}
+
+	if cfg.SourceInterface != "" {
+		control, err := bindToInterface(cfg.SourceInterface)
+		if err != nil {
+			return nil, err
+		}
+		dialer.Control = control
+	}
+
+	return dialer, nil
+}
@@ -0,0 +1,157 @@
+package link
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/kayrus/gof5/pkg/metrics"
+	"github.com/kayrus/gof5/pkg/util"
+)
+
+// ErrIdleTimeout terminates the tunnel, when no packets pass it within the
+// idleTimeout
+var ErrIdleTimeout = errors.New("no tunnel traffic within the idle timeout")
+
+// linkStats counts the traffic of a single tunnel session
+type linkStats struct {
+	bytesIn    atomic.Uint64
+	bytesOut   atomic.Uint64
+	packetsIn  atomic.Uint64
+	packetsOut atomic.Uint64
+	// unix nano time of the last received packet and the last keepalive
+	lastRecv      atomic.Int64
+	lastKeepalive atomic.Int64
+	keepalive     atomic.Int64
+}
+
+// touch records the time of the last packet, received from the tunnel
+func (l *vpnLink) touch() {
+	l.stats.lastRecv.Store(time.Now().UnixNano())
+}
+
+// keepaliveSent records the time of the sent keepalive echo
+func (l *vpnLink) keepaliveSent(interval time.Duration) {
+	l.stats.keepalive.Store(int64(interval))
+	l.stats.lastKeepalive.Store(time.Now().UnixNano())
+}
+
+// Health returns false, when the previous keepalive echo got no reply within
+// the keepalive interval, and the time since the last received packet
+func (l *vpnLink) Health() (bool, time.Duration) {
+	last := l.stats.lastRecv.Load()
+	since := time.Since(time.Unix(0, last))
+	sent := l.stats.lastKeepalive.Load()
+	if sent == 0 {
+		// keepalive is disabled or not sent yet
+		return true, since
+	}
+	return last+l.stats.keepalive.Load() >= sent, since
+}
+
+// countIn counts the bytes, received from the tunnel, packets are not counted
+// by the pppd driver, it handles a raw HDLC stream
+func (l *vpnLink) countIn(n int, packet bool) {
+	l.touch()
+	l.stats.bytesIn.Add(uint64(n))
+	metrics.BytesIn.Add(float64(n))
+	if packet {
+		l.stats.packetsIn.Add(1)
+		metrics.PacketsIn.Inc()
+	}
+}
+
+// countOut counts the bytes, sent to the tunnel
+func (l *vpnLink) countOut(n int, packet bool) {
+	l.stats.bytesOut.Add(uint64(n))
+	metrics.BytesOut.Add(float64(n))
+	if packet {
+		l.stats.packetsOut.Add(1)
+		metrics.PacketsOut.Inc()
+	}
+}
+
+// Bytes returns the session totals of the received and sent bytes
+func (l *vpnLink) Bytes() (uint64, uint64) {
+	return l.stats.bytesIn.Load(), l.stats.bytesOut.Load()
+}
+
+// WatchIdle terminates the tunnel with the ErrIdleTimeout, when no packets
+// pass the tunnel within the timeout, the keepalive echoes are not counted as
+// packets
+func (l *vpnLink) WatchIdle(timeout time.Duration) {
+	select {
+	case <-l.Established:
+	case <-l.TunDown:
+		return
+	}
+
+	ticker := time.NewTicker(timeout / 10)
+	defer ticker.Stop()
+
+	var prev uint64
+	last := time.Now()
+	for {
+		select {
+		case <-l.TunDown:
+			return
+		case now := <-ticker.C:
+			if v := l.stats.packetsIn.Load() + l.stats.packetsOut.Load(); v != prev {
+				prev, last = v, now
+				continue
+			}
+			if now.Sub(last) >= timeout {
+				log.Printf("No tunnel traffic within %s, disconnecting", timeout)
+				l.ErrChan <- ErrIdleTimeout
+				return
+			}
+		}
+	}
+}
+
+// LogThroughput periodically logs the tunnel throughput and the session
+// totals into the debug log
+func (l *vpnLink) LogThroughput(interval time.Duration) {
+	select {
+	case <-l.Established:
+	case <-l.TunDown:
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var prevIn, prevOut uint64
+	prev := time.Now()
+	for {
+		select {
+		case <-l.TunDown:
+			return
+		case now := <-ticker.C:
+			in, out := l.stats.bytesIn.Load(), l.stats.bytesOut.Load()
+			sec := now.Sub(prev).Seconds()
+			util.DebugLog.Printf("%s throughput: in %s/s, out %s/s, session total: in %s (%d packets), out %s (%d packets)",
+				l.name,
+				formatBytes(float64(in-prevIn)/sec), formatBytes(float64(out-prevOut)/sec),
+				formatBytes(float64(in)), l.stats.packetsIn.Load(),
+				formatBytes(float64(out)), l.stats.packetsOut.Load(),
+			)
+			prevIn, prevOut, prev = in, out, now
+		}
+	}
+}
+
+func formatBytes(v float64) string {
+	const unit = 1024
+	if v < unit {
+		return fmt.Sprintf("%.0f B", v)
+	}
+	exp := 0
+	for v >= unit*unit && exp < 3 {
+		v /= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", v/unit, "KMGT"[exp])
+}
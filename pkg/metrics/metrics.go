@@ -0,0 +1,90 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "gof5"
+
+var (
+	BytesIn = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "received_bytes_total",
+		Help:      "Bytes received from the tunnel.",
+	})
+	BytesOut = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sent_bytes_total",
+		Help:      "Bytes sent to the tunnel.",
+	})
+	// packets are not counted by the pppd driver, it handles a raw HDLC stream
+	PacketsIn = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "received_packets_total",
+		Help:      "Packets received from the tunnel.",
+	})
+	PacketsOut = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sent_packets_total",
+		Help:      "Packets sent to the tunnel.",
+	})
+	Reconnects = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reconnects_total",
+		Help:      "Reconnect attempts.",
+	})
+	Connected = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "connected",
+		Help:      "Whether the VPN connection is established.",
+	})
+	ConnectionStartTime = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "connection_start_time_seconds",
+		Help:      "Unix time, when the VPN connection was established.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(
+		BytesIn,
+		BytesOut,
+		PacketsIn,
+		PacketsOut,
+		Reconnects,
+		Connected,
+		ConnectionStartTime,
+	)
+}
+
+// Start serves the metrics endpoint on the specified address, the returned
+// function stops the server
+func Start(listen string) (func(), error) {
+	l, err := net.Listen("tcp", listen)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set metrics listener: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	srv := &http.Server{Handler: mux}
+
+	log.Printf("Serving metrics on http://%s/metrics", l.Addr())
+	go func() {
+		if err := srv.Serve(l); err != nil && err != http.ErrServerClosed {
+			log.Printf("Failed to serve metrics: %v", err)
+		}
+	}()
+
+	return func() {
+		log.Printf("Shutting down metrics server")
+		srv.Shutdown(context.Background())
+	}, nil
+}
@@ -0,0 +1,81 @@
+package systemd
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	Ready    = "READY=1"
+	Stopping = "STOPPING=1"
+	Watchdog = "WATCHDOG=1"
+)
+
+// Notify sends the state to the systemd notification socket, it is a noop,
+// when the NOTIFY_SOCKET environment variable is not set
+func Notify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+
+	// "@" prefixed names are handled by Go as abstract sockets
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("failed to connect to systemd notification socket: %s", err)
+	}
+	defer conn.Close()
+
+	if _, err = conn.Write([]byte(state)); err != nil {
+		return fmt.Errorf("failed to send %q systemd notification: %s", state, err)
+	}
+
+	return nil
+}
+
+// WatchdogInterval returns the systemd watchdog timeout, zero is returned,
+// when the watchdog is disabled
+func WatchdogInterval() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		// watchdog is intended for another process
+		return 0
+	}
+
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+
+	return time.Duration(usec) * time.Microsecond
+}
+
+// StartWatchdog sends watchdog keepalives twice per watchdog timeout, the
+// returned function stops them
+func StartWatchdog() func() {
+	interval := WatchdogInterval()
+	if interval == 0 || os.Getenv("NOTIFY_SOCKET") == "" {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := Notify(Watchdog); err != nil {
+					log.Printf("Warning: %s", err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
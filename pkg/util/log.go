@@ -0,0 +1,121 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+var (
+	// DebugLog is used for messages, enabled by the debug option
+	DebugLog = log.New(os.Stderr, "", log.LstdFlags)
+	// ColorLog is used for highlighted messages
+	ColorLog = log.New(color.Error, "", log.LstdFlags)
+
+	logMu     sync.Mutex
+	logJSON   bool
+	logOutput io.Writer = os.Stderr
+	logFields           = map[string]string{}
+)
+
+// SetLogFormat sets the "text" or "json" logs format
+func SetLogFormat(format string) error {
+	logMu.Lock()
+	defer logMu.Unlock()
+
+	switch format {
+	case "text", "":
+		logJSON = false
+	case "json":
+		logJSON = true
+		// no escape sequences inside JSON messages
+		color.NoColor = true
+	default:
+		return fmt.Errorf("unsupported %q log format, supported formats are: text, json", format)
+	}
+	applyLogOutput()
+
+	return nil
+}
+
+// SetLogOutput sets the output destination for all loggers
+func SetLogOutput(w io.Writer) {
+	logMu.Lock()
+	defer logMu.Unlock()
+
+	logOutput = w
+	applyLogOutput()
+}
+
+// SetLogField sets a contextual field, added to every JSON log entry
+func SetLogField(key, value string) {
+	logMu.Lock()
+	defer logMu.Unlock()
+
+	logFields[key] = value
+}
+
+func applyLogOutput() {
+	if logJSON {
+		for _, v := range []struct {
+			*log.Logger
+			level string
+		}{
+			{log.Default(), "info"},
+			{DebugLog, "debug"},
+			{ColorLog, "info"},
+		} {
+			v.SetFlags(0)
+			v.SetOutput(&jsonWriter{out: logOutput, level: v.level})
+		}
+		return
+	}
+
+	log.SetFlags(log.LstdFlags)
+	log.SetOutput(logOutput)
+	DebugLog.SetFlags(log.LstdFlags)
+	DebugLog.SetOutput(logOutput)
+	ColorLog.SetFlags(log.LstdFlags)
+	if logOutput == os.Stderr {
+		ColorLog.SetOutput(color.Error)
+	} else {
+		ColorLog.SetOutput(logOutput)
+	}
+}
+
+// jsonWriter writes every log line as a JSON object
+type jsonWriter struct {
+	out   io.Writer
+	level string
+}
+
+func (w *jsonWriter) Write(p []byte) (int, error) {
+	entry := map[string]string{
+		"ts":    time.Now().Format(time.RFC3339Nano),
+		"level": w.level,
+		"msg":   string(bytes.TrimRight(p, "\n")),
+	}
+
+	logMu.Lock()
+	for k, v := range logFields {
+		entry[k] = v
+	}
+	logMu.Unlock()
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return 0, err
+	}
+	if _, err = w.out.Write(append(data, '\n')); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
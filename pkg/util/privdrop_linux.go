@@ -0,0 +1,107 @@
+//go:build linux
+// +build linux
+
+package util
+
+import (
+	"fmt"
+	"runtime"
+	"sync/atomic"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// retainedCaps are kept by the privileged thread for the teardown: the
+// routes removal and the resolv.conf, PID and state files restore
+var retainedCaps = []uint{
+	unix.CAP_NET_ADMIN,
+	unix.CAP_DAC_OVERRIDE,
+	unix.CAP_CHOWN,
+	unix.CAP_FOWNER,
+}
+
+// privileged receives the funcs, executed on the privileged thread, it is
+// set, when the privileges are dropped
+var privileged atomic.Pointer[chan func()]
+
+// DropPrivileges switches the process to the uid and gid. Only a dedicated
+// OS thread keeps the capabilities, required for the teardown, the funcs are
+// executed there using Privileged.
+func DropPrivileges(uid, gid int) error {
+	if privileged.Load() != nil {
+		return nil
+	}
+
+	funcs := make(chan func())
+	errChan := make(chan error, 1)
+	go func() {
+		// the thread is never unlocked, the Go runtime doesn't clone the
+		// locked threads, thus the new threads don't inherit the
+		// capabilities; on error the thread terminates with the goroutine
+		runtime.LockOSThread()
+		if err := dropPrivileges(uid, gid); err != nil {
+			errChan <- err
+			return
+		}
+		errChan <- nil
+		for f := range funcs {
+			f()
+		}
+	}()
+	if err := <-errChan; err != nil {
+		return err
+	}
+	privileged.Store(&funcs)
+
+	return nil
+}
+
+func dropPrivileges(uid, gid int) error {
+	// keep the permitted capabilities of the current thread after setuid
+	if err := unix.Prctl(unix.PR_SET_KEEPCAPS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("failed to keep the capabilities: %s", err)
+	}
+	// the syscall package applies the IDs to all threads
+	if err := syscall.Setgroups([]int{gid}); err != nil {
+		return fmt.Errorf("failed to set the supplementary groups: %s", err)
+	}
+	if err := syscall.Setgid(gid); err != nil {
+		return fmt.Errorf("failed to set %d GID: %s", gid, err)
+	}
+	if err := syscall.Setuid(uid); err != nil {
+		return fmt.Errorf("failed to set %d UID: %s", uid, err)
+	}
+
+	// setuid clears the effective capabilities, limit the permitted ones to
+	// the retained capabilities and raise them
+	hdr := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	var data [2]unix.CapUserData
+	for _, v := range retainedCaps {
+		data[v/32].Permitted |= 1 << (v % 32)
+		data[v/32].Effective |= 1 << (v % 32)
+	}
+	if err := unix.Capset(&hdr, &data[0]); err != nil {
+		return fmt.Errorf("failed to set the capabilities: %s", err)
+	}
+
+	return nil
+}
+
+// Privileged executes the func on the thread, which keeps the capabilities
+// after the privileges are dropped, and waits for it; the func is executed
+// directly, when the privileges are not dropped. The func must not call
+// Privileged.
+func Privileged(f func()) {
+	funcs := privileged.Load()
+	if funcs == nil {
+		f()
+		return
+	}
+	done := make(chan struct{})
+	*funcs <- func() {
+		defer close(done)
+		f()
+	}
+	<-done
+}
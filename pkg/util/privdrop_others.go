@@ -0,0 +1,16 @@
+//go:build !linux
+// +build !linux
+
+package util
+
+import "fmt"
+
+// DropPrivileges is supported only in Linux
+func DropPrivileges(uid, gid int) error {
+	return fmt.Errorf("dropping privileges is supported only in Linux")
+}
+
+// Privileged executes the func
+func Privileged(f func()) {
+	f()
+}
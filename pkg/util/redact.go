@@ -0,0 +1,93 @@
+package util
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Redacted replaces the credentials in the debug output
+const Redacted = "***"
+
+var (
+	// redactParams are the form and URL query parameters with the
+	// credentials, one-time tokens and the session ID
+	redactParams = []string{"password", "password1", "_F5_challenge", "otp", "token", "passcode", "sess"}
+	// redactCookies are the F5 session cookies
+	redactCookies = []string{"MRHSession", "LastMRH_Session"}
+	// redactHeaders are replaced entirely
+	redactHeaders = []string{"Authorization", "Proxy-Authorization"}
+	// the session ID in the connection options XML
+	sessionIDRe = regexp.MustCompile(`(?i)(<Session_ID>)[^<]*(</Session_ID>)`)
+)
+
+// RedactHeaders returns a copy of the HTTP headers with the authorization
+// headers and the session cookie values replaced
+func RedactHeaders(h http.Header) http.Header {
+	res := make(http.Header, len(h))
+	for k, v := range h {
+		v = append([]string(nil), v...)
+		switch {
+		case StrSliceContains(redactHeaders, k):
+			for i := range v {
+				v[i] = Redacted
+			}
+		case k == "Cookie":
+			for i := range v {
+				v[i] = redactPairs(v[i], ";", redactCookies)
+			}
+		case k == "Set-Cookie":
+			for i := range v {
+				// the cookie attributes follow the first pair
+				cookie, attrs, _ := strings.Cut(v[i], ";")
+				v[i] = redactPairs(cookie, ";", redactCookies)
+				if attrs != "" {
+					v[i] += ";" + attrs
+				}
+			}
+		}
+		res[k] = v
+	}
+	return res
+}
+
+// RedactQuery replaces the credentials in the URL encoded form or query
+func RedactQuery(s string) string {
+	return redactPairs(s, "&", redactParams)
+}
+
+// RedactURL replaces the credentials in the URL query
+func RedactURL(u *url.URL) string {
+	if u.RawQuery == "" {
+		return u.String()
+	}
+	v := *u
+	v.RawQuery = RedactQuery(u.RawQuery)
+	return v.String()
+}
+
+// RedactBody replaces the credentials in the request or response body
+func RedactBody(s string) string {
+	return sessionIDRe.ReplaceAllString(RedactQuery(s), "${1}"+Redacted+"${2}")
+}
+
+// redactPairs replaces the values of the sensitive keys in the sep
+// separated key=value pairs, the rest is kept as is
+func redactPairs(s, sep string, keys []string) string {
+	pairs := strings.Split(s, sep)
+	for i, p := range pairs {
+		k, _, ok := strings.Cut(p, "=")
+		if !ok {
+			continue
+		}
+		name := strings.TrimSpace(k)
+		if u, err := url.QueryUnescape(name); err == nil {
+			name = u
+		}
+		if StrSliceContains(keys, name) {
+			pairs[i] = k + "=" + Redacted
+		}
+	}
+	return strings.Join(pairs, sep)
+}
@@ -0,0 +1,46 @@
+package util
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestRedactHeaders(t *testing.T) {
+	h := http.Header{
+		"Authorization": {"Basic dXNlcjpwYXNz"},
+		"Cookie":        {"F5_ST=1z1z1z; MRHSession=secret; LastMRH_Session=abc"},
+		"Set-Cookie":    {"MRHSession=secret; path=/; secure", "TIN=273000; path=/"},
+		"User-Agent":    {"gof5"},
+	}
+	expected := http.Header{
+		"Authorization": {"***"},
+		"Cookie":        {"F5_ST=1z1z1z; MRHSession=***; LastMRH_Session=***"},
+		"Set-Cookie":    {"MRHSession=***; path=/; secure", "TIN=273000; path=/"},
+		"User-Agent":    {"gof5"},
+	}
+	if v := RedactHeaders(h); !reflect.DeepEqual(v, expected) {
+		t.Errorf("Redacted headers %q don't correspond to expected %q", v, expected)
+	}
+	if v := h.Get("Cookie"); v != "F5_ST=1z1z1z; MRHSession=secret; LastMRH_Session=abc" {
+		t.Errorf("Original headers are modified: %q", v)
+	}
+}
+
+func TestRedactBody(t *testing.T) {
+	for body, expected := range map[string]string{
+		"username=user&password=p%26ss&vhost=standard":        "username=user&password=***&vhost=standard",
+		"_F5_challenge=123456&vhost=standard":                 "_F5_challenge=***&vhost=standard",
+		"<Session_ID>secret</Session_ID><DNS0>1.1.1.1</DNS0>": "<Session_ID>***</Session_ID><DNS0>1.1.1.1</DNS0>",
+	} {
+		if v := RedactBody(body); v != expected {
+			t.Errorf("Redacted body %q doesn't correspond to expected %q", v, expected)
+		}
+	}
+
+	u, _ := url.Parse("https://f5.example.com/myvpn?sess=secret&hostname=aG9zdA%3D%3D")
+	if v, expected := RedactURL(u), "https://f5.example.com/myvpn?sess=***&hostname=aG9zdA%3D%3D"; v != expected {
+		t.Errorf("Redacted URL %q doesn't correspond to expected %q", v, expected)
+	}
+}
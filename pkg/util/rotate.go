@@ -0,0 +1,120 @@
+package util
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// RotateWriter is a log file writer, which rotates the file, when it exceeds
+// the max size
+type RotateWriter struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	mode       os.FileMode
+	file       *os.File
+	size       int64
+}
+
+// NewRotateWriter opens the log file with the mode for appending, zero
+// maxSize disables the rotation
+func NewRotateWriter(path string, maxSize int64, maxBackups int, mode os.FileMode) (*RotateWriter, error) {
+	if err := os.MkdirAll(filepath.Dir(path), DirMode(mode)); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %s", err)
+	}
+
+	w := &RotateWriter{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+		mode:       mode,
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *RotateWriter) open() error {
+	f, err := OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, w.mode)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %s", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to stat log file: %s", err)
+	}
+	w.file = f
+	w.size = info.Size()
+	return nil
+}
+
+// rotate renames the log file with a numeric suffix and opens a fresh one,
+// the oldest backup with the maxBackups suffix is overwritten. When the file
+// cannot be renamed, e.g. in a root owned directory after the privileges are
+// dropped, the current file is reopened and the rotation is disabled.
+func (w *RotateWriter) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+
+	if err := w.shift(); err != nil {
+		if e := w.open(); e != nil {
+			return e
+		}
+		w.maxSize = 0
+		// the logger cannot be used, it writes into this file
+		fmt.Fprintf(w.file, "Warning: failed to rotate log file, the rotation is disabled: %s\n", err)
+		return nil
+	}
+
+	return w.open()
+}
+
+// shift renames the log file and the backups
+func (w *RotateWriter) shift() error {
+	if w.maxBackups > 0 {
+		for i := w.maxBackups - 1; i > 0; i-- {
+			src := fmt.Sprintf("%s.%d", w.path, i)
+			if _, err := os.Stat(src); err == nil {
+				if err := os.Rename(src, fmt.Sprintf("%s.%d", w.path, i+1)); err != nil {
+					return err
+				}
+			}
+		}
+		if err := os.Rename(w.path, w.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(w.path); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Write writes the data to the log file and rotates it if necessary
+func (w *RotateWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.maxSize > 0 && w.size > 0 && w.size+int64(len(p)) > w.maxSize {
+		if err := w.rotate(); err != nil {
+			return 0, fmt.Errorf("failed to rotate log file: %s", err)
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Close closes the log file
+func (w *RotateWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.file.Close()
+}
@@ -0,0 +1,68 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRotateWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gof5.log")
+	w, err := NewRotateWriter(path, 10, 2, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	for _, v := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := w.Write([]byte(v)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for file, expected := range map[string]string{
+		path:        "fourth\n",
+		path + ".1": "third\n",
+		path + ".2": "second\n",
+	} {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != expected {
+			t.Errorf("expected %q in %s, got %q", expected, file, data)
+		}
+	}
+
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("expected no %s.3 backup, got: %v", path, err)
+	}
+}
+
+func TestRotateWriterFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gof5.log")
+	// the log file cannot be renamed into the directory
+	if err := os.MkdirAll(filepath.Join(path+".1", "dir"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	w, err := NewRotateWriter(path, 10, 1, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	for _, v := range []string{"first\n", "second\n", "third\n"} {
+		if _, err := w.Write([]byte(v)); err != nil {
+			t.Fatalf("write after the failed rotation: %s", err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), "first\nWarning: failed to rotate log file") || !strings.HasSuffix(string(data), "second\nthird\n") {
+		t.Errorf("unexpected %q log content", data)
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !windows
+// +build !windows
+
+package util
+
+import (
+	"io"
+	"log/syslog"
+)
+
+// NewSyslogWriter returns a writer to the local syslog daemon
+func NewSyslogWriter() (io.Writer, error) {
+	return syslog.New(syslog.LOG_DAEMON|syslog.LOG_INFO, "gof5")
+}
@@ -0,0 +1,11 @@
+package util
+
+import (
+	"fmt"
+	"io"
+)
+
+// NewSyslogWriter is not supported in Windows
+func NewSyslogWriter() (io.Writer, error) {
+	return nil, fmt.Errorf("syslog is not supported on Windows")
+}
@@ -0,0 +1,105 @@
+package util
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+var (
+	traceEnabled atomic.Bool
+	tracer       struct {
+		sync.Mutex
+		start  time.Time
+		phases []*tracePhase
+	}
+)
+
+type tracePhase struct {
+	name  string
+	count int
+	total time.Duration
+}
+
+// EnableTrace enables the connection phase trace, set by the --trace flag
+func EnableTrace() {
+	traceEnabled.Store(true)
+	TraceStart()
+}
+
+// TraceEnabled reports, whether the connection phase trace is enabled
+func TraceEnabled() bool {
+	return traceEnabled.Load()
+}
+
+// TraceStart resets the traced phases, it is called on every connection
+// attempt
+func TraceStart() {
+	tracer.Lock()
+	defer tracer.Unlock()
+	tracer.start = time.Now()
+	tracer.phases = nil
+}
+
+// TracePhase starts the phase, the returned func logs the phase duration
+func TracePhase(name string) func() {
+	if !traceEnabled.Load() {
+		return func() {}
+	}
+	start := time.Now()
+	return func() {
+		TraceRecord(name, time.Since(start))
+	}
+}
+
+// TraceRecord logs the phase duration and adds it to the summary, the same
+// phase may be recorded several times, e.g. on the second logon request
+func TraceRecord(name string, d time.Duration) {
+	if !traceEnabled.Load() {
+		return
+	}
+	tracer.Lock()
+	defer tracer.Unlock()
+
+	log.Printf("Trace: +%s %s took %s", time.Since(tracer.start).Round(time.Millisecond), name, d.Round(time.Microsecond))
+	for _, v := range tracer.phases {
+		if v.name == name {
+			v.count++
+			v.total += d
+			return
+		}
+	}
+	tracer.phases = append(tracer.phases, &tracePhase{name: name, count: 1, total: d})
+}
+
+// TraceSummary logs the table of the traced phases in the order of their
+// first occurrence and resets them, it is a no-op without the new phases
+func TraceSummary() {
+	if !traceEnabled.Load() {
+		return
+	}
+	tracer.Lock()
+	defer tracer.Unlock()
+	if len(tracer.phases) == 0 {
+		return
+	}
+
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PHASE\tCOUNT\tDURATION")
+	for _, v := range tracer.phases {
+		fmt.Fprintf(w, "%s\t%d\t%s\n", v.name, v.count, v.total.Round(time.Microsecond))
+	}
+	fmt.Fprintf(w, "total\t\t%s\n", time.Since(tracer.start).Round(time.Microsecond))
+	w.Flush()
+	tracer.phases = nil
+
+	log.Printf("Trace summary:")
+	for _, v := range strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n") {
+		log.Printf("Trace: %s", v)
+	}
+}
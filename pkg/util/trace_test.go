@@ -0,0 +1,39 @@
+package util
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTraceSummary(t *testing.T) {
+	var buf bytes.Buffer
+	orig := log.Writer()
+	log.SetOutput(&buf)
+	defer log.SetOutput(orig)
+
+	EnableTrace()
+	defer traceEnabled.Store(false)
+
+	TraceRecord("logon POST", time.Second)
+	TraceRecord("tls handshake", time.Millisecond)
+	TraceRecord("logon POST", time.Second)
+	TraceSummary()
+
+	out := buf.String()
+	if !strings.Contains(out, "logon POST     2      2s") {
+		t.Errorf("logon POST phases are not summed:\n%s", out)
+	}
+	if strings.Index(out, "logon POST     2") > strings.Index(out, "tls handshake  1") {
+		t.Errorf("unexpected summary order:\n%s", out)
+	}
+
+	// the summary resets the phases
+	buf.Reset()
+	TraceSummary()
+	if buf.Len() != 0 {
+		t.Errorf("unexpected second summary:\n%s", buf.String())
+	}
+}
@@ -1,5 +1,13 @@
 package util
 
+import "os"
+
+// DirMode returns the mode of a directory, containing the files with the
+// mode: the search bit is added for every read bit, e.g. 0600 becomes 0700
+func DirMode(mode os.FileMode) os.FileMode {
+	return mode | (mode&0444)>>2
+}
+
 func SplitFunc(c rune) bool {
 	return c == ' ' || c == '\n' || c == '\r'
 }
@@ -12,3 +20,40 @@ func StrSliceContains(haystack []string, needle string) bool {
 	}
 	return false
 }
+
+// OpenFile opens the file like os.OpenFile, the permissions of an existing
+// file, which are not allowed by the mode, are removed, thus a tighter mode
+// applies to the files of the previous runs; the umask still applies to the
+// new files
+func OpenFile(path string, flag int, mode os.FileMode) (*os.File, error) {
+	f, err := os.OpenFile(path, flag, mode)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if perm := info.Mode().Perm(); perm&^mode != 0 {
+		if err = f.Chmod(perm & mode); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// WriteFile writes the file like os.WriteFile, the permissions of an
+// existing file are tightened like in OpenFile
+func WriteFile(path string, data []byte, mode os.FileMode) error {
+	f, err := OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	return err
+}